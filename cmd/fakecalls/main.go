@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCalls is how many calls are kept for GET /calls
+const maxCalls = 100
+
+// call is a call placed against the fake
+type call struct {
+	Sid       string    `json:"sid"`
+	To        string    `json:"to"`
+	From      string    `json:"from"`
+	Status    string    `json:"status"`
+	Spoken    string    `json:"spoken"`
+	TwiML     string    `json:"twiml"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// twilioError mirrors the error body of the Twilio REST API
+type twilioError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+// fakeCalls is a local stand-in for the Twilio Calls API. It accepts calls
+// with inline TwiML, logs what would be spoken and keeps recent calls for
+// inspection.
+type fakeCalls struct {
+	failing map[string]bool
+	mu      sync.Mutex
+	calls   []call
+}
+
+func main() {
+	// Parse command line flags
+	addr := flag.String("addr", ":8089", "Address to listen on")
+	fail := flag.String("fail", "", "Comma separated numbers whose calls are rejected, to test retries")
+	flag.Parse()
+
+	f := &fakeCalls{failing: make(map[string]bool)}
+	for _, number := range strings.Split(*fail, ",") {
+		if number = strings.TrimSpace(number); number != "" {
+			f.failing[number] = true
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /2010-04-01/Accounts/{sid}/Calls.json", f.createCall)
+	mux.HandleFunc("GET /calls", f.listCalls)
+
+	fmt.Printf("Fake Twilio Calls API listening on %s; set voice.base_url to http://localhost%s\n", *addr, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+// createCall handles POST /2010-04-01/Accounts/{sid}/Calls.json
+func (f *fakeCalls) createCall(w http.ResponseWriter, r *http.Request) {
+	accountSID := r.PathValue("sid")
+	if user, _, ok := r.BasicAuth(); !ok || user != accountSID {
+		writeError(w, http.StatusUnauthorized, 20003, "Authenticate")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, 20001, "Invalid form body")
+		return
+	}
+
+	to, from, twiml := r.PostForm.Get("To"), r.PostForm.Get("From"), r.PostForm.Get("Twiml")
+	switch {
+	case to == "":
+		writeError(w, http.StatusBadRequest, 21201, "No 'To' number is specified")
+		return
+	case from == "":
+		writeError(w, http.StatusBadRequest, 21213, "No 'From' number is specified")
+		return
+	case twiml == "":
+		writeError(w, http.StatusBadRequest, 21205, "Either a 'Url' or 'Twiml' must be provided")
+		return
+	case f.failing[to]:
+		writeError(w, http.StatusBadRequest, 13224, "The phone number you are attempting to call is not valid")
+		return
+	}
+
+	spoken, err := spokenText(twiml)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 12100, "Document parse failure: "+err.Error())
+		return
+	}
+
+	c := call{
+		Sid:       newSid(),
+		To:        to,
+		From:      from,
+		Status:    "queued",
+		Spoken:    spoken,
+		TwiML:     twiml,
+		CreatedAt: time.Now().UTC(),
+	}
+	f.mu.Lock()
+	f.calls = append([]call{c}, f.calls...)
+	if len(f.calls) > maxCalls {
+		f.calls = f.calls[:maxCalls]
+	}
+	f.mu.Unlock()
+
+	log.Printf("Call %s to %s: %s", c.Sid, c.To, c.Spoken)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"sid":         c.Sid,
+		"account_sid": accountSID,
+		"to":          c.To,
+		"from":        c.From,
+		"status":      c.Status,
+	})
+}
+
+// listCalls handles GET /calls, optionally filtered with ?to=
+func (f *fakeCalls) listCalls(w http.ResponseWriter, r *http.Request) {
+	to := r.URL.Query().Get("to")
+
+	f.mu.Lock()
+	calls := make([]call, 0, len(f.calls))
+	for _, c := range f.calls {
+		if to == "" || c.To == to {
+			calls = append(calls, c)
+		}
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"calls": calls})
+}
+
+// spokenText returns the text of every <Say> verb in a TwiML document
+func spokenText(twiml string) (string, error) {
+	var doc struct {
+		XMLName xml.Name `xml:"Response"`
+		Says    []string `xml:"Say"`
+	}
+	if err := xml.Unmarshal([]byte(twiml), &doc); err != nil {
+		return "", err
+	}
+	return strings.Join(doc.Says, " "), nil
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(twilioError{Code: code, Message: message, Status: status})
+}
+
+// newSid returns a random call SID in Twilio's format
+func newSid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "CA" + hex.EncodeToString(b)
+}
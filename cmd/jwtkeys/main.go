@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+func main() {
+	// Parse command line flags
+	keyringPath := flag.String("keyring", "", "Keyring file (defaults to jwt.keyring_file)")
+	list := flag.Bool("list", false, "List the keys in the keyring")
+	importKey := flag.Bool("import", false, "Import the key configured under jwt so existing tokens stay valid")
+	add := flag.Bool("add", false, "Add a new verify-only signing key")
+	alg := flag.String("alg", "", "Algorithm of added keys: HS256, RS256, ES256 or EdDSA (defaults to jwt.algorithm)")
+	promote := flag.String("promote", "", "Make the key with this ID the active signing key")
+	retire := flag.Bool("retire", false, "Retire keys whose tokens have all expired")
+	rotate := flag.Bool("rotate", false, "Run scheduled rotation: add, promote and retire keys as due")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := *keyringPath
+	if path == "" {
+		path = cfg.JWT.KeyringFile
+	}
+	if path == "" {
+		fmt.Println("Error: set jwt.keyring_file or pass -keyring")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *alg == "" {
+		*alg = cfg.JWT.Algorithm
+	}
+
+	keyring, err := auth.LoadKeyring(path)
+	if err != nil {
+		fmt.Printf("Failed to load keyring: %v\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now().UTC()
+	changed := true
+
+	switch {
+	case *importKey:
+		key, err := keyring.Import(cfg, now)
+		if err != nil {
+			fmt.Printf("Failed to import key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %s key %q as %s\n", key.Algorithm, key.ID, key.State)
+	case *add:
+		key, err := keyring.Add(*alg, now)
+		if err != nil {
+			fmt.Printf("Failed to add key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %s key %s\n", key.Algorithm, key.ID)
+		fmt.Printf("Promote it with -promote %s once services have fetched the new JWKS\n", key.ID)
+	case *promote != "":
+		if key := keyring.Get(*promote); key != nil && keyring.Active() != nil && now.Sub(key.CreatedAt) < cfg.JWT.Rotation.PublishDelay {
+			fmt.Printf("Warning: key %s was added less than %s ago; services caching the JWKS may reject its tokens\n", key.ID, cfg.JWT.Rotation.PublishDelay)
+		}
+		if err := keyring.Promote(*promote, now); err != nil {
+			fmt.Printf("Failed to promote key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Promoted key %s\n", *promote)
+	case *retire:
+		retired := keyring.Retire(maxTokenLifetime(cfg), now)
+		fmt.Printf("Retired %d key(s) %s\n", len(retired), strings.Join(retired, " "))
+		changed = len(retired) > 0
+	case *rotate:
+		rotation, err := keyring.Rotate(*alg, cfg.JWT.Rotation.Interval, cfg.JWT.Rotation.PublishDelay, maxTokenLifetime(cfg), now)
+		if err != nil {
+			fmt.Printf("Failed to rotate keys: %v\n", err)
+			os.Exit(1)
+		}
+		if rotation.Added != "" {
+			fmt.Printf("Added key %s\n", rotation.Added)
+		}
+		if rotation.Promoted != "" {
+			fmt.Printf("Promoted key %s\n", rotation.Promoted)
+		}
+		if len(rotation.Retired) > 0 {
+			fmt.Printf("Retired key(s) %s\n", strings.Join(rotation.Retired, " "))
+		}
+		changed = rotation.Added != "" || rotation.Promoted != "" || len(rotation.Retired) > 0
+		if !changed {
+			fmt.Println("No rotation due")
+		}
+	case *list:
+		changed = false
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if changed {
+		if err := keyring.Save(path); err != nil {
+			fmt.Printf("Failed to save keyring: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	printKeys(keyring)
+}
+
+// maxTokenLifetime returns how long the tokens signed by the keyring stay
+// valid: access tokens and, when OIDC is enabled, ID tokens
+func maxTokenLifetime(cfg *config.Config) time.Duration {
+	if cfg.OIDC.Enabled {
+		return max(cfg.JWT.TokenLifetime, cfg.OIDC.IDTokenLifetime)
+	}
+	return cfg.JWT.TokenLifetime
+}
+
+// printKeys lists the keys in the keyring
+func printKeys(keyring *auth.Keyring) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tALGORITHM\tSTATE\tCREATED\tACTIVATED\tDEACTIVATED")
+	for _, key := range keyring.Keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.State,
+			formatTime(key.CreatedAt), formatTime(key.ActivatedAt), formatTime(key.DeactivatedAt))
+	}
+	w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -27,7 +27,7 @@ func main() {
 	cfg := cfgManager.GetConfig()
 
 	// Setup router
-	router, err := routes.SetupRouter(cfg)
+	router, stopWorkers, err := routes.SetupRouter(cfgManager)
 	if err != nil {
 		log.Fatalf("Failed to setup router: %v", err)
 	}
@@ -118,5 +118,8 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Let in-flight OTP deliveries finish; queued ones survive in Redis
+	stopWorkers()
+
 	log.Println("Server exiting")
 }
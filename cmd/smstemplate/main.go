@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/services/sms"
+)
+
+func main() {
+	// Parse command line flags
+	locale := flag.String("locale", "", "Locale or Accept-Language value to render (default: every locale)")
+	code := flag.String("code", "123456", "OTP code to render")
+	expiry := flag.Duration("expiry", 0, "OTP expiry to render (default: security.otp_expiry)")
+	templateID := flag.String("template-id", "", "Template set to render (default: sms.template_id)")
+	templatesDir := flag.String("templates-dir", "", "Directory of template overrides (default: sms.templates_dir)")
+	brand := flag.String("brand", "", "Brand name (default: sms.brand_name)")
+	clientApp := flag.String("client-app", "", "Client app from sms.client_apps whose autofill lines to append")
+	check := flag.Bool("check", false, "Render every locale with the longest code and expiry and fail if any needs more than one SMS")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Printf("Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Flags override the configuration
+	if *templateID != "" {
+		cfg.SMS.TemplateID = *templateID
+	}
+	if *templatesDir != "" {
+		cfg.SMS.TemplatesDir = *templatesDir
+	}
+	if *brand != "" {
+		cfg.SMS.BrandName = *brand
+	}
+	if *expiry == 0 {
+		*expiry = cfg.Security.OTPExpiry
+	}
+
+	templates, err := sms.NewTemplates(cfg)
+	if err != nil {
+		fmt.Printf("Failed to load templates: %v\n", err)
+		os.Exit(1)
+	}
+
+	var autofill sms.Autofill
+	if *clientApp != "" {
+		var ok bool
+		if autofill, ok = templates.ClientApp(*clientApp); !ok {
+			fmt.Printf("Unknown client app: %s\n", *clientApp)
+			os.Exit(1)
+		}
+	}
+
+	var messages []*sms.Message
+	switch {
+	case *check:
+		messages, err = templates.Check(autofill)
+	case *locale != "":
+		var msg *sms.Message
+		msg, err = templates.Render(*locale, *code, *expiry, autofill)
+		messages = []*sms.Message{msg}
+	default:
+		for _, l := range templates.Locales() {
+			var msg *sms.Message
+			if msg, err = templates.Preview(l, *code, *expiry, autofill); err != nil {
+				break
+			}
+			messages = append(messages, msg)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Failed to render templates: %v\n", err)
+		os.Exit(1)
+	}
+
+	multipart := false
+	for _, msg := range messages {
+		fmt.Printf("%s/%s  %s, %d units, %d segment(s)\n", templates.ID(), msg.Locale, msg.Encoding, msg.Units, msg.Segments)
+		fmt.Printf("  %s\n\n", strings.ReplaceAll(msg.Body, "\n", "\n  "))
+		if msg.Segments > 1 {
+			multipart = true
+		}
+	}
+
+	if *check && multipart {
+		fmt.Println("Some templates need more than one SMS; they will fall back to the default locale")
+		os.Exit(1)
+	}
+}
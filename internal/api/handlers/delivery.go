@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/services/delivery"
+	"github.com/lmousom/passless-auth/internal/services/sms"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/otpdata"
+)
+
+// DeliveryHandler reports the status of queued OTP messages. Delivery IDs are
+// unguessable, and the response never includes the recipient or the code.
+type DeliveryHandler struct {
+	config     *config.Config
+	deliveries *delivery.Queue
+}
+
+func NewDeliveryHandler(cfg *config.Config, deliveries *delivery.Queue) *DeliveryHandler {
+	return &DeliveryHandler{
+		config:     cfg,
+		deliveries: deliveries,
+	}
+}
+
+func (h *DeliveryHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	record, err := h.deliveries.Status(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to get delivery status", err))
+		return
+	}
+	if record == nil {
+		middleware.ErrorResponse(w, errors.NewNotFound("Delivery not found", nil))
+		return
+	}
+
+	response := &otpdata.DeliveryStatusResponse{
+		DeliveryID: record.ID,
+		Channel:    record.Channel,
+		Status:     string(record.Status),
+		Attempts:   record.Attempts,
+		CreatedAt:  record.CreatedAt,
+		UpdatedAt:  record.UpdatedAt,
+
+		ReceiptStatus:      record.ReceiptStatus,
+		FallbackDeliveryID: record.FallbackID,
+	}
+	// Let the client offer another channel when the SMS did not get through
+	smsFailed := record.Status == storage.DeliveryFailed || record.ReceiptStatus == string(sms.ReportUndelivered)
+	if record.Channel == otpdata.ChannelSMS && smsFailed {
+		response.AlternativeChannels = enabledPhoneChannels(h.config)[1:]
+	}
+	if !record.NextAttempt.IsZero() {
+		next := record.NextAttempt
+		response.NextAttempt = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
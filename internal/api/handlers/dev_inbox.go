@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/phone"
+	"github.com/lmousom/passless-auth/internal/services/sms"
+)
+
+const defaultInboxLimit = 10
+
+type DevInboxResponse struct {
+	Status   string            `json:"status"`
+	Phone    string            `json:"phone"`
+	Messages []sms.SinkMessage `json:"messages"`
+}
+
+// DevInboxHandler exposes messages captured by the development SMS sink providers
+type DevInboxHandler struct {
+	inbox  *sms.Inbox
+	phones *phone.Parser
+}
+
+func NewDevInboxHandler(inbox *sms.Inbox, phones *phone.Parser) *DevInboxHandler {
+	return &DevInboxHandler{
+		inbox:  inbox,
+		phones: phones,
+	}
+}
+
+func (h *DevInboxHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := normalizePhone(h.phones, mux.Vars(r)["phone"])
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	limit := defaultInboxLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid limit", err))
+			return
+		}
+		limit = n
+	}
+
+	response := &DevInboxResponse{
+		Status:   "success",
+		Phone:    phoneNumber,
+		Messages: h.inbox.Messages(phoneNumber, limit),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
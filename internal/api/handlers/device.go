@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/oidc"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/devicedata"
+)
+
+// DeviceHandler backs the verification page where a signed in user enters
+// the user code shown by a CLI or kiosk to let it sign in as them
+type DeviceHandler struct {
+	provider    *oidc.Provider
+	tokens      *auth.TokenManager
+	redisClient *storage.RedisClient
+}
+
+func NewDeviceHandler(provider *oidc.Provider, tokens *auth.TokenManager, redisClient *storage.RedisClient) *DeviceHandler {
+	return &DeviceHandler{
+		provider:    provider,
+		tokens:      tokens,
+		redisClient: redisClient,
+	}
+}
+
+// Get describes the device waiting for the user_code query parameter, so the
+// user can check what they are approving
+func (h *DeviceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.authenticate(r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	device, err := h.provider.Device(r.Context(), r.URL.Query().Get("user_code"))
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to get device", err))
+		return
+	}
+	if device == nil {
+		middleware.ErrorResponse(w, h.unknownUserCode(r.Context(), claims))
+		return
+	}
+
+	writeDeviceResponse(w, &devicedata.DeviceResponse{
+		Status:     "success",
+		ClientID:   device.Client.ID,
+		ClientName: device.Client.Name,
+		Scope:      device.Scope,
+		ExpiresAt:  device.ExpiresAt.UTC(),
+	})
+}
+
+// Verify approves or denies the device waiting for a user code
+func (h *DeviceHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.authenticate(r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	var req devicedata.VerifyDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
+		return
+	}
+
+	var found bool
+	var message string
+	switch req.Action {
+	case devicedata.ActionApprove:
+		found, err = h.provider.ApproveDevice(r.Context(), req.UserCode, claims)
+		message = "Device approved successfully"
+	case devicedata.ActionDeny:
+		found, err = h.provider.DenyDevice(r.Context(), req.UserCode)
+		message = "Device denied successfully"
+	default:
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid action", nil).
+			WithField("action", "unsupported", "action must be approve or deny"))
+		return
+	}
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to verify device", err))
+		return
+	}
+	if !found {
+		middleware.ErrorResponse(w, h.unknownUserCode(r.Context(), claims))
+		return
+	}
+
+	writeDeviceResponse(w, &devicedata.VerifyDeviceResponse{
+		Status:  "success",
+		Message: message,
+	})
+}
+
+// authenticate returns the signed in user, refusing users locked out for
+// entering too many unknown user codes
+func (h *DeviceHandler) authenticate(r *http.Request) (*auth.Claims, error) {
+	claims, err := authenticate(h.tokens, r)
+	if err != nil {
+		return nil, err
+	}
+
+	retryAfter, err := h.redisClient.GetLockout(r.Context(), deviceLockoutKey(claims))
+	if err != nil {
+		return nil, errors.NewInternalServer("Failed to check lockout status", err)
+	}
+	if retryAfter > 0 {
+		return nil, errors.NewTooManyAttempts("Too many failed attempts, try again later", nil).WithRetryAfter(retryAfter)
+	}
+	return claims, nil
+}
+
+// unknownUserCode counts a user code that matched no device towards a
+// lockout, as user codes are short enough to guess
+func (h *DeviceHandler) unknownUserCode(ctx context.Context, claims *auth.Claims) error {
+	lockout, err := h.redisClient.RecordFailedAttempt(ctx, deviceLockoutKey(claims))
+	if err != nil {
+		return errors.NewInternalServer("Failed to record failed attempt", err)
+	}
+	if lockout > 0 {
+		middleware.RecordLockout("device")
+		return errors.NewTooManyAttempts("Too many failed attempts, try again later", nil).WithRetryAfter(lockout)
+	}
+	return errors.NewNotFound("Invalid or expired user code", nil)
+}
+
+// deviceLockoutKey keeps user code failures apart from the user's failed
+// sign-ins, so guessing user codes cannot lock them out of signing in
+func deviceLockoutKey(claims *auth.Claims) string {
+	return "device:" + claims.Identifier()
+}
+
+func writeDeviceResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
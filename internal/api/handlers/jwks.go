@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+)
+
+// JWKSHandler publishes the public keys that session tokens are signed with,
+// so that other services can verify tokens without a shared secret
+type JWKSHandler struct {
+	tokens *auth.TokenManager
+}
+
+func NewJWKSHandler(tokens *auth.TokenManager) *JWKSHandler {
+	return &JWKSHandler{
+		tokens: tokens,
+	}
+}
+
+func (h *JWKSHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(h.tokens.JWKS()); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
@@ -4,20 +4,72 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
 	"github.com/lmousom/passless-auth/internal/errors"
 	"github.com/lmousom/passless-auth/internal/middleware"
 )
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Clear the token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:   "token",
-		Value:  "",
-		MaxAge: -1,
-	})
+// LogoutHandler ends sessions and revokes their tokens
+type LogoutHandler struct {
+	config   *config.Config
+	sessions *auth.SessionManager
+}
+
+func NewLogoutHandler(cfg *config.Config, sessions *auth.SessionManager) *LogoutHandler {
+	return &LogoutHandler{
+		config:   cfg,
+		sessions: sessions,
+	}
+}
+
+// Handle ends the session of the request's tokens
+func (h *LogoutHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Revoke the access token so that copies of it stop working
+	if claims, err := parseTokenCookie(h.sessions.Tokens(), r); err == nil {
+		if err := h.sessions.Tokens().RevokeToken(ctx, claims); err != nil {
+			middleware.ErrorResponse(w, errors.NewInternalServer("Failed to revoke token", err))
+			return
+		}
+	}
+
+	// Revoke the session so its refresh token can no longer be used
+	if c, err := r.Cookie(refreshTokenCookie); err == nil {
+		if _, err := h.sessions.End(ctx, c.Value); err != nil {
+			middleware.ErrorResponse(w, errors.NewInternalServer("Failed to end session", err))
+			return
+		}
+	}
+
+	// Clear the token cookies
+	clearSessionCookies(w, h.config)
+
+	writeLogoutResponse(w, "Logged out successfully")
+}
+
+// HandleAll revokes every token and session of the signed in user
+func (h *LogoutHandler) HandleAll(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	if err := h.sessions.EndAll(r.Context(), claims.Identifier()); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to revoke sessions", err))
+		return
+	}
+
+	clearSessionCookies(w, h.config)
+
+	writeLogoutResponse(w, "Logged out of all sessions successfully")
+}
 
+func writeLogoutResponse(w http.ResponseWriter, message string) {
 	response := map[string]string{
-		"message": "Logged out successfully",
+		"message": message,
 		"status":  "success",
 	}
 
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/phone"
+	"github.com/lmousom/passless-auth/internal/services/delivery"
+	"github.com/lmousom/passless-auth/internal/services/sms"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/magiclink"
+	"github.com/lmousom/passless-auth/models/otpdata"
+)
+
+const (
+	magicStateCookie = "magic_state"
+	magicLinkPath    = "/api/v1/magic/"
+)
+
+type MagicLinkHandler struct {
+	config      *config.Config
+	deliveries  *delivery.Queue
+	smsGuard    *sms.Guard
+	phones      *phone.Parser
+	countries   *phone.CountryPolicy
+	redisClient *storage.RedisClient
+	sessions    *auth.SessionManager
+}
+
+// NewMagicLinkHandler creates a MagicLinkHandler. Links are handed to
+// deliveries and sent in the background.
+func NewMagicLinkHandler(cfg *config.Config, deliveries *delivery.Queue, smsGuard *sms.Guard, phones *phone.Parser, countries *phone.CountryPolicy, redisClient *storage.RedisClient, sessions *auth.SessionManager) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		config:      cfg,
+		deliveries:  deliveries,
+		smsGuard:    smsGuard,
+		phones:      phones,
+		countries:   countries,
+		redisClient: redisClient,
+		sessions:    sessions,
+	}
+}
+
+func (h *MagicLinkHandler) SendMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req magiclink.SendMagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
+		return
+	}
+
+	if req.Locale == "" {
+		req.Locale = r.Header.Get("Accept-Language")
+	}
+
+	channel, recipient, err := resolveRecipient(h.phones, req.Phone, req.Email, h.config.Email.Enabled)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	rules, err := deliveryRules(h.countries, channel, recipient)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	expiry := rules.OTPExpiry
+
+	// Don't let an account that is locked out sign in by link instead
+	retryAfter, err := h.redisClient.GetLockout(ctx, recipient)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to check lockout status", err))
+		return
+	}
+	if retryAfter > 0 {
+		middleware.ErrorResponse(w, errors.NewTooManyAttempts("Too many failed attempts, try again later", nil).WithRetryAfter(retryAfter))
+		return
+	}
+
+	if channel == otpdata.ChannelSMS {
+		if err := h.smsGuard.Allow(ctx, recipient); err != nil {
+			middleware.ErrorResponse(w, err)
+			return
+		}
+	}
+
+	link, err := h.redisClient.CreateMagicLink(ctx, recipient, channel, expiry)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to create magic link", err))
+		return
+	}
+
+	linkURL := strings.TrimSuffix(h.config.MagicLink.BaseURL, "/") + magicLinkPath + link.Token
+
+	// Queue the link like an OTP so a slow provider does not hold the request open
+	deliveryID, err := h.deliveries.Enqueue(ctx, &storage.DeliveryJob{
+		Channel:   channel,
+		Recipient: recipient,
+		Link:      linkURL,
+		Locale:    req.Locale,
+		Provider:  rules.SMSProvider,
+		Region:    rules.Region,
+		Expiry:    expiry,
+		ExpiresAt: link.ExpiresAt,
+	})
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to queue magic link", err))
+		return
+	}
+
+	// Bind the link to this browser; it can only be redeemed alongside this cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicStateCookie,
+		Value:    link.State,
+		Expires:  link.ExpiresAt,
+		Path:     magicLinkPath,
+		HttpOnly: true,
+		Secure:   h.config.Server.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	response := &magiclink.SendMagicLinkResponse{
+		Status:     "success",
+		Message:    "Magic link queued for delivery",
+		Channel:    channel,
+		ExpiresAt:  link.ExpiresAt.UTC(),
+		DeliveryID: deliveryID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
+
+func (h *MagicLinkHandler) ConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	if token == "" {
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Magic link token is required", nil))
+		return
+	}
+
+	state, err := r.Cookie(magicStateCookie)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewForbidden("Magic link must be opened in the browser that requested it", nil))
+		return
+	}
+
+	ctx := r.Context()
+	result, err := h.redisClient.ConsumeMagicLink(ctx, token, state.Value)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to verify magic link", err))
+		return
+	}
+
+	switch result.Status {
+	case storage.MagicLinkValid:
+	case storage.MagicLinkExpired:
+		middleware.ErrorResponse(w, errors.NewOTPExpired("Magic link has expired", nil))
+		return
+	case storage.MagicLinkUsed:
+		middleware.ErrorResponse(w, errors.NewOTPAlreadyUsed("Magic link has already been used", nil))
+		return
+	case storage.MagicLinkStateMismatch:
+		middleware.ErrorResponse(w, errors.NewForbidden("Magic link must be opened in the browser that requested it", nil))
+		return
+	default:
+		middleware.ErrorResponse(w, errors.NewOTPNotFound("Magic link not found", nil))
+		return
+	}
+
+	claims := &auth.Claims{}
+	if result.Channel == otpdata.ChannelEmail {
+		claims.Email = result.Recipient
+	} else {
+		claims.Phone = result.Recipient
+	}
+
+	session, err := startLoginSession(ctx, h.redisClient, h.sessions, claims, clientDevice(r, ""), []string{auth.MethodMagicLink})
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	setSessionCookies(w, h.config, session)
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicStateCookie,
+		Value:    "",
+		Path:     magicLinkPath,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	if h.config.MagicLink.RedirectURL != "" {
+		http.Redirect(w, r, h.config.MagicLink.RedirectURL, http.StatusFound)
+		return
+	}
+
+	response := &magiclink.MagicLinkResponse{
+		Status:  "success",
+		Message: "Magic link verified successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/oidc"
+)
+
+// OIDCHandler serves the OpenID Connect endpoints that let registered
+// clients sign users in with their passwordless session
+type OIDCHandler struct {
+	provider *oidc.Provider
+	tokens   *auth.TokenManager
+}
+
+func NewOIDCHandler(provider *oidc.Provider, tokens *auth.TokenManager) *OIDCHandler {
+	return &OIDCHandler{
+		provider: provider,
+		tokens:   tokens,
+	}
+}
+
+// Discovery returns the provider metadata
+func (h *OIDCHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(h.provider.Discovery()); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
+
+// Authorize redirects a signed in user back to the client with an
+// authorization code, and sends other users to the sign-in page first
+func (h *OIDCHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOIDCError(w, &oidc.Error{Code: oidc.ErrInvalidRequest, Description: "invalid request parameters", Status: http.StatusBadRequest})
+		return
+	}
+	req := &oidc.AuthorizeRequest{
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		ResponseType:        r.Form.Get("response_type"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Prompt:              r.Form.Get("prompt"),
+	}
+
+	// Without a verified redirect URI the error can only be shown here
+	if err := h.provider.CheckRedirect(req); err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	if err := h.provider.CheckRequest(req); err != nil {
+		h.redirectError(w, r, req, err)
+		return
+	}
+
+	claims, err := authenticate(h.tokens, r)
+	if err != nil {
+		if appErr, ok := err.(*errors.AppError); ok && appErr.Code == errors.ErrInternalServer {
+			h.redirectError(w, r, req, &oidc.Error{Code: oidc.ErrServerError, Err: err})
+			return
+		}
+		if hasPrompt(req.Prompt, "none") {
+			h.redirectError(w, r, req, &oidc.Error{Code: oidc.ErrLoginRequired, Description: "the user is not signed in"})
+			return
+		}
+		http.Redirect(w, r, h.provider.LoginURL(req), http.StatusFound)
+		return
+	}
+
+	code, err := h.provider.Authorize(r.Context(), req, claims)
+	if err != nil {
+		h.redirectError(w, r, req, err)
+		return
+	}
+	http.Redirect(w, r, h.provider.ResponseURL(req, url.Values{"code": {code}}), http.StatusFound)
+}
+
+// DeviceAuthorization gives a CLI or kiosk the codes to sign in with the
+// device authorization grant
+func (h *OIDCHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		writeOIDCError(w, &oidc.Error{Code: oidc.ErrInvalidRequest, Description: "invalid request body", Status: http.StatusBadRequest})
+		return
+	}
+	req := &oidc.DeviceRequest{
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Scope:        r.PostForm.Get("scope"),
+	}
+	if id, secret, ok := clientCredentials(r); ok {
+		req.ClientID, req.ClientSecret = id, secret
+	}
+
+	response, err := h.provider.AuthorizeDevice(r.Context(), req)
+	if err != nil {
+		if oidcErr, ok := err.(*oidc.Error); ok && oidcErr.Code == oidc.ErrInvalidClient && r.Header.Get("Authorization") != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oidc"`)
+		}
+		writeOIDCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
+
+// Token exchanges an authorization code, or the device code of an approved
+// device, for an access token and ID token
+func (h *OIDCHandler) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		writeOIDCError(w, &oidc.Error{Code: oidc.ErrInvalidRequest, Description: "invalid request body", Status: http.StatusBadRequest})
+		return
+	}
+	req := &oidc.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		DeviceCode:   r.PostForm.Get("device_code"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+	}
+	if id, secret, ok := clientCredentials(r); ok {
+		req.ClientID, req.ClientSecret = id, secret
+	}
+
+	response, err := h.provider.Exchange(r.Context(), req)
+	if err != nil {
+		if oidcErr, ok := err.(*oidc.Error); ok && oidcErr.Code == oidc.ErrInvalidClient && r.Header.Get("Authorization") != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oidc"`)
+		}
+		writeOIDCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
+
+// UserInfo returns the claims of the user a bearer access token belongs to
+func (h *OIDCHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	accessToken, ok := bearerToken(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc"`)
+		writeOIDCError(w, &oidc.Error{Code: oidc.ErrInvalidToken, Description: "no access token provided", Status: http.StatusUnauthorized})
+		return
+	}
+
+	info, err := h.provider.UserInfo(r.Context(), accessToken)
+	if err != nil {
+		if oidcErr, ok := err.(*oidc.Error); ok && oidcErr.Code != oidc.ErrServerError {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="oidc", error="`+oidcErr.Code+`"`)
+		}
+		writeOIDCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
+
+// redirectError sends err to the client's redirect URI
+func (h *OIDCHandler) redirectError(w http.ResponseWriter, r *http.Request, req *oidc.AuthorizeRequest, err error) {
+	oidcErr, ok := err.(*oidc.Error)
+	if !ok {
+		oidcErr = &oidc.Error{Code: oidc.ErrServerError, Err: err}
+	}
+	if oidcErr.Err != nil {
+		log.Printf("OIDC authorization failed: %v", oidcErr)
+	}
+	http.Redirect(w, r, h.provider.ErrorURL(req, oidcErr), http.StatusFound)
+}
+
+// writeOIDCError writes err as an OAuth 2.0 error response
+func writeOIDCError(w http.ResponseWriter, err error) {
+	oidcErr, ok := err.(*oidc.Error)
+	if !ok {
+		oidcErr = &oidc.Error{Code: oidc.ErrServerError, Status: http.StatusInternalServerError, Err: err}
+	}
+	if oidcErr.Err != nil {
+		log.Printf("OIDC request failed: %v", oidcErr)
+	}
+	status := oidcErr.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(oidcErr)
+}
+
+// clientCredentials returns the client ID and secret of HTTP Basic
+// authentication, which are form-encoded (RFC 6749 section 2.3.1)
+func clientCredentials(r *http.Request) (string, string, bool) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return "", "", false
+	}
+	id, err := url.QueryUnescape(id)
+	if err != nil {
+		return "", "", false
+	}
+	secret, err = url.QueryUnescape(secret)
+	if err != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// bearerToken returns the token of a Bearer Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// hasPrompt reports whether the space-separated prompt list contains prompt
+func hasPrompt(prompts, prompt string) bool {
+	for _, p := range strings.Fields(prompts) {
+		if p == prompt {
+			return true
+		}
+	}
+	return false
+}
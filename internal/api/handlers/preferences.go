@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/otpdata"
+)
+
+// ChannelPreferenceHandler lets a signed in user choose the channel that
+// codes for their phone number are sent on
+type ChannelPreferenceHandler struct {
+	config      *config.Config
+	redisClient *storage.RedisClient
+	tokens      *auth.TokenManager
+}
+
+func NewChannelPreferenceHandler(cfg *config.Config, redisClient *storage.RedisClient, tokens *auth.TokenManager) *ChannelPreferenceHandler {
+	return &ChannelPreferenceHandler{
+		config:      cfg,
+		redisClient: redisClient,
+		tokens:      tokens,
+	}
+}
+
+// Get returns the user's preferred channel
+func (h *ChannelPreferenceHandler) Get(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := h.phoneNumber(r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	channel, err := h.redisClient.GetChannelPreference(r.Context(), phoneNumber)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to get channel preference", err))
+		return
+	}
+
+	h.respond(w, channel)
+}
+
+// Set stores the user's preferred channel
+func (h *ChannelPreferenceHandler) Set(w http.ResponseWriter, r *http.Request) {
+	phoneNumber, err := h.phoneNumber(r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	var req otpdata.ChannelPreferenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
+		return
+	}
+	if req.Channel != "" && !phoneChannelEnabled(h.config, req.Channel) {
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Channel is not available", nil).
+			WithField("channel", "unsupported", "channel is not one of the available channels"))
+		return
+	}
+
+	if err := h.redisClient.SetChannelPreference(r.Context(), phoneNumber, req.Channel); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to store channel preference", err))
+		return
+	}
+
+	h.respond(w, req.Channel)
+}
+
+// phoneNumber returns the phone number of the signed in user
+func (h *ChannelPreferenceHandler) phoneNumber(r *http.Request) (string, error) {
+	claims, err := authenticate(h.tokens, r)
+	if err != nil {
+		return "", err
+	}
+	if claims.Phone == "" {
+		return "", errors.NewInvalidRequest("Channel preferences apply to phone numbers only", nil)
+	}
+	return claims.Phone, nil
+}
+
+func (h *ChannelPreferenceHandler) respond(w http.ResponseWriter, channel string) {
+	response := &otpdata.ChannelPreferenceResponse{
+		Status:            "success",
+		Channel:           channel,
+		AvailableChannels: enabledPhoneChannels(h.config),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
@@ -3,65 +3,57 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
 	"github.com/lmousom/passless-auth/internal/errors"
 	"github.com/lmousom/passless-auth/internal/middleware"
 )
 
-func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
-	c, err := r.Cookie("token")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			middleware.ErrorResponse(w, errors.NewUnauthorized("No authentication token provided", nil))
-			return
-		}
-		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid cookie", err))
-		return
-	}
+// refreshTokenCookie holds the opaque refresh token of a session
+const refreshTokenCookie = "refresh_token"
 
-	tknStr := c.Value
-	claims := &Claims{}
-
-	tkn, err := jwt.ParseWithClaims(tknStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			middleware.ErrorResponse(w, errors.NewUnauthorized("Invalid token signature", err))
-			return
-		}
-		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid token", err))
-		return
-	}
+// RefreshTokenHandler exchanges a refresh token for a new access token and a
+// new refresh token. Refresh tokens are single use: presenting one that was
+// already exchanged revokes the session.
+type RefreshTokenHandler struct {
+	config   *config.Config
+	sessions *auth.SessionManager
+}
 
-	if !tkn.Valid {
-		middleware.ErrorResponse(w, errors.NewUnauthorized("Invalid token", nil))
-		return
+func NewRefreshTokenHandler(cfg *config.Config, sessions *auth.SessionManager) *RefreshTokenHandler {
+	return &RefreshTokenHandler{
+		config:   cfg,
+		sessions: sessions,
 	}
+}
 
-	if time.Until(time.Unix(claims.ExpiresAt.Unix(), 0)) > 30*time.Second {
-		middleware.ErrorResponse(w, errors.NewInvalidRequest("Token not expired yet", nil))
+func (h *RefreshTokenHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(refreshTokenCookie)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewUnauthorized("No refresh token provided", nil))
 		return
 	}
 
-	// Create new token with extended expiry
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims.ExpiresAt = jwt.NewNumericDate(expirationTime)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	session, err := h.sessions.Refresh(r.Context(), c.Value, clientIP(r))
 	if err != nil {
-		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to generate new token", err))
+		switch err {
+		case auth.ErrRefreshTokenReused:
+			clearSessionCookies(w, h.config)
+			middleware.ErrorResponse(w, errors.NewInvalidToken("Refresh token has already been used, the session has been revoked", err))
+		case auth.ErrSessionExpired:
+			clearSessionCookies(w, h.config)
+			middleware.ErrorResponse(w, errors.NewTokenExpired("Session has expired, sign in again", err))
+		case auth.ErrInvalidRefreshToken:
+			clearSessionCookies(w, h.config)
+			middleware.ErrorResponse(w, errors.NewInvalidToken("Invalid refresh token", err))
+		default:
+			middleware.ErrorResponse(w, errors.NewInternalServer("Failed to refresh session", err))
+		}
 		return
 	}
 
-	// Set the new token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
+	setSessionCookies(w, h.config, session)
 
 	response := map[string]string{
 		"message": "Token refreshed successfully",
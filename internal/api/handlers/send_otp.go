@@ -1,54 +1,143 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
+	"log"
 	"net/http"
-	"strconv"
+	"net/mail"
+	"strings"
 	"time"
 
+	"github.com/lmousom/passless-auth/internal/config"
 	"github.com/lmousom/passless-auth/internal/errors"
 	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/phone"
+	"github.com/lmousom/passless-auth/internal/services/delivery"
 	"github.com/lmousom/passless-auth/internal/services/sms"
+	"github.com/lmousom/passless-auth/internal/storage"
 	"github.com/lmousom/passless-auth/models/otpdata"
-	"github.com/lmousom/passless-auth/utils"
 )
 
 var table = []byte{'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'}
 
+const (
+	// idempotencyKeyHeader lets clients retry sendOtp without sending a new code
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+	// idempotencyLockTTL is how long a key is held by a request that never completes
+	idempotencyLockTTL = 30 * time.Second
+)
+
 type SendOtpHandler struct {
-	smsService *sms.TwilioService
+	config      *config.Config
+	deliveries  *delivery.Queue
+	smsGuard    *sms.Guard
+	phones      *phone.Parser
+	countries   *phone.CountryPolicy
+	redisClient *storage.RedisClient
 }
 
-func NewSendOtpHandler(smsService *sms.TwilioService) *SendOtpHandler {
+// NewSendOtpHandler creates a SendOtpHandler. Codes are handed to deliveries
+// and sent in the background.
+func NewSendOtpHandler(cfg *config.Config, deliveries *delivery.Queue, smsGuard *sms.Guard, phones *phone.Parser, countries *phone.CountryPolicy, redisClient *storage.RedisClient) *SendOtpHandler {
 	return &SendOtpHandler{
-		smsService: smsService,
+		config:      cfg,
+		deliveries:  deliveries,
+		smsGuard:    smsGuard,
+		phones:      phones,
+		countries:   countries,
+		redisClient: redisClient,
 	}
 }
 
-func (h *SendOtpHandler) SendOtp(phonenumber string) (*otpdata.SendOtpResponse, error) {
-	if phonenumber == "" {
-		return nil, errors.NewInvalidRequest("Phone number is required", nil)
+func (h *SendOtpHandler) SendOtp(ctx context.Context, sendOtpRequest otpdata.SendOtpRequest) (*otpdata.SendOtpResponse, error) {
+	channel, recipient, err := resolveRecipient(h.phones, sendOtpRequest.Phone, sendOtpRequest.Email, h.config.Email.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	requested := sendOtpRequest.Channel
+	if requested == "" && channel == otpdata.ChannelSMS {
+		// Use the channel the user prefers, unless it has since been disabled
+		preferred, err := h.redisClient.GetChannelPreference(ctx, recipient)
+		if err != nil {
+			return nil, errors.NewInternalServer("Failed to get channel preference", err)
+		}
+		if phoneChannelEnabled(h.config, preferred) {
+			requested = preferred
+		}
+	}
+	if channel, err = h.selectChannel(channel, requested); err != nil {
+		return nil, err
+	}
+
+	rules, err := deliveryRules(h.countries, channel, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	if channel == otpdata.ChannelSMS && sendOtpRequest.ClientApp != "" && !h.hasClientApp(sendOtpRequest.ClientApp) {
+		return nil, errors.NewInvalidRequest("Unknown client app", nil).
+			WithField("client_app", "unknown", "client app is not configured")
+	}
+
+	// Don't issue new codes to an account that is locked out
+	retryAfter, err := h.redisClient.GetLockout(ctx, recipient)
+	if err != nil {
+		return nil, errors.NewInternalServer("Failed to check lockout status", err)
+	}
+	if retryAfter > 0 {
+		return nil, errors.NewTooManyAttempts("Too many failed attempts, try again later", nil).WithRetryAfter(retryAfter)
+	}
+
+	// Enforce send limits and toll fraud protection for phone numbers
+	if channel != otpdata.ChannelEmail {
+		if err := h.smsGuard.Allow(ctx, recipient); err != nil {
+			return nil, err
+		}
 	}
 
-	otp := GenerateOtp(6)
-	ttl := 2 * 60 * 1000
-	expiresIn := time.Now().UTC().UnixMilli() + int64(ttl)
-	data := phonenumber + "." + otp + "." + strconv.FormatInt(expiresIn, 10)
-	hash := utils.Encrypt([]byte(data))
-	fullhash := hash + "." + strconv.FormatInt(expiresIn, 10)
+	otp := GenerateOtp(rules.OTPLength)
 
-	// Send OTP via Twilio
-	if err := h.smsService.SendOTP(phonenumber, otp); err != nil {
-		return nil, errors.NewInternalServer("Failed to send OTP", err)
+	// Persist the challenge before sending so the code is never deliverable without being verifiable
+	challenge, err := h.redisClient.CreateOTPChallenge(ctx, recipient, otp, rules.OTPExpiry)
+	if err != nil {
+		return nil, errors.NewInternalServer("Failed to store OTP", err)
+	}
+
+	// Queue the code for delivery so a slow provider does not hold the request open
+	deliveryID, err := h.deliveries.Enqueue(ctx, &storage.DeliveryJob{
+		Channel:   channel,
+		Recipient: recipient,
+		Code:      otp,
+		Locale:    sendOtpRequest.Locale,
+		ClientApp: sendOtpRequest.ClientApp,
+		Provider:  rules.SMSProvider,
+		Region:    rules.Region,
+		Expiry:    rules.OTPExpiry,
+		ExpiresAt: challenge.ExpiresAt,
+	})
+	if err != nil {
+		return nil, errors.NewInternalServer("Failed to queue OTP", err)
 	}
 
 	response := &otpdata.SendOtpResponse{
-		Status:  "success",
-		Message: "OTP sent successfully",
-		Phone:   phonenumber,
-		Hash:    fullhash,
+		Status:      "success",
+		Message:     "OTP queued for delivery",
+		Channel:     channel,
+		ChallengeID: challenge.ID,
+		ExpiresAt:   challenge.ExpiresAt.UTC(),
+		DeliveryID:  deliveryID,
+	}
+	if channel == otpdata.ChannelEmail {
+		response.Email = recipient
+	} else {
+		response.Phone = recipient
 	}
 
 	return response, nil
@@ -60,8 +149,21 @@ func (h *SendOtpHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
 		return
 	}
+	if sendOtpRequest.Locale == "" {
+		sendOtpRequest.Locale = r.Header.Get("Accept-Language")
+	}
 
-	response, err := h.SendOtp(sendOtpRequest.Phone)
+	var response *otpdata.SendOtpResponse
+	var err error
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+		var replayed bool
+		response, replayed, err = h.sendOnce(r.Context(), key, sendOtpRequest)
+		if replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+	} else {
+		response, err = h.SendOtp(r.Context(), sendOtpRequest)
+	}
 	if err != nil {
 		middleware.ErrorResponse(w, err)
 		return
@@ -74,6 +176,98 @@ func (h *SendOtpHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sendOnce sends a code for the first request with an idempotency key and
+// returns the same response to retries of it, until the code expires or the
+// idempotency window ends. Failed requests release the key so that they can
+// be retried.
+func (h *SendOtpHandler) sendOnce(ctx context.Context, key string, sendOtpRequest otpdata.SendOtpRequest) (*otpdata.SendOtpResponse, bool, error) {
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, false, errors.NewInvalidRequest("Invalid idempotency key", nil).
+			WithField(idempotencyKeyHeader, "too_long", "idempotency key must be at most 255 characters")
+	}
+
+	// Clients only pick keys unique among their own requests, so keys are
+	// scoped to the recipient and client app they were sent for
+	_, recipient, err := resolveRecipient(h.phones, sendOtpRequest.Phone, sendOtpRequest.Email, h.config.Email.Enabled)
+	if err != nil {
+		return nil, false, err
+	}
+	key = strings.Join([]string{recipient, sendOtpRequest.ClientApp, key}, "\x00")
+
+	body, err := json.Marshal(sendOtpRequest)
+	if err != nil {
+		return nil, false, errors.NewInternalServer("Failed to encode request", err)
+	}
+	sum := sha256.Sum256(body)
+
+	result, err := h.redisClient.ReserveIdempotencyKey(ctx, "sendOtp", key, hex.EncodeToString(sum[:]), idempotencyLockTTL)
+	if err != nil {
+		return nil, false, errors.NewInternalServer("Failed to check idempotency key", err)
+	}
+
+	switch result.Status {
+	case storage.IdempotencyMismatch:
+		return nil, false, errors.NewIdempotencyKeyReused("Idempotency key was used for a different request", nil)
+	case storage.IdempotencyPending:
+		return nil, false, errors.NewRequestInProgress("A request with this idempotency key is in progress", nil).
+			WithRetryAfter(time.Second)
+	case storage.IdempotencyCompleted:
+		var response otpdata.SendOtpResponse
+		if err := json.Unmarshal(result.Response, &response); err != nil {
+			return nil, false, errors.NewInternalServer("Failed to decode stored response", err)
+		}
+		return &response, true, nil
+	}
+
+	response, err := h.SendOtp(ctx, sendOtpRequest)
+	if err != nil {
+		if err := h.redisClient.ReleaseIdempotencyKey(ctx, "sendOtp", key); err != nil {
+			log.Printf("Failed to release idempotency key: %v", err)
+		}
+		return nil, false, err
+	}
+
+	// Replaying the challenge is pointless once its code has expired
+	ttl := min(h.config.Security.IdempotencyWindow, time.Until(response.ExpiresAt))
+	stored, err := json.Marshal(response)
+	if err == nil {
+		err = h.redisClient.CompleteIdempotencyKey(ctx, "sendOtp", key, stored, ttl)
+	}
+	if err != nil {
+		// The code was sent; a retry after the lock lapses sends another
+		log.Printf("Failed to store idempotent response: %v", err)
+	}
+
+	return response, false, nil
+}
+
+// selectChannel applies the channel requested for a recipient whose default
+// channel is resolved. Phone numbers can use any enabled phone channel.
+func (h *SendOtpHandler) selectChannel(resolved, requested string) (string, error) {
+	switch {
+	case requested == "" || requested == resolved:
+		return resolved, nil
+	case resolved != otpdata.ChannelSMS || !isPhoneChannel(requested):
+		return "", errors.NewInvalidRequest("Unsupported channel", nil).
+			WithField("channel", "unsupported", "channel is not available for this recipient")
+	case !phoneChannelEnabled(h.config, requested):
+		return "", errors.NewInvalidRequest("Channel is not enabled", nil).
+			WithField("channel", "not_enabled", requested+" delivery is not enabled")
+	default:
+		return requested, nil
+	}
+}
+
+// hasClientApp reports whether name is configured in sms.client_apps
+func (h *SendOtpHandler) hasClientApp(name string) bool {
+	for app := range h.config.SMS.ClientApps {
+		if strings.EqualFold(app, name) {
+			return true
+		}
+	}
+	return false
+}
+
 func GenerateOtp(max int) string {
 	b := make([]byte, max)
 	n, err := io.ReadAtLeast(rand.Reader, b, max)
@@ -85,3 +279,113 @@ func GenerateOtp(max int) string {
 	}
 	return string(b)
 }
+
+// phoneChannels are the channels that deliver to a phone number, SMS first
+var phoneChannels = []string{otpdata.ChannelSMS, otpdata.ChannelVoice, otpdata.ChannelWhatsApp, otpdata.ChannelTelegram}
+
+func isPhoneChannel(channel string) bool {
+	for _, c := range phoneChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// phoneChannelEnabled reports whether codes can be sent to phone numbers
+// through channel
+func phoneChannelEnabled(cfg *config.Config, channel string) bool {
+	switch channel {
+	case otpdata.ChannelSMS:
+		return true
+	case otpdata.ChannelVoice:
+		return cfg.Voice.Enabled
+	case otpdata.ChannelWhatsApp:
+		return cfg.Messaging.WhatsApp.Enabled
+	case otpdata.ChannelTelegram:
+		return cfg.Messaging.Telegram.Enabled
+	default:
+		return false
+	}
+}
+
+// enabledPhoneChannels returns the phone channels that are enabled
+func enabledPhoneChannels(cfg *config.Config) []string {
+	var channels []string
+	for _, channel := range phoneChannels {
+		if phoneChannelEnabled(cfg, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// resolveRecipient picks the delivery channel and normalized recipient from a
+// request that carries exactly one of phone or email
+func resolveRecipient(phones *phone.Parser, phoneNumber, email string, emailEnabled bool) (string, string, error) {
+	if phoneNumber == "" && email == "" {
+		return "", "", errors.NewInvalidRequest("Phone number or email is required", nil)
+	}
+	if phoneNumber != "" && email != "" {
+		return "", "", errors.NewInvalidRequest("Provide either a phone number or an email, not both", nil)
+	}
+
+	if email == "" {
+		e164, err := normalizePhone(phones, phoneNumber)
+		if err != nil {
+			return "", "", err
+		}
+		return otpdata.ChannelSMS, e164, nil
+	}
+
+	if !emailEnabled {
+		return "", "", errors.NewInvalidRequest("Email delivery is not enabled", nil)
+	}
+	address, err := normalizeEmail(email)
+	if err != nil {
+		return "", "", err
+	}
+	return otpdata.ChannelEmail, address, nil
+}
+
+// deliveryRules returns the OTP settings for recipient, refusing phone
+// numbers from countries outside security.countries
+func deliveryRules(countries *phone.CountryPolicy, channel, recipient string) (*phone.CountryRules, error) {
+	if channel == otpdata.ChannelEmail {
+		return countries.Defaults(), nil
+	}
+
+	rules, err := countries.Rules(recipient)
+	if err == phone.ErrCountryNotAllowed {
+		return nil, errors.NewCountryNotAllowed("OTP delivery to this country is not supported", err).
+			WithField("phone", phone.ErrCountryNotAllowed.Code, phone.ErrCountryNotAllowed.Message)
+	}
+	if err != nil {
+		return nil, errors.NewInvalidRequest("Invalid phone number", err)
+	}
+	return rules, nil
+}
+
+// normalizePhone converts a user supplied phone number to E.164 so the same
+// handset always maps to the same OTP challenge, storage keys and token subject
+func normalizePhone(phones *phone.Parser, raw string) (string, error) {
+	e164, err := phones.Normalize(raw)
+	if err != nil {
+		appErr := errors.NewInvalidRequest("Invalid phone number", err)
+		if verr, ok := err.(*phone.ValidationError); ok {
+			appErr.WithField("phone", verr.Code, verr.Message)
+		}
+		return "", appErr
+	}
+	return e164, nil
+}
+
+// normalizeEmail validates a bare email address and lower-cases it so the
+// same mailbox always maps to the same OTP challenge and token subject
+func normalizeEmail(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != strings.TrimSpace(address) {
+		return "", errors.NewInvalidRequest("Invalid email address", err)
+	}
+	return strings.ToLower(parsed.Address), nil
+}
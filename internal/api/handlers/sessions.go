@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/sessiondata"
+)
+
+const (
+	// maxDeviceNameLength bounds the device name a client can give a session
+	maxDeviceNameLength = 100
+	// maxUserAgentLength bounds the user agent stored for a session
+	maxUserAgentLength = 512
+)
+
+// SessionsHandler lets a signed in user list the devices they are signed in
+// on and sign them out
+type SessionsHandler struct {
+	sessions *auth.SessionManager
+}
+
+func NewSessionsHandler(sessions *auth.SessionManager) *SessionsHandler {
+	return &SessionsHandler{
+		sessions: sessions,
+	}
+}
+
+// List returns the user's active sessions
+func (h *SessionsHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	sessions, err := h.sessions.List(r.Context(), claims.Identifier())
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to list sessions", err))
+		return
+	}
+
+	response := &sessiondata.SessionListResponse{
+		Status:   "success",
+		Sessions: make([]sessiondata.Session, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		methods := session.AuthMethods
+		if methods == nil {
+			methods = []string{}
+		}
+		response.Sessions = append(response.Sessions, sessiondata.Session{
+			ID:          session.ID,
+			DeviceName:  session.Device.Name,
+			UserAgent:   session.Device.UserAgent,
+			IP:          session.Device.IP,
+			AuthMethods: methods,
+			CreatedAt:   session.CreatedAt.UTC(),
+			LastSeenAt:  session.LastSeenAt.UTC(),
+			ExpiresAt:   session.ExpiresAt.UTC(),
+			Current:     session.ID == claims.SessionID,
+		})
+	}
+
+	writeSessionsResponse(w, response)
+}
+
+// Revoke signs out one of the user's sessions
+func (h *SessionsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	found, err := h.sessions.Revoke(r.Context(), claims.Identifier(), mux.Vars(r)["id"])
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to revoke session", err))
+		return
+	}
+	if !found {
+		middleware.ErrorResponse(w, errors.NewNotFound("Session not found", nil))
+		return
+	}
+
+	writeSessionsResponse(w, &sessiondata.RevokeSessionsResponse{
+		Status:  "success",
+		Message: "Session revoked successfully",
+		Revoked: 1,
+	})
+}
+
+// RevokeOthers signs out every session of the user except the current one
+func (h *SessionsHandler) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+
+	revoked, err := h.sessions.RevokeOthers(r.Context(), claims.Identifier(), claims.SessionID)
+	if err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to revoke sessions", err))
+		return
+	}
+
+	writeSessionsResponse(w, &sessiondata.RevokeSessionsResponse{
+		Status:  "success",
+		Message: "Other sessions revoked successfully",
+		Revoked: revoked,
+	})
+}
+
+func writeSessionsResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
+		return
+	}
+}
+
+// clientDevice describes the client making r for the session list
+func clientDevice(r *http.Request, name string) storage.SessionDevice {
+	if len(name) > maxDeviceNameLength {
+		name = name[:maxDeviceNameLength]
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return storage.SessionDevice{
+		Name:      name,
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+	}
+}
+
+// clientIP returns the address r was received from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
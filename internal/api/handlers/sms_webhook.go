@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/services/delivery"
+	"github.com/lmousom/passless-auth/internal/services/sms"
+)
+
+// SMSWebhookHandler receives delivery receipts from SMS providers
+type SMSWebhookHandler struct {
+	webhooks   *sms.Webhooks
+	deliveries *delivery.Queue
+}
+
+func NewSMSWebhookHandler(webhooks *sms.Webhooks, deliveries *delivery.Queue) *SMSWebhookHandler {
+	return &SMSWebhookHandler{
+		webhooks:   webhooks,
+		deliveries: deliveries,
+	}
+}
+
+func (h *SMSWebhookHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	report, err := h.webhooks.Parse(mux.Vars(r)["provider"], r)
+	switch {
+	case err == sms.ErrWebhookNotSupported:
+		middleware.ErrorResponse(w, errors.NewNotFound("Delivery receipts are not enabled for this provider", err))
+		return
+	case err == sms.ErrInvalidSignature:
+		middleware.ErrorResponse(w, errors.NewForbidden("Invalid webhook signature", err))
+		return
+	case err != nil:
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid delivery receipt", err))
+		return
+	}
+
+	// A failure here makes the provider retry the receipt later
+	if err := h.deliveries.HandleReport(r.Context(), report); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to record delivery receipt", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
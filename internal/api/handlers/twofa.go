@@ -3,10 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
 	"github.com/lmousom/passless-auth/internal/errors"
 	"github.com/lmousom/passless-auth/internal/middleware"
 	"github.com/lmousom/passless-auth/internal/storage"
@@ -14,27 +13,34 @@ import (
 )
 
 type TwoFAHandler struct {
+	config       *config.Config
 	twoFAManager *auth.TwoFAManager
+	sessions     *auth.SessionManager
 	redisClient  *storage.RedisClient
 }
 
-func NewTwoFAHandler(twoFAManager *auth.TwoFAManager, redisClient *storage.RedisClient) *TwoFAHandler {
+func NewTwoFAHandler(cfg *config.Config, twoFAManager *auth.TwoFAManager, sessions *auth.SessionManager, redisClient *storage.RedisClient) *TwoFAHandler {
 	return &TwoFAHandler{
+		config:       cfg,
 		twoFAManager: twoFAManager,
+		sessions:     sessions,
 		redisClient:  redisClient,
 	}
 }
 
+// Enable2FA turns on 2FA for the signed in user and returns the secret key
+// to add to their authenticator app
 func (h *TwoFAHandler) Enable2FA(w http.ResponseWriter, r *http.Request) {
-	var req twofa.Enable2FARequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
+	claims, err := authenticate(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
 		return
 	}
+	identifier := claims.Identifier()
 
 	// Check if 2FA is already enabled
 	ctx := r.Context()
-	enabled, err := h.redisClient.GetTwoFAEnabled(ctx, req.Phone)
+	enabled, err := h.redisClient.GetTwoFAEnabled(ctx, identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to check 2FA status", err))
 		return
@@ -45,26 +51,26 @@ func (h *TwoFAHandler) Enable2FA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate secret key
-	secretKey, err := h.twoFAManager.GenerateSecretKey(req.Phone)
+	secretKey, err := h.twoFAManager.GenerateSecretKey(identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to generate 2FA secret key", err))
 		return
 	}
 
 	// Store secret key
-	if err := h.redisClient.SetTwoFASecret(ctx, req.Phone, secretKey); err != nil {
+	if err := h.redisClient.SetTwoFASecret(ctx, identifier, secretKey); err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to store 2FA secret key", err))
 		return
 	}
 
 	// Set 2FA enabled status
-	if err := h.redisClient.SetTwoFAEnabled(ctx, req.Phone, true); err != nil {
+	if err := h.redisClient.SetTwoFAEnabled(ctx, identifier, true); err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to enable 2FA", err))
 		return
 	}
 
 	// Generate QR code
-	qrCode, err := h.twoFAManager.GenerateQRCode(req.Phone, secretKey)
+	qrCode, err := h.twoFAManager.GenerateQRCode(identifier, secretKey)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to generate QR code", err))
 		return
@@ -84,7 +90,21 @@ func (h *TwoFAHandler) Enable2FA(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Verify2FA checks the second factor of a user signed in with a first one,
+// and upgrades their session to a verified one
 func (h *TwoFAHandler) Verify2FA(w http.ResponseWriter, r *http.Request) {
+	// The session is not verified yet, so authenticate would refuse it
+	claims, err := parseTokenCookie(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+	if claims.SessionID == "" || claims.TwoFAVerified {
+		middleware.ErrorResponse(w, errors.NewInvalidRequest("No session awaiting 2FA verification", nil))
+		return
+	}
+	identifier := claims.Identifier()
+
 	var req twofa.Verify2FARequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
@@ -94,7 +114,7 @@ func (h *TwoFAHandler) Verify2FA(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Check if 2FA is enabled
-	enabled, err := h.redisClient.GetTwoFAEnabled(ctx, req.Phone)
+	enabled, err := h.redisClient.GetTwoFAEnabled(ctx, identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to check 2FA status", err))
 		return
@@ -105,14 +125,14 @@ func (h *TwoFAHandler) Verify2FA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get secret key
-	secretKey, err := h.redisClient.GetTwoFASecret(ctx, req.Phone)
+	secretKey, err := h.redisClient.GetTwoFASecret(ctx, identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to get 2FA secret key", err))
 		return
 	}
 
 	// Check attempts
-	attempts, err := h.redisClient.IncrementTwoFAAttempts(ctx, req.Phone)
+	attempts, err := h.redisClient.IncrementTwoFAAttempts(ctx, identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to track 2FA attempts", err))
 		return
@@ -129,35 +149,24 @@ func (h *TwoFAHandler) Verify2FA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Reset attempts on successful verification
-	if err := h.redisClient.ResetTwoFAAttempts(ctx, req.Phone); err != nil {
+	if err := h.redisClient.ResetTwoFAAttempts(ctx, identifier); err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to reset 2FA attempts", err))
 		return
 	}
 
-	// Generate new token with TwoFAVerified set to true
-	claims := &Claims{
-		Phone:         req.Phone,
-		TwoFAEnabled:  true,
-		TwoFAVerified: true,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
+	// Replace the session started before 2FA with one that has TwoFAVerified
+	// set to true, keeping its device and first factor
+	session, err := h.sessions.Upgrade(ctx, claims, auth.MethodTOTP)
+	if err == auth.ErrSessionNotFound {
+		middleware.ErrorResponse(w, errors.NewInvalidToken("Session has ended", err))
+		return
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
-		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to generate token", err))
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to start session", err))
 		return
 	}
 
-	// Set the new token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: time.Now().Add(24 * time.Hour),
-		Path:    "/api/v1",
-	})
+	setSessionCookies(w, h.config, session)
 
 	response := &twofa.Verify2FAResponse{
 		Status:  "success",
@@ -171,7 +180,16 @@ func (h *TwoFAHandler) Verify2FA(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Disable2FA turns off 2FA for the signed in user once they confirm it with
+// a current code
 func (h *TwoFAHandler) Disable2FA(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.sessions.Tokens(), r)
+	if err != nil {
+		middleware.ErrorResponse(w, err)
+		return
+	}
+	identifier := claims.Identifier()
+
 	var req twofa.Disable2FARequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid request body", err))
@@ -181,7 +199,7 @@ func (h *TwoFAHandler) Disable2FA(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	// Check if 2FA is enabled
-	enabled, err := h.redisClient.GetTwoFAEnabled(ctx, req.Phone)
+	enabled, err := h.redisClient.GetTwoFAEnabled(ctx, identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to check 2FA status", err))
 		return
@@ -192,7 +210,7 @@ func (h *TwoFAHandler) Disable2FA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get secret key
-	secretKey, err := h.redisClient.GetTwoFASecret(ctx, req.Phone)
+	secretKey, err := h.redisClient.GetTwoFASecret(ctx, identifier)
 	if err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to get 2FA secret key", err))
 		return
@@ -205,13 +223,13 @@ func (h *TwoFAHandler) Disable2FA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Disable 2FA
-	if err := h.redisClient.SetTwoFAEnabled(ctx, req.Phone, false); err != nil {
+	if err := h.redisClient.SetTwoFAEnabled(ctx, identifier, false); err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to disable 2FA", err))
 		return
 	}
 
 	// Delete secret key
-	if err := h.redisClient.DeleteTwoFASecret(ctx, req.Phone); err != nil {
+	if err := h.redisClient.DeleteTwoFASecret(ctx, identifier); err != nil {
 		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to delete 2FA secret key", err))
 		return
 	}
@@ -4,56 +4,80 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/auth"
 	"github.com/lmousom/passless-auth/internal/errors"
 	"github.com/lmousom/passless-auth/internal/middleware"
 )
 
-func VerificationHandler(w http.ResponseWriter, r *http.Request) {
-	c, err := r.Cookie("token")
+// VerificationHandler reports whether the request carries a valid session
+type VerificationHandler struct {
+	tokens *auth.TokenManager
+}
+
+func NewVerificationHandler(tokens *auth.TokenManager) *VerificationHandler {
+	return &VerificationHandler{
+		tokens: tokens,
+	}
+}
+
+func (h *VerificationHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.tokens, r)
 	if err != nil {
-		if err == http.ErrNoCookie {
-			middleware.ErrorResponse(w, errors.NewUnauthorized("No authentication token provided", nil))
-			return
-		}
-		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid cookie", err))
+		middleware.ErrorResponse(w, err)
 		return
 	}
 
-	tokenStr := c.Value
-	claims := &Claims{}
+	response := map[string]string{
+		"message": "Welcome " + claims.Identifier() + "!",
+		"status":  "success",
+	}
 
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			middleware.ErrorResponse(w, errors.NewUnauthorized("Invalid token signature", err))
-			return
-		}
-		middleware.ErrorResponse(w, errors.NewInvalidRequest("Invalid token", err))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
 		return
 	}
+}
 
-	if !token.Valid {
-		middleware.ErrorResponse(w, errors.NewUnauthorized("Invalid token", nil))
-		return
+// authenticate returns the claims of the request's token cookie. Tokens of
+// users with 2FA enabled are only accepted once 2FA has been verified.
+func authenticate(tokens *auth.TokenManager, r *http.Request) (*auth.Claims, error) {
+	claims, err := parseTokenCookie(tokens, r)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check 2FA status
 	if claims.TwoFAEnabled && !claims.TwoFAVerified {
-		middleware.ErrorResponse(w, errors.NewUnauthorized("2FA verification required", nil))
-		return
+		return nil, errors.NewUnauthorized("2FA verification required", nil)
 	}
+	return claims, nil
+}
 
-	response := map[string]string{
-		"message": "Welcome " + claims.Phone + "!",
-		"status":  "success",
+// parseTokenCookie validates the request's token cookie and returns its claims
+func parseTokenCookie(tokens *auth.TokenManager, r *http.Request) (*auth.Claims, error) {
+	c, err := r.Cookie("token")
+	if err != nil {
+		if err == http.ErrNoCookie {
+			return nil, errors.NewUnauthorized("No authentication token provided", nil)
+		}
+		return nil, errors.NewInvalidRequest("Invalid cookie", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		middleware.ErrorResponse(w, errors.NewInternalServer("Failed to encode response", err))
-		return
+	claims, err := tokens.ValidateToken(r.Context(), c.Value)
+	switch {
+	case err == auth.ErrTokenExpired:
+		return nil, errors.NewTokenExpired("Token has expired", err)
+	case err == auth.ErrTokenRevoked:
+		return nil, errors.NewInvalidToken("Token has been revoked", err)
+	case err == auth.ErrInvalidToken:
+		return nil, errors.NewInvalidToken("Invalid token", err)
+	case err != nil:
+		return nil, errors.NewInternalServer("Failed to validate token", err)
+	}
+	// Access tokens issued to OpenID Connect clients are not sessions
+	if claims.ClientID != "" {
+		return nil, errors.NewInvalidToken("Invalid token", nil)
 	}
+	return claims, nil
 }
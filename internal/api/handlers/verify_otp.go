@@ -4,100 +4,131 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
 	"github.com/lmousom/passless-auth/internal/errors"
 	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/phone"
 	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/otpdata"
 	"github.com/lmousom/passless-auth/models/verifydata"
-	"github.com/lmousom/passless-auth/utils"
 )
 
-var jwtKey = []byte("github.com/lmousom/passless-auth")
-
-type Claims struct {
-	Phone         string `json:"phone"`
-	TwoFAEnabled  bool   `json:"twofa_enabled"`
-	TwoFAVerified bool   `json:"twofa_verified"`
-	jwt.RegisteredClaims
-}
-
 type VerifyOtpHandler struct {
+	config       *config.Config
 	redisClient  *storage.RedisClient
 	twoFAManager *auth.TwoFAManager
+	sessions     *auth.SessionManager
+	phones       *phone.Parser
 }
 
-func NewVerifyOtpHandler(redisClient *storage.RedisClient, twoFAManager *auth.TwoFAManager) *VerifyOtpHandler {
+func NewVerifyOtpHandler(cfg *config.Config, redisClient *storage.RedisClient, twoFAManager *auth.TwoFAManager, sessions *auth.SessionManager, phones *phone.Parser) *VerifyOtpHandler {
 	return &VerifyOtpHandler{
+		config:       cfg,
 		redisClient:  redisClient,
 		twoFAManager: twoFAManager,
+		sessions:     sessions,
+		phones:       phones,
 	}
 }
 
-func (h *VerifyOtpHandler) VerifyOtp(verifyOtpRequest verifydata.VerifyOtpRequest) (*verifydata.VerifyOtpResponse, string, error) {
-	if verifyOtpRequest.Phone == "" || verifyOtpRequest.Hash == "" || verifyOtpRequest.Otp == "" {
-		return nil, "", errors.NewInvalidRequest("Phone, hash, and OTP are required", nil)
+func (h *VerifyOtpHandler) VerifyOtp(ctx context.Context, verifyOtpRequest verifydata.VerifyOtpRequest, device storage.SessionDevice) (*verifydata.VerifyOtpResponse, *auth.Session, error) {
+	if (verifyOtpRequest.Phone == "" && verifyOtpRequest.Email == "") || verifyOtpRequest.ChallengeID == "" || verifyOtpRequest.Otp == "" {
+		return nil, nil, errors.NewInvalidRequest("Phone or email, challenge ID, and OTP are required", nil)
 	}
-
-	// First validate the OTP
-	f := func(c rune) bool {
-		return c == '.'
-	}
-	extValue := strings.FieldsFunc(verifyOtpRequest.Hash, f)
-	if len(extValue) != 2 {
-		return nil, "", errors.NewInvalidRequest("Invalid hash format", nil)
+	if verifyOtpRequest.Phone != "" && verifyOtpRequest.Email != "" {
+		return nil, nil, errors.NewInvalidRequest("Provide either a phone number or an email, not both", nil)
 	}
 
-	hashValue := extValue[0]
-	expiresIn := extValue[1]
-
-	now := time.Now()
-	expiredInTime, err := utils.MsToTime(expiresIn)
-	if err != nil {
-		return nil, "", errors.NewInvalidRequest("Invalid expiry time", err)
+	claims := &auth.Claims{}
+	if verifyOtpRequest.Phone != "" {
+		e164, err := normalizePhone(h.phones, verifyOtpRequest.Phone)
+		if err != nil {
+			return nil, nil, err
+		}
+		claims.Phone = e164
+	} else {
+		address, err := normalizeEmail(verifyOtpRequest.Email)
+		if err != nil {
+			return nil, nil, err
+		}
+		claims.Email = address
 	}
+	recipient := claims.Identifier()
 
-	if now.After(expiredInTime) {
-		return nil, "", errors.NewOTPExpired("OTP has expired", nil)
+	// Refuse to evaluate any code while the account is locked out
+	retryAfter, err := h.redisClient.GetLockout(ctx, recipient)
+	if err != nil {
+		return nil, nil, errors.NewInternalServer("Failed to check lockout status", err)
 	}
-
-	if hashValue != utils.Encrypt([]byte(verifyOtpRequest.Phone+"."+verifyOtpRequest.Otp+"."+expiresIn)) {
-		return nil, "", errors.NewInvalidOTP("Invalid OTP", nil)
+	if retryAfter > 0 {
+		return nil, nil, errors.NewTooManyAttempts("Too many failed attempts, try again later", nil).WithRetryAfter(retryAfter)
 	}
 
-	// Check if 2FA is enabled
-	ctx := context.Background()
-	twoFAEnabled, err := h.redisClient.GetTwoFAEnabled(ctx, verifyOtpRequest.Phone)
+	// Validate and consume the OTP challenge
+	result, err := h.redisClient.VerifyOTPChallenge(ctx, verifyOtpRequest.ChallengeID, recipient, verifyOtpRequest.Otp)
 	if err != nil {
-		return nil, "", errors.NewInternalServer("Failed to check 2FA status", err)
+		return nil, nil, errors.NewInternalServer("Failed to verify OTP", err)
 	}
 
-	// For now, we'll just set TwoFAVerified to false if 2FA is enabled
-	// The actual 2FA verification should happen in a separate endpoint
-	twoFAVerified := !twoFAEnabled
-
-	claims := &Claims{
-		Phone:         verifyOtpRequest.Phone,
-		TwoFAEnabled:  twoFAEnabled,
-		TwoFAVerified: twoFAVerified,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiredInTime),
-		},
+	switch result.Status {
+	case storage.OTPChallengeValid:
+		if err := h.redisClient.ResetFailedAttempts(ctx, recipient); err != nil {
+			return nil, nil, errors.NewInternalServer("Failed to reset failed attempts", err)
+		}
+	case storage.OTPChallengeInvalid:
+		if err := h.recordFailure(ctx, claims); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errors.NewInvalidOTP("Invalid OTP", nil)
+	case storage.OTPChallengeLocked:
+		// Attempts is only set when this request was the one that locked the challenge
+		if result.Attempts > 0 {
+			middleware.RecordLockout("challenge")
+			if err := h.recordFailure(ctx, claims); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, errors.NewTooManyAttempts("Too many attempts for this OTP, request a new one", nil)
+	case storage.OTPChallengeExpired:
+		return nil, nil, errors.NewOTPExpired("OTP has expired", nil)
+	case storage.OTPChallengeUsed:
+		return nil, nil, errors.NewOTPAlreadyUsed("OTP has already been used", nil)
+	default:
+		return nil, nil, errors.NewOTPNotFound("OTP challenge not found", nil)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	session, err := startLoginSession(ctx, h.redisClient, h.sessions, claims, device, []string{auth.MethodOTP})
 	if err != nil {
-		return nil, "", errors.NewInternalServer("Failed to generate token", err)
+		return nil, nil, err
 	}
 
 	return &verifydata.VerifyOtpResponse{
 		Status:  "success",
 		Message: "OTP verified successfully",
-	}, tokenString, nil
+	}, session, nil
+}
+
+// recordFailure counts a wrong code against the account and returns a
+// TooManyAttempts error if it triggered a lockout
+func (h *VerifyOtpHandler) recordFailure(ctx context.Context, claims *auth.Claims) error {
+	channel := otpdata.ChannelSMS
+	if claims.Email != "" {
+		channel = otpdata.ChannelEmail
+	}
+	middleware.RecordLoginFailure(channel)
+
+	lockout, err := h.redisClient.RecordFailedAttempt(ctx, claims.Identifier())
+	if err != nil {
+		return errors.NewInternalServer("Failed to record failed attempt", err)
+	}
+	if lockout > 0 {
+		middleware.RecordLockout("account")
+		return errors.NewTooManyAttempts("Too many failed attempts, try again later", nil).WithRetryAfter(lockout)
+	}
+	return nil
 }
 
 func (h *VerifyOtpHandler) Handle(w http.ResponseWriter, r *http.Request) {
@@ -107,19 +138,14 @@ func (h *VerifyOtpHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, tokenString, err := h.VerifyOtp(verifyOtpRequest)
+	device := clientDevice(r, verifyOtpRequest.DeviceName)
+	response, session, err := h.VerifyOtp(r.Context(), verifyOtpRequest, device)
 	if err != nil {
 		middleware.ErrorResponse(w, err)
 		return
 	}
 
-	// Set the token cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: time.Now().Add(24 * time.Hour), // Set a reasonable expiry time
-		Path:    "/api/v1",
-	})
+	setSessionCookies(w, h.config, session)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -127,3 +153,73 @@ func (h *VerifyOtpHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// startLoginSession starts a session for a user who has completed the first
+// login factor, flagging whether a 2FA step is still required
+func startLoginSession(ctx context.Context, redisClient *storage.RedisClient, sessions *auth.SessionManager, claims *auth.Claims, device storage.SessionDevice, methods []string) (*auth.Session, error) {
+	// Check if 2FA is enabled
+	twoFAEnabled, err := redisClient.GetTwoFAEnabled(ctx, claims.Identifier())
+	if err != nil {
+		return nil, errors.NewInternalServer("Failed to check 2FA status", err)
+	}
+
+	// For now, we'll just set TwoFAVerified to false if 2FA is enabled
+	// The actual 2FA verification should happen in a separate endpoint
+	claims.TwoFAEnabled = twoFAEnabled
+	claims.TwoFAVerified = !twoFAEnabled
+
+	session, err := sessions.Start(ctx, claims, device, methods)
+	if err != nil {
+		return nil, errors.NewInternalServer("Failed to start session", err)
+	}
+	return session, nil
+}
+
+// setSessionCookies sets the access token cookie and the HttpOnly refresh
+// token cookie, each expiring with its token
+func setSessionCookies(w http.ResponseWriter, cfg *config.Config, session *auth.Session) {
+	// Lax rather than Strict so the session reaches /oidc/authorize when a
+	// client redirects the user to it
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    session.AccessToken,
+		Expires:  session.AccessExpiresAt,
+		Path:     "/api/v1",
+		HttpOnly: true,
+		Secure:   cfg.Server.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    session.RefreshToken,
+		Expires:  session.RefreshExpiresAt,
+		Path:     "/api/v1",
+		HttpOnly: true,
+		Secure:   cfg.Server.Environment == "production",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookies removes the access and refresh token cookies
+func clearSessionCookies(w http.ResponseWriter, cfg *config.Config) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    "",
+		Path:     "/api/v1",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.Server.Environment == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    "",
+		Path:     "/api/v1",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.Server.Environment == "production",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
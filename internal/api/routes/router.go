@@ -6,17 +6,27 @@ import (
 	"github.com/lmousom/passless-auth/internal/auth"
 	"github.com/lmousom/passless-auth/internal/config"
 	"github.com/lmousom/passless-auth/internal/middleware"
+	"github.com/lmousom/passless-auth/internal/oidc"
+	"github.com/lmousom/passless-auth/internal/phone"
+	"github.com/lmousom/passless-auth/internal/services/delivery"
+	"github.com/lmousom/passless-auth/internal/services/email"
+	"github.com/lmousom/passless-auth/internal/services/messaging"
 	"github.com/lmousom/passless-auth/internal/services/sms"
+	"github.com/lmousom/passless-auth/internal/services/voice"
 	"github.com/lmousom/passless-auth/internal/storage"
 )
 
-func SetupRouter(cfg *config.Config) (*mux.Router, error) {
+// SetupRouter builds the HTTP routes. Most components use the configuration
+// as it was at startup; the country policy follows live reloads. The returned
+// stop function shuts down the background delivery workers.
+func SetupRouter(cfgManager *config.ConfigManager) (*mux.Router, func(), error) {
+	cfg := cfgManager.GetConfig()
 	r := mux.NewRouter()
 
 	// Middleware
-	rateLimiter, err := middleware.RateLimiter()
+	rateLimiter, err := middleware.RateLimiter(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Apply security middleware
@@ -35,36 +45,148 @@ func SetupRouter(cfg *config.Config) (*mux.Router, error) {
 	}
 
 	// Initialize services
-	smsService, err := sms.NewTwilioService(cfg)
+	smsService, err := sms.NewProvider(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Email is an optional second OTP channel
+	var emailSender email.Sender
+	if cfg.Email.Enabled {
+		smtpSender, err := email.NewSMTPSender(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		emailSender = smtpSender
+	}
+
+	// Voice calls are an optional channel for phone numbers
+	var caller voice.Caller
+	if cfg.Voice.Enabled {
+		twilioCaller, err := voice.NewTwilioCaller(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		caller = twilioCaller
+	}
+
+	// WhatsApp and Telegram channels
+	messengers, err := messaging.NewMessengers(cfg)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Initialize Redis client
 	redisClient, err := storage.NewRedisClient(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	smsGuard := sms.NewGuard(cfg, redisClient)
+	phones := phone.NewParser(cfg)
+	countries := phone.NewCountryPolicy(cfgManager)
+	smsProviders := sms.NewPool(cfg, smsService)
+
+	// Magic links sent by SMS are rendered from their own template set
+	var magicLinks *sms.Templates
+	if cfg.MagicLink.Enabled {
+		if magicLinks, err = sms.NewMagicLinkTemplates(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Start the OTP delivery workers
+	deliveries := delivery.NewQueue(cfg, redisClient, smsProviders, smsGuard, magicLinks, caller, messengers, emailSender)
+	if err := deliveries.Start(); err != nil {
+		return nil, nil, err
 	}
 
 	// Initialize handlers
-	sendOtpHandler := handlers.NewSendOtpHandler(smsService)
+	sendOtpHandler := handlers.NewSendOtpHandler(cfg, deliveries, smsGuard, phones, countries, redisClient)
+	deliveryHandler := handlers.NewDeliveryHandler(cfg, deliveries)
+	webhooks, err := sms.NewWebhooks(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	smsWebhookHandler := handlers.NewSMSWebhookHandler(webhooks, deliveries)
+	tokens, err := auth.NewTokenManager(cfg, redisClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	channelPreferenceHandler := handlers.NewChannelPreferenceHandler(cfg, redisClient, tokens)
+	sessions := auth.NewSessionManager(cfg, tokens, redisClient)
 	twoFAManager := auth.NewTwoFAManager(cfg)
-	verifyOtpHandler := handlers.NewVerifyOtpHandler(redisClient, twoFAManager)
-	twoFAHandler := handlers.NewTwoFAHandler(twoFAManager, redisClient)
+	verifyOtpHandler := handlers.NewVerifyOtpHandler(cfg, redisClient, twoFAManager, sessions, phones)
+	twoFAHandler := handlers.NewTwoFAHandler(cfg, twoFAManager, sessions, redisClient)
+	verificationHandler := handlers.NewVerificationHandler(tokens)
+	refreshTokenHandler := handlers.NewRefreshTokenHandler(cfg, sessions)
+	logoutHandler := handlers.NewLogoutHandler(cfg, sessions)
+	sessionsHandler := handlers.NewSessionsHandler(sessions)
+	jwksHandler := handlers.NewJWKSHandler(tokens)
+
+	// Public keys for services that verify our tokens
+	r.HandleFunc("/.well-known/jwks.json", jwksHandler.Handle).Methods("GET")
 
 	// API routes
 	api := r.PathPrefix("/api/v1").Subrouter()
 	api.HandleFunc("/sendOtp", sendOtpHandler.Handle).Methods("POST")
+	api.HandleFunc("/deliveries/{id}", deliveryHandler.Handle).Methods("GET")
 	api.HandleFunc("/verifyOtp", verifyOtpHandler.Handle).Methods("POST")
-	api.HandleFunc("/login", handlers.VerificationHandler).Methods("GET")
-	api.HandleFunc("/refreshToken", handlers.RefreshTokenHandler).Methods("POST")
-	api.HandleFunc("/logout", handlers.LogoutHandler).Methods("POST")
+	api.HandleFunc("/login", verificationHandler.Handle).Methods("GET")
+	api.HandleFunc("/refreshToken", refreshTokenHandler.Handle).Methods("POST")
+	api.HandleFunc("/logout", logoutHandler.Handle).Methods("POST")
+	api.HandleFunc("/logout/all", logoutHandler.HandleAll).Methods("POST")
+	api.HandleFunc("/sessions", sessionsHandler.List).Methods("GET")
+	api.HandleFunc("/sessions", sessionsHandler.RevokeOthers).Methods("DELETE")
+	api.HandleFunc("/sessions/{id}", sessionsHandler.Revoke).Methods("DELETE")
 	api.HandleFunc("/health", handlers.HealthCheckHandler).Methods("GET")
+	api.HandleFunc("/preferences/channel", channelPreferenceHandler.Get).Methods("GET")
+	api.HandleFunc("/preferences/channel", channelPreferenceHandler.Set).Methods("PUT")
+
+	// Delivery receipts from SMS providers
+	if cfg.SMS.Webhooks.Enabled {
+		api.HandleFunc("/webhooks/sms/{provider}", smsWebhookHandler.Handle).Methods("GET", "POST")
+	}
+
+	// Magic link routes
+	if cfg.MagicLink.Enabled {
+		magicLinkHandler := handlers.NewMagicLinkHandler(cfg, deliveries, smsGuard, phones, countries, redisClient, sessions)
+		api.HandleFunc("/sendMagicLink", magicLinkHandler.SendMagicLink).Methods("POST")
+		api.HandleFunc("/magic/{token}", magicLinkHandler.ConsumeMagicLink).Methods("GET")
+	}
+
+	// Development inbox for messages captured by the sink SMS providers. It
+	// hands out every code sent, so it is only served in development.
+	if cfg.Server.Environment == "development" && sms.UsesSink(cfg) {
+		devInboxHandler := handlers.NewDevInboxHandler(sms.DevInbox(), phones)
+		api.HandleFunc("/dev/inbox/{phone}", devInboxHandler.Handle).Methods("GET")
+	}
+
+	// OpenID Connect provider routes
+	if cfg.OIDC.Enabled {
+		provider, err := oidc.NewProvider(cfg, tokens, redisClient)
+		if err != nil {
+			return nil, nil, err
+		}
+		oidcHandler := handlers.NewOIDCHandler(provider, tokens)
+		r.HandleFunc(oidc.DiscoveryPath, oidcHandler.Discovery).Methods("GET")
+		r.HandleFunc(oidc.AuthorizePath, oidcHandler.Authorize).Methods("GET", "POST")
+		r.HandleFunc(oidc.TokenPath, oidcHandler.Token).Methods("POST")
+		r.HandleFunc(oidc.UserInfoPath, oidcHandler.UserInfo).Methods("GET", "POST")
+
+		// Device authorization grant for CLIs and kiosks
+		if cfg.OIDC.Device.Enabled {
+			deviceHandler := handlers.NewDeviceHandler(provider, tokens, redisClient)
+			r.HandleFunc(oidc.DeviceAuthorizationPath, oidcHandler.DeviceAuthorization).Methods("POST")
+			api.HandleFunc("/oidc/device", deviceHandler.Get).Methods("GET")
+			api.HandleFunc("/oidc/device", deviceHandler.Verify).Methods("POST")
+		}
+	}
 
 	// 2FA routes
 	api.HandleFunc("/2fa/enable", twoFAHandler.Enable2FA).Methods("POST")
 	api.HandleFunc("/2fa/verify", twoFAHandler.Verify2FA).Methods("POST")
 	api.HandleFunc("/2fa/disable", twoFAHandler.Disable2FA).Methods("POST")
 
-	return r, nil
+	return r, deliveries.Stop, nil
 }
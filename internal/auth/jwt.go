@@ -1,44 +1,305 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/storage"
 )
 
+const (
+	// minSecretLength is the shortest HS256 secret accepted, matching the hash size
+	minSecretLength = 32
+	// tokenLeeway tolerates clock differences between instances
+	tokenLeeway = 5 * time.Second
+	// keyringReloadInterval is how often the keyring file is checked for changes
+	keyringReloadInterval = 30 * time.Second
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, forged or
+	// issued for another issuer or audience
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned for tokens past their expiry
+	ErrTokenExpired = errors.New("token has expired")
+	// ErrTokenRevoked is returned for tokens revoked by logging out
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+// Claims are the claims of a session token
+type Claims struct {
+	Phone         string `json:"phone,omitempty"`
+	Email         string `json:"email,omitempty"`
+	TwoFAEnabled  bool   `json:"twofa_enabled"`
+	TwoFAVerified bool   `json:"twofa_verified"`
+	// SessionID identifies the session the token was issued for
+	SessionID string `json:"sid,omitempty"`
+	// Generation is the user's token generation when the token was issued;
+	// logging out everywhere starts a new generation
+	Generation int64 `json:"gen,omitempty"`
+	// ClientID and Scope are set on tokens issued to OpenID Connect clients
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Identifier returns the phone number or email address the token was issued to
+func (c *Claims) Identifier() string {
+	if c.Phone != "" {
+		return c.Phone
+	}
+	return c.Email
+}
+
+// TokenManager issues and validates session tokens signed with the
+// configured JWT secret or private key, or with the active key of a keyring
 type TokenManager struct {
-	config *config.Config
+	config      *config.Config
+	revocations *revocationList
+
+	mu     sync.RWMutex
+	active *signingKey
+	// keys verify tokens, newest first
+	keys        []*signingKey
+	keyringHash [sha256.Size]byte
+	checkedAt   time.Time
 }
 
-func (tm *TokenManager) GenerateToken(phone string) (string, error) {
-	claims := &jwt.RegisteredClaims{
-		Subject:   phone,
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(tm.config.JWT.TokenLifetime)),
-		Issuer:    "passless-auth",
-		NotBefore: jwt.NewNumericDate(time.Now()),
+func NewTokenManager(cfg *config.Config, redisClient *storage.RedisClient) (*TokenManager, error) {
+	tm := &TokenManager{
+		config:      cfg,
+		revocations: newRevocationList(redisClient, cfg.JWT.Revocation.CacheSize, cfg.JWT.Revocation.CacheTTL),
 	}
 
-	secret, err := tm.config.GetDecryptedJWTSecret()
+	if cfg.JWT.KeyringFile != "" {
+		if err := tm.loadKeyring(); err != nil {
+			return nil, err
+		}
+		return tm, nil
+	}
+
+	key, err := loadSigningKey(cfg)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	tm.active = key
+	tm.keys = []*signingKey{key}
+	return tm, nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+// Lifetime returns how long issued access tokens are valid for
+func (tm *TokenManager) Lifetime() time.Duration {
+	return tm.config.JWT.TokenLifetime
 }
 
-func (tm *TokenManager) ValidateToken(tokenString string) (*jwt.Token, error) {
-	secret, err := tm.config.GetDecryptedJWTSecret()
+// GenerateToken signs a token for claims. The registered claims are replaced
+// with a new ID, issuer, audience and validity period, and the token is
+// issued in the user's current token generation.
+func (tm *TokenManager) GenerateToken(ctx context.Context, claims *Claims) (string, time.Time, error) {
+	id, err := tokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	generation, err := tm.revocations.generation(ctx, claims.Identifier())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claims.Generation = generation
+
+	now := time.Now()
+	expiresAt := now.Add(tm.config.JWT.TokenLifetime)
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        id,
+		Issuer:    tm.config.JWT.Issuer,
+		Subject:   claims.Identifier(),
+		Audience:  jwt.ClaimStrings{tm.config.JWT.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	signed, err := tm.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// Sign signs claims as they are with the active key
+func (tm *TokenManager) Sign(claims jwt.Claims) (string, error) {
+	active, _ := tm.keyset()
+	token := jwt.NewWithClaims(active.method, claims)
+	if active.id != "" {
+		token.Header["kid"] = active.id
+	}
+	signed, err := token.SignedString(active.sign)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+	return signed, nil
+}
+
+// Algorithm returns the algorithm new tokens are signed with
+func (tm *TokenManager) Algorithm() string {
+	active, _ := tm.keyset()
+	return active.method.Alg()
+}
+
+// ValidateToken verifies the signature and registered claims of tokenString,
+// checks that it has not been revoked, and returns its claims. It returns
+// ErrTokenExpired, ErrInvalidToken or ErrTokenRevoked for unusable tokens.
+func (tm *TokenManager) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
+	_, keys := tm.keyset()
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Select the key by kid; its algorithm must match the token's
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range keys {
+			if key.id == kid && key.method.Alg() == token.Method.Alg() {
+				return key.verify, nil
+			}
+		}
+		return nil, ErrInvalidToken
+	},
+		jwt.WithIssuer(tm.config.JWT.Issuer),
+		jwt.WithAudience(tm.config.JWT.Audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
+	if err != nil || claims.ID == "" || claims.Subject != claims.Identifier() {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := tm.revocations.revoked(ctx, claims)
 	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// RevokeToken revokes the token claims were issued in until it expires
+func (tm *TokenManager) RevokeToken(ctx context.Context, claims *Claims) error {
+	return tm.revocations.revoke(ctx, claims)
+}
+
+// RevokeSession revokes every access token of session id. It is called once
+// the session has ended, as tokens issued for it later are rejected too.
+func (tm *TokenManager) RevokeSession(ctx context.Context, id string) error {
+	return tm.revocations.revokeSession(ctx, id, tm.config.JWT.TokenLifetime)
+}
+
+// RevokeAll revokes every token issued to identifier so far by starting a
+// new token generation, and returns the new generation
+func (tm *TokenManager) RevokeAll(ctx context.Context, identifier string) (int64, error) {
+	return tm.revocations.revokeAll(ctx, identifier)
+}
+
+// Generation returns the current token generation of identifier
+func (tm *TokenManager) Generation(ctx context.Context, identifier string) (int64, error) {
+	return tm.revocations.generation(ctx, identifier)
+}
 
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
+// JWKS returns the public keys tokens can be verified with, including keys
+// about to be promoted. HMAC secrets are never published.
+func (tm *TokenManager) JWKS() *JWKSet {
+	_, keys := tm.keyset()
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range keys {
+		if key.jwk != nil {
+			set.Keys = append(set.Keys, *key.jwk)
 		}
-		return []byte(secret), nil
-	})
+	}
+	return set
+}
+
+// keyset returns the signing key and the verification keys, picking up
+// changes to the keyring file
+func (tm *TokenManager) keyset() (*signingKey, []*signingKey) {
+	tm.mu.RLock()
+	due := tm.config.JWT.KeyringFile != "" && time.Since(tm.checkedAt) >= keyringReloadInterval
+	tm.mu.RUnlock()
+
+	if due {
+		if err := tm.loadKeyring(); err != nil {
+			// Keep using the keys loaded before
+			log.Printf("Failed to reload JWT keyring: %v", err)
+		}
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.active, tm.keys
+}
+
+// loadKeyring loads the keyring file if it changed since it was last loaded
+func (tm *TokenManager) loadKeyring() error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if tm.active != nil && time.Since(tm.checkedAt) < keyringReloadInterval {
+		return nil // Reloaded by another request
+	}
+	tm.checkedAt = time.Now()
+
+	path := tm.config.JWT.KeyringFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	if hash == tm.keyringHash {
+		return nil
+	}
+
+	keyring, err := parseKeyring(data)
+	if err != nil {
+		return err
+	}
+
+	var active *signingKey
+	var keys []*signingKey
+	for _, entry := range keyring.Usable() {
+		key, err := entry.signingKey()
+		if err != nil {
+			return err
+		}
+		if entry.State == KeyActive {
+			active = key
+		}
+		keys = append(keys, key)
+	}
+	if active == nil {
+		return fmt.Errorf("keyring %s has no active key", path)
+	}
+
+	tm.active = active
+	tm.keys = keys
+	tm.keyringHash = hash
+	return nil
+}
+
+// tokenID returns a random jti
+func tokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// KeyState is the lifecycle state of a keyring key
+type KeyState string
+
+const (
+	// KeyActive signs new tokens. At most one key is active.
+	KeyActive KeyState = "active"
+	// KeyVerify only verifies tokens: a new key waiting to be promoted, or an
+	// old key whose tokens may still be valid
+	KeyVerify KeyState = "verify"
+	// KeyRetired is no longer used and its key material has been removed
+	KeyRetired KeyState = "retired"
+)
+
+// generatedRSAKeyBits is the size of RSA keys added to a keyring
+const generatedRSAKeyBits = 3072
+
+// KeyringKey is a signing key and its rotation history
+type KeyringKey struct {
+	ID        string   `json:"id"`
+	Algorithm string   `json:"algorithm"`
+	State     KeyState `json:"state"`
+	// Key is the PEM encoded private key, or the HMAC secret, encrypted
+	// with the configuration encryption key
+	Key           string    `json:"key,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ActivatedAt   time.Time `json:"activated_at,omitzero"`
+	DeactivatedAt time.Time `json:"deactivated_at,omitzero"`
+	RetiredAt     time.Time `json:"retired_at,omitzero"`
+}
+
+// Keyring holds the keys tokens are signed and verified with, so that the
+// signing key can be replaced without invalidating issued tokens
+type Keyring struct {
+	Keys []*KeyringKey `json:"keys"`
+}
+
+// LoadKeyring reads a keyring file. A missing file is an empty keyring.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Keyring{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	return parseKeyring(data)
+}
+
+func parseKeyring(data []byte) (*Keyring, error) {
+	var keyring Keyring
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring: %w", err)
+	}
+	return &keyring, nil
+}
+
+// Save writes the keyring to path, replacing the file atomically so that a
+// server never reads a partial keyring
+func (k *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode keyring: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return nil
+}
+
+// Active returns the key that signs new tokens, or nil
+func (k *Keyring) Active() *KeyringKey {
+	for _, key := range k.Keys {
+		if key.State == KeyActive {
+			return key
+		}
+	}
+	return nil
+}
+
+// Get returns the key with id, or nil
+func (k *Keyring) Get(id string) *KeyringKey {
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Add generates a verify-only key for alg. It is published in the JWKS but
+// does not sign tokens until it is promoted.
+func (k *Keyring) Add(alg string, now time.Time) (*KeyringKey, error) {
+	material, err := generateKeyMaterial(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &config.EncryptedValue{}
+	if err := ev.Encrypt(material); err != nil {
+		return nil, fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	key := &KeyringKey{
+		ID:        config.GenerateKeyID(),
+		Algorithm: alg,
+		State:     KeyVerify,
+		Key:       ev.Value,
+		CreatedAt: now,
+	}
+	k.Keys = append(k.Keys, key)
+	return key, nil
+}
+
+// Import adds the key configured under jwt, so that tokens issued before
+// the keyring was introduced stay valid. It becomes the active key if there
+// is none.
+func (k *Keyring) Import(cfg *config.Config, now time.Time) (*KeyringKey, error) {
+	material, err := configuredKeyMaterial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	signing, err := newSigningKey(cfg.JWT.Algorithm, material, cfg.JWT.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if k.Get(signing.id) != nil {
+		return nil, fmt.Errorf("key %q is already in the keyring", signing.id)
+	}
+
+	ev := &config.EncryptedValue{}
+	if err := ev.Encrypt(material); err != nil {
+		return nil, fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	key := &KeyringKey{
+		ID:        signing.id,
+		Algorithm: signing.method.Alg(),
+		State:     KeyVerify,
+		Key:       ev.Value,
+		CreatedAt: now,
+	}
+	if k.Active() == nil {
+		key.State = KeyActive
+		key.ActivatedAt = now
+	} else {
+		key.DeactivatedAt = now
+	}
+	k.Keys = append(k.Keys, key)
+	return key, nil
+}
+
+// Promote makes the key with id the active key. The previously active key
+// keeps verifying the tokens it signed.
+func (k *Keyring) Promote(id string, now time.Time) error {
+	key := k.Get(id)
+	switch {
+	case key == nil:
+		return fmt.Errorf("key %s not found", id)
+	case key.State == KeyActive:
+		return nil
+	case key.State != KeyVerify:
+		return fmt.Errorf("key %s is %s", id, key.State)
+	}
+
+	if active := k.Active(); active != nil {
+		active.State = KeyVerify
+		active.DeactivatedAt = now
+	}
+	key.State = KeyActive
+	key.ActivatedAt = now
+	return nil
+}
+
+// Retire removes the key material of keys that stopped signing at least
+// maxTokenLifetime ago, as no token they signed can still be valid. It
+// returns the IDs of the retired keys.
+func (k *Keyring) Retire(maxTokenLifetime time.Duration, now time.Time) []string {
+	var retired []string
+	for _, key := range k.Keys {
+		if key.State != KeyVerify || key.DeactivatedAt.IsZero() {
+			continue
+		}
+		if now.Sub(key.DeactivatedAt) < maxTokenLifetime+tokenLeeway {
+			continue
+		}
+		key.State = KeyRetired
+		key.Key = ""
+		key.RetiredAt = now
+		retired = append(retired, key.ID)
+	}
+	return retired
+}
+
+// Pending returns the newest key that was added but never active, or nil
+func (k *Keyring) Pending() *KeyringKey {
+	var pending *KeyringKey
+	for _, key := range k.Keys {
+		if key.State == KeyVerify && key.ActivatedAt.IsZero() && (pending == nil || key.CreatedAt.After(pending.CreatedAt)) {
+			pending = key
+		}
+	}
+	return pending
+}
+
+// Usable returns the active and verify-only keys, newest first
+func (k *Keyring) Usable() []*KeyringKey {
+	var keys []*KeyringKey
+	for _, key := range k.Keys {
+		if key.State == KeyActive || key.State == KeyVerify {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// signingKey decrypts the key for signing and verification
+func (key *KeyringKey) signingKey() (*signingKey, error) {
+	ev := &config.EncryptedValue{Value: key.Key}
+	material, err := ev.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %w", key.ID, err)
+	}
+
+	signing, err := newSigningKey(key.Algorithm, material, key.ID)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", key.ID, err)
+	}
+	return signing, nil
+}
+
+// generateKeyMaterial returns a new PEM encoded private key for alg, or a
+// random secret for HS256
+func generateKeyMaterial(alg string) (string, error) {
+	var private interface{}
+	var err error
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, minSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return "", fmt.Errorf("failed to generate secret: %w", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(secret), nil
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, generatedRSAKeyBits)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s key: %w", alg, err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s key: %w", alg, err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// Rotation is the outcome of a scheduled rotation step
+type Rotation struct {
+	Added    string
+	Promoted string
+	Retired  []string
+}
+
+// Rotate runs one step of scheduled rotation. A new key is added
+// publishDelay before the active key has signed for interval, so that
+// services caching the JWKS know it before it is promoted; it is promoted
+// once the interval has elapsed. Keys whose tokens have all expired are
+// retired. A keyring without an active key gets one straight away.
+func (k *Keyring) Rotate(alg string, interval, publishDelay, maxTokenLifetime time.Duration, now time.Time) (*Rotation, error) {
+	rotation := &Rotation{Retired: k.Retire(maxTokenLifetime, now)}
+
+	active := k.Active()
+	pending := k.Pending()
+
+	if pending == nil && (active == nil || now.Sub(active.ActivatedAt) >= interval-publishDelay) {
+		key, err := k.Add(alg, now)
+		if err != nil {
+			return nil, err
+		}
+		rotation.Added = key.ID
+		pending = key
+	}
+
+	if pending == nil {
+		return rotation, nil
+	}
+	// Without an active key no tokens can be issued, so promote at once
+	if active == nil || (now.Sub(active.ActivatedAt) >= interval && now.Sub(pending.CreatedAt) >= publishDelay) {
+		if err := k.Promote(pending.ID, now); err != nil {
+			return nil, err
+		}
+		rotation.Promoted = pending.ID
+	}
+	return rotation, nil
+}
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// setEncryptionKey sets a configuration encryption key for the test, which
+// keyring keys are encrypted with
+func setEncryptionKey(t *testing.T) {
+	t.Helper()
+	key, err := config.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("GenerateEncryptionKey: %v", err)
+	}
+	t.Setenv(config.EncryptionKeyEnv, key)
+}
+
+func addKey(t *testing.T, k *Keyring, now time.Time) *KeyringKey {
+	t.Helper()
+	key, err := k.Add("ES256", now)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	return key
+}
+
+func TestKeyringPromoteAndRetire(t *testing.T) {
+	setEncryptionKey(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	lifetime := 15 * time.Minute
+
+	k := &Keyring{}
+	first := addKey(t, k, t0)
+	if first.State != KeyVerify || k.Active() != nil {
+		t.Fatalf("added key is %s, want verify-only", first.State)
+	}
+	if k.Pending() != first {
+		t.Errorf("Pending() = %v, want the added key", k.Pending())
+	}
+	if err := k.Promote(first.ID, t0); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+
+	t1 := t0.Add(24 * time.Hour)
+	second := addKey(t, k, t1)
+	if err := k.Promote(second.ID, t1); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if k.Active() != second {
+		t.Errorf("Active() = %s, want %s", k.Active().ID, second.ID)
+	}
+	if first.State != KeyVerify || !first.DeactivatedAt.Equal(t1) {
+		t.Errorf("previous key is %s deactivated at %v, want verify at %v", first.State, first.DeactivatedAt, t1)
+	}
+	if k.Pending() != nil {
+		t.Errorf("Pending() = %s, want none: the previous key was active before", k.Pending().ID)
+	}
+	if usable := k.Usable(); len(usable) != 2 || usable[0] != second || usable[1] != first {
+		t.Errorf("Usable() = %v, want the new key then the previous one", usable)
+	}
+
+	// Tokens signed just before the promotion stay valid for their lifetime
+	// plus the clock leeway
+	if retired := k.Retire(lifetime, t1.Add(lifetime)); len(retired) != 0 {
+		t.Errorf("Retire() at the token lifetime = %v, want none", retired)
+	}
+	retired := k.Retire(lifetime, t1.Add(lifetime+tokenLeeway))
+	if len(retired) != 1 || retired[0] != first.ID {
+		t.Fatalf("Retire() = %v, want [%s]", retired, first.ID)
+	}
+	if first.State != KeyRetired || first.Key != "" || !first.RetiredAt.Equal(t1.Add(lifetime+tokenLeeway)) {
+		t.Errorf("retired key is %s with key %q retired at %v", first.State, first.Key, first.RetiredAt)
+	}
+	if second.State != KeyActive {
+		t.Errorf("active key is %s after Retire, want active", second.State)
+	}
+	if usable := k.Usable(); len(usable) != 1 || usable[0] != second {
+		t.Errorf("Usable() = %v, want only the active key", usable)
+	}
+
+	if err := k.Promote(first.ID, t1); err == nil {
+		t.Error("Promote() of a retired key succeeded")
+	}
+	if err := k.Promote("missing", t1); err == nil {
+		t.Error("Promote() of an unknown key succeeded")
+	}
+}
+
+func TestKeyringRotate(t *testing.T) {
+	setEncryptionKey(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	interval := 24 * time.Hour
+	publishDelay := time.Hour
+	lifetime := 15 * time.Minute
+
+	k := &Keyring{}
+	rotate := func(now time.Time) *Rotation {
+		t.Helper()
+		rotation, err := k.Rotate("ES256", interval, publishDelay, lifetime, now)
+		if err != nil {
+			t.Fatalf("Rotate() error = %v", err)
+		}
+		return rotation
+	}
+
+	// An empty keyring gets an active key straight away
+	r := rotate(t0)
+	if r.Added == "" || r.Promoted != r.Added {
+		t.Fatalf("Rotate() on an empty keyring = %+v, want a key added and promoted", r)
+	}
+	first := k.Get(r.Added)
+
+	if r := rotate(t0.Add(interval - publishDelay - time.Minute)); r.Added != "" || r.Promoted != "" {
+		t.Errorf("Rotate() before the publish delay = %+v, want no change", r)
+	}
+
+	// The next key is published ahead of its promotion
+	r = rotate(t0.Add(interval - publishDelay))
+	if r.Added == "" || r.Promoted != "" {
+		t.Fatalf("Rotate() at the publish delay = %+v, want a key added only", r)
+	}
+	second := k.Get(r.Added)
+	if k.Active() != first || k.Pending() != second {
+		t.Errorf("after publishing, active = %s and pending = %v", k.Active().ID, k.Pending())
+	}
+
+	if r := rotate(t0.Add(interval - time.Minute)); r.Added != "" || r.Promoted != "" {
+		t.Errorf("Rotate() before the interval = %+v, want no change", r)
+	}
+
+	r = rotate(t0.Add(interval))
+	if r.Added != "" || r.Promoted != second.ID || len(r.Retired) != 0 {
+		t.Fatalf("Rotate() at the interval = %+v, want %s promoted", r, second.ID)
+	}
+	if first.State != KeyVerify {
+		t.Errorf("previous key is %s, want verify", first.State)
+	}
+
+	r = rotate(t0.Add(interval + lifetime + tokenLeeway))
+	if len(r.Retired) != 1 || r.Retired[0] != first.ID || r.Added != "" || r.Promoted != "" {
+		t.Errorf("Rotate() after the token lifetime = %+v, want %s retired", r, first.ID)
+	}
+}
+
+func TestKeyringPublishesBeforePromoting(t *testing.T) {
+	setEncryptionKey(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	k := &Keyring{}
+	first := addKey(t, k, t0)
+	if err := k.Promote(first.ID, t0); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+
+	// A rotation that was not run during the publish window adds the key
+	// but holds back its promotion for the full delay
+	late := t0.Add(48 * time.Hour)
+	r, err := k.Rotate("ES256", 24*time.Hour, time.Hour, 15*time.Minute, late)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if r.Added == "" || r.Promoted != "" {
+		t.Fatalf("Rotate() = %+v, want a key added only", r)
+	}
+	added := r.Added
+
+	r, err = k.Rotate("ES256", 24*time.Hour, time.Hour, 15*time.Minute, late.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if r.Promoted != added || k.Active().ID != added {
+		t.Errorf("Rotate() after the publish delay = %+v, want the new key promoted", r)
+	}
+}
+
+func newKeyringTokenManager(t *testing.T, path string) *TokenManager {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	cfg := &config.Config{}
+	cfg.Redis.Host, cfg.Redis.Port, _ = strings.Cut(mr.Addr(), ":")
+	cfg.JWT.KeyringFile = path
+	cfg.JWT.TokenLifetime = 15 * time.Minute
+	cfg.JWT.Issuer = "passless-auth"
+	cfg.JWT.Audience = "passless-auth"
+	cfg.JWT.Revocation.CacheSize = 100
+	cfg.JWT.Revocation.CacheTTL = time.Second
+
+	redisClient, err := storage.NewRedisClient(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	tm, err := NewTokenManager(cfg, redisClient)
+	if err != nil {
+		t.Fatalf("NewTokenManager() error = %v", err)
+	}
+	return tm
+}
+
+// reload saves k and makes tm pick it up without waiting for the reload
+// interval
+func reload(t *testing.T, tm *TokenManager, k *Keyring, path string) {
+	t.Helper()
+	if err := k.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	tm.mu.Lock()
+	tm.checkedAt = time.Time{}
+	tm.mu.Unlock()
+	if err := tm.loadKeyring(); err != nil {
+		t.Fatalf("loadKeyring() error = %v", err)
+	}
+}
+
+func TestTokenManagerValidatesAcrossKeyStates(t *testing.T) {
+	setEncryptionKey(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	now := time.Now()
+
+	k := &Keyring{}
+	first := addKey(t, k, now)
+	if err := k.Promote(first.ID, now); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if err := k.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	tm := newKeyringTokenManager(t, path)
+
+	oldToken, _, err := tm.GenerateToken(ctx, &Claims{Phone: "+14155550100"})
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	// A pending key is published but does not sign
+	second := addKey(t, k, now)
+	reload(t, tm, k, path)
+	if len(tm.JWKS().Keys) != 2 {
+		t.Errorf("JWKS has %d keys, want the active and pending keys", len(tm.JWKS().Keys))
+	}
+	pendingToken, _, err := tm.GenerateToken(ctx, &Claims{Phone: "+14155550100"})
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	if kid := tokenKID(t, pendingToken); kid != first.ID {
+		t.Errorf("token signed with %s before promotion, want %s", kid, first.ID)
+	}
+
+	// Tokens signed by the previous key validate after the promotion
+	if err := k.Promote(second.ID, now); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	reload(t, tm, k, path)
+	newToken, _, err := tm.GenerateToken(ctx, &Claims{Phone: "+14155550100"})
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+	if kid := tokenKID(t, newToken); kid != second.ID {
+		t.Errorf("token signed with %s after promotion, want %s", kid, second.ID)
+	}
+	if _, err := tm.ValidateToken(ctx, oldToken); err != nil {
+		t.Errorf("ValidateToken() of a token signed by a verify-only key error = %v", err)
+	}
+
+	// Once retired, tokens signed by the previous key are rejected
+	if retired := k.Retire(tm.Lifetime(), now.Add(tm.Lifetime()+tokenLeeway)); len(retired) != 1 {
+		t.Fatalf("Retire() = %v, want the previous key", retired)
+	}
+	reload(t, tm, k, path)
+	if _, err := tm.ValidateToken(ctx, oldToken); err != ErrInvalidToken {
+		t.Errorf("ValidateToken() of a token signed by a retired key error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := tm.ValidateToken(ctx, newToken); err != nil {
+		t.Errorf("ValidateToken() of a token signed by the active key error = %v", err)
+	}
+	if len(tm.JWKS().Keys) != 1 {
+		t.Errorf("JWKS has %d keys after retiring, want 1", len(tm.JWKS().Keys))
+	}
+}
+
+func TestTokenManagerRejectsUnknownKID(t *testing.T) {
+	setEncryptionKey(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	now := time.Now()
+
+	k := &Keyring{}
+	key := addKey(t, k, now)
+	if err := k.Promote(key.ID, now); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if err := k.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// A token from a keyring this instance has never seen
+	other := &Keyring{}
+	otherKey := addKey(t, other, now)
+	if err := other.Promote(otherKey.ID, now); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	otherPath := filepath.Join(t.TempDir(), "keyring.json")
+	if err := other.Save(otherPath); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	token, _, err := newKeyringTokenManager(t, otherPath).GenerateToken(ctx, &Claims{Phone: "+14155550100"})
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	if _, err := newKeyringTokenManager(t, path).ValidateToken(ctx, token); err != ErrInvalidToken {
+		t.Errorf("ValidateToken() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// tokenKID returns the kid header of token without verifying it
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
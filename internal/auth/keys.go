@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// minRSAKeyBits is the smallest RSA modulus accepted for RS256
+const minRSAKeyBits = 2048
+
+// signingKey is a key tokens are signed and verified with
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	// sign is the HMAC secret or the private key
+	sign interface{}
+	// verify is the HMAC secret or the public key
+	verify interface{}
+	// jwk is the public key to publish, nil for HMAC secrets
+	jwk *JWK
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// loadSigningKey loads the key configured for cfg.JWT.Algorithm
+func loadSigningKey(cfg *config.Config) (*signingKey, error) {
+	material, err := configuredKeyMaterial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newSigningKey(cfg.JWT.Algorithm, material, cfg.JWT.KeyID)
+}
+
+// configuredKeyMaterial returns the HMAC secret or PEM private key configured
+// for cfg.JWT.Algorithm
+func configuredKeyMaterial(cfg *config.Config) (string, error) {
+	if cfg.JWT.Algorithm == "" || cfg.JWT.Algorithm == jwt.SigningMethodHS256.Alg() {
+		secret, err := cfg.GetDecryptedJWTSecret()
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt JWT secret: %w", err)
+		}
+		return secret, nil
+	}
+
+	pemData, err := cfg.GetDecryptedJWTPrivateKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt JWT private key: %w", err)
+	}
+	if pemData == "" && cfg.JWT.PrivateKeyFile != "" {
+		data, err := os.ReadFile(cfg.JWT.PrivateKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read JWT private key: %w", err)
+		}
+		pemData = string(data)
+	}
+	if pemData == "" {
+		return "", fmt.Errorf("jwt.private_key or jwt.private_key_file is required for %s", cfg.JWT.Algorithm)
+	}
+	return pemData, nil
+}
+
+// newSigningKey returns the key for an HMAC secret or PEM private key
+func newSigningKey(alg, material, kid string) (*signingKey, error) {
+	if alg == "" || alg == jwt.SigningMethodHS256.Alg() {
+		return newHMACKey(kid, material)
+	}
+	private, err := parsePrivateKey([]byte(material))
+	if err != nil {
+		return nil, err
+	}
+	return newAsymmetricKey(alg, private, kid)
+}
+
+// newHMACKey returns an HS256 key for secret
+func newHMACKey(kid, secret string) (*signingKey, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("HS256 secrets must be at least %d bytes", minSecretLength)
+	}
+	return &signingKey{
+		id:     kid,
+		method: jwt.SigningMethodHS256,
+		sign:   []byte(secret),
+		verify: []byte(secret),
+	}, nil
+}
+
+// parsePrivateKey decodes a PKCS #8, PKCS #1 or SEC 1 PEM private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported JWT private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("failed to parse JWT private key")
+}
+
+// newAsymmetricKey checks that key suits alg and derives its public JWK. An
+// empty kid defaults to the key's JWK thumbprint (RFC 7638).
+func newAsymmetricKey(alg string, key crypto.Signer, kid string) (*signingKey, error) {
+	var method jwt.SigningMethod
+	var jwk JWK
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("an RSA key cannot sign %s tokens", alg)
+		}
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		method = jwt.SigningMethodRS256
+		jwk = JWK{
+			Kty: "RSA",
+			N:   b64(k.N.Bytes()),
+			E:   b64(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PrivateKey:
+		if alg != jwt.SigningMethodES256.Alg() {
+			return nil, fmt.Errorf("an ECDSA key cannot sign %s tokens", alg)
+		}
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 requires a P-256 key")
+		}
+		method = jwt.SigningMethodES256
+		jwk = JWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   b64(k.X.FillBytes(make([]byte, 32))),
+			Y:   b64(k.Y.FillBytes(make([]byte, 32))),
+		}
+	case ed25519.PrivateKey:
+		if alg != jwt.SigningMethodEdDSA.Alg() {
+			return nil, fmt.Errorf("an Ed25519 key cannot sign %s tokens", alg)
+		}
+		method = jwt.SigningMethodEdDSA
+		jwk = JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(k.Public().(ed25519.PublicKey)),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT private key type %T", key)
+	}
+
+	if kid == "" {
+		kid = thumbprint(&jwk)
+	}
+	jwk.Use = "sig"
+	jwk.Alg = method.Alg()
+	jwk.Kid = kid
+
+	return &signingKey{
+		id:     kid,
+		method: method,
+		sign:   key,
+		verify: key.Public(),
+		jwk:    &jwk,
+	}, nil
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of a public JWK
+func thumbprint(jwk *JWK) string {
+	// The required members, in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
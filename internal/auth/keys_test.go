@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestThumbprintRFC7638Example(t *testing.T) {
+	// RFC 7638, section 3.1
+	jwk := &JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		// Members outside the required set do not change the thumbprint
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	if got, want := thumbprint(jwk), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint() = %s, want %s", got, want)
+	}
+}
+
+// pemKey encodes key as a PEM block of the given type
+func pemKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func pkcs8(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	return pemKey(t, "PRIVATE KEY", der)
+}
+
+func TestNewSigningKeyFormats(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		alg     string
+		pem     string
+		wantKty string
+	}{
+		{"RSA PKCS #8", "RS256", pkcs8(t, rsaKey), "RSA"},
+		{"RSA PKCS #1", "RS256", pemKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), "RSA"},
+		{"P-256 PKCS #8", "ES256", pkcs8(t, ecKey), "EC"},
+		{"P-256 SEC 1", "ES256", pemKey(t, "EC PRIVATE KEY", sec1), "EC"},
+		{"Ed25519 PKCS #8", "EdDSA", pkcs8(t, edKey), "OKP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := newSigningKey(tt.alg, tt.pem, "")
+			if err != nil {
+				t.Fatalf("newSigningKey() error = %v", err)
+			}
+			if key.method.Alg() != tt.alg || key.jwk.Kty != tt.wantKty || key.jwk.Alg != tt.alg {
+				t.Errorf("key = %s with JWK %+v, want %s %s", key.method.Alg(), key.jwk, tt.alg, tt.wantKty)
+			}
+			// The kid defaults to the thumbprint of the published key
+			if key.id == "" || key.id != key.jwk.Kid || key.id != thumbprint(key.jwk) {
+				t.Errorf("kid = %q, JWK kid = %q, want the thumbprint %q", key.id, key.jwk.Kid, thumbprint(key.jwk))
+			}
+		})
+	}
+}
+
+func TestNewSigningKeyRejectsWeakKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		alg  string
+		pem  string
+		want string
+	}{
+		{"1024-bit RSA", "RS256", pkcs8(t, rsaKey), "at least 2048 bits"},
+		{"P-384", "ES256", pkcs8(t, p384Key), "P-256"},
+		{"key for another algorithm", "RS256", pkcs8(t, p256Key), "cannot sign RS256"},
+		{"not PEM", "ES256", "not a key", "not PEM encoded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSigningKey(tt.alg, tt.pem, "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("newSigningKey() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// revocationList checks tokens against the revoked token IDs and per-user
+// token generations in Redis. Answers are cached in process for a short
+// time, so revocations made on another instance take up to the cache TTL to
+// apply; revocations made on this instance apply at once.
+type revocationList struct {
+	redisClient *storage.RedisClient
+	cache       *lruCache
+	ttl         time.Duration
+}
+
+func newRevocationList(redisClient *storage.RedisClient, size int, ttl time.Duration) *revocationList {
+	return &revocationList{
+		redisClient: redisClient,
+		cache:       newLRUCache(size),
+		ttl:         ttl,
+	}
+}
+
+// revoked reports whether claims' token was revoked on its own, with its
+// session, or by a later token generation
+func (rl *revocationList) revoked(ctx context.Context, claims *Claims) (bool, error) {
+	now := time.Now()
+	jtiKey := "jti:" + claims.ID
+	sidKey := "sid:" + claims.SessionID
+	genKey := "gen:" + claims.Identifier()
+
+	revoked, okRevoked := rl.cache.get(jtiKey, now)
+	if okRevoked && revoked == 1 {
+		return true, nil
+	}
+	if claims.SessionID != "" {
+		sessionRevoked, okSession := rl.cache.get(sidKey, now)
+		if okSession && sessionRevoked == 1 {
+			return true, nil
+		}
+		okRevoked = okRevoked && okSession
+	}
+	generation, okGeneration := rl.cache.get(genKey, now)
+
+	if !okRevoked || !okGeneration {
+		isRevoked, gen, err := rl.redisClient.TokenRevocation(ctx, claims.ID, claims.SessionID, claims.Identifier())
+		if err != nil {
+			return false, err
+		}
+		if isRevoked {
+			// A revocation lasts until the token expires
+			rl.cache.set(jtiKey, 1, claims.ExpiresAt.Time)
+			return true, nil
+		}
+		rl.cache.set(jtiKey, 0, now.Add(rl.ttl))
+		if claims.SessionID != "" {
+			rl.cache.set(sidKey, 0, now.Add(rl.ttl))
+		}
+		rl.cache.set(genKey, gen, now.Add(rl.ttl))
+		generation = gen
+	}
+	return claims.Generation < generation, nil
+}
+
+// generation returns the current token generation of identifier, bypassing
+// the cache so that new tokens are never issued in a stale generation
+func (rl *revocationList) generation(ctx context.Context, identifier string) (int64, error) {
+	return rl.redisClient.GetTokenGeneration(ctx, identifier)
+}
+
+// revoke revokes a single token
+func (rl *revocationList) revoke(ctx context.Context, claims *Claims) error {
+	if err := rl.redisClient.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+	rl.cache.set("jti:"+claims.ID, 1, claims.ExpiresAt.Time)
+	return nil
+}
+
+// revokeSession revokes every access token carrying sessionID until the
+// tokens issued so far have expired, which they all do within lifetime.
+// Tokens issued for the session later are rejected too.
+func (rl *revocationList) revokeSession(ctx context.Context, sessionID string, lifetime time.Duration) error {
+	expiresAt := time.Now().Add(lifetime + tokenLeeway)
+	if err := rl.redisClient.RevokeSessionTokens(ctx, sessionID, expiresAt); err != nil {
+		return err
+	}
+	rl.cache.set("sid:"+sessionID, 1, expiresAt)
+	return nil
+}
+
+// revokeAll starts a new token generation for identifier
+func (rl *revocationList) revokeAll(ctx context.Context, identifier string) (int64, error) {
+	gen, err := rl.redisClient.IncrementTokenGeneration(ctx, identifier)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	rl.cache.set("gen:"+identifier, gen, time.Now().Add(rl.ttl))
+	return gen, nil
+}
+
+// lruCache is a size-bounded cache whose entries also expire
+type lruCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     int64
+	expiresAt time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string, now time.Time) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !now.Before(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return 0, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *lruCache) set(key string, value int64, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
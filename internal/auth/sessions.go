@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// Authentication methods recorded for sessions
+const (
+	MethodOTP       = "otp"
+	MethodMagicLink = "magic_link"
+	MethodTOTP      = "totp"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown or revoked refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. The session and its access tokens have been
+	// revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrSessionExpired is returned once a session reaches its maximum lifetime
+	ErrSessionExpired = errors.New("session has expired")
+	// ErrSessionNotFound is returned for sessions that have ended
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Session is a short-lived access token and the opaque refresh token that
+// replaces it
+type Session struct {
+	ID               string
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// SessionManager starts sessions and rotates their refresh tokens. Each
+// session is a family of refresh tokens; every refresh token can be used
+// once, and using one again revokes the whole family.
+type SessionManager struct {
+	config      *config.Config
+	tokens      *TokenManager
+	redisClient *storage.RedisClient
+}
+
+func NewSessionManager(cfg *config.Config, tokens *TokenManager, redisClient *storage.RedisClient) *SessionManager {
+	return &SessionManager{
+		config:      cfg,
+		tokens:      tokens,
+		redisClient: redisClient,
+	}
+}
+
+// Tokens returns the manager access tokens are issued with
+func (sm *SessionManager) Tokens() *TokenManager {
+	return sm.tokens
+}
+
+// Start begins a session for claims from device, signed in with methods
+func (sm *SessionManager) Start(ctx context.Context, claims *Claims, device storage.SessionDevice, methods []string) (*Session, error) {
+	generation, err := sm.tokens.Generation(ctx, claims.Identifier())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	family := &storage.RefreshSession{
+		Phone:         claims.Phone,
+		Email:         claims.Email,
+		TwoFAEnabled:  claims.TwoFAEnabled,
+		TwoFAVerified: claims.TwoFAVerified,
+		Generation:    generation,
+		Device:        device,
+		AuthMethods:   methods,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(sm.config.JWT.MaxSessionLifetime),
+	}
+	refreshToken, refreshExpiresAt, err := sm.redisClient.CreateRefreshSession(ctx, family, sm.config.JWT.RefreshTokenLifetime)
+	if err != nil {
+		return nil, err
+	}
+	return sm.issue(ctx, family, refreshToken, refreshExpiresAt)
+}
+
+// Refresh exchanges refreshToken for a new access and refresh token, noting
+// ip as where the session was last seen. It returns ErrInvalidRefreshToken,
+// ErrRefreshTokenReused or ErrSessionExpired if the token cannot be used.
+func (sm *SessionManager) Refresh(ctx context.Context, refreshToken, ip string) (*Session, error) {
+	result, err := sm.redisClient.RotateRefreshToken(ctx, refreshToken, sm.config.JWT.RefreshTokenLifetime, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	switch result.Status {
+	case storage.RefreshValid:
+		return sm.continueSession(ctx, result)
+	case storage.RefreshReused:
+		// The family is revoked; its access tokens may be in the wrong hands too
+		if err := sm.tokens.RevokeSession(ctx, result.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	case storage.RefreshExpired:
+		return nil, ErrSessionExpired
+	default:
+		return nil, ErrInvalidRefreshToken
+	}
+}
+
+// End revokes the session refreshToken belongs to, along with its access
+// tokens, and returns it. Unknown tokens are ignored and return nil.
+func (sm *SessionManager) End(ctx context.Context, refreshToken string) (*storage.RefreshSession, error) {
+	id, err := sm.redisClient.RevokeRefreshToken(ctx, refreshToken)
+	if err != nil || id == "" {
+		return nil, err
+	}
+	if err := sm.tokens.RevokeSession(ctx, id); err != nil {
+		return nil, err
+	}
+	return sm.redisClient.GetRefreshSession(ctx, id)
+}
+
+// Upgrade replaces the session claims were issued for with one that has
+// passed 2FA with method, keeping its user, device and earlier factors. It
+// returns ErrSessionNotFound if that session has ended.
+func (sm *SessionManager) Upgrade(ctx context.Context, claims *Claims, method string) (*Session, error) {
+	previous, err := sm.redisClient.GetRefreshSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil || previous.Revoked || previous.Identifier() != claims.Identifier() {
+		return nil, ErrSessionNotFound
+	}
+
+	// Only one upgrade of a session can find it
+	found, err := sm.Revoke(ctx, claims.Identifier(), claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrSessionNotFound
+	}
+
+	upgraded := &Claims{
+		Phone:         previous.Phone,
+		Email:         previous.Email,
+		TwoFAEnabled:  true,
+		TwoFAVerified: true,
+	}
+	methods := append(previous.AuthMethods[:len(previous.AuthMethods):len(previous.AuthMethods)], method)
+	return sm.Start(ctx, upgraded, previous.Device, methods)
+}
+
+// List returns the active sessions of identifier, most recently seen first
+func (sm *SessionManager) List(ctx context.Context, identifier string) ([]*storage.RefreshSession, error) {
+	generation, err := sm.tokens.Generation(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	return sm.redisClient.ListUserSessions(ctx, identifier, generation)
+}
+
+// Revoke ends session id of identifier along with its access tokens. It
+// reports whether the session was found.
+func (sm *SessionManager) Revoke(ctx context.Context, identifier, id string) (bool, error) {
+	found, err := sm.redisClient.RevokeUserSession(ctx, identifier, id)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, sm.tokens.RevokeSession(ctx, id)
+}
+
+// RevokeOthers ends every session of identifier except keepID and returns
+// how many were ended
+func (sm *SessionManager) RevokeOthers(ctx context.Context, identifier, keepID string) (int, error) {
+	sessions, err := sm.List(ctx, identifier)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		found, err := sm.Revoke(ctx, identifier, session.ID)
+		if err != nil {
+			return revoked, err
+		}
+		if found {
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// EndAll revokes every access token and session of identifier
+func (sm *SessionManager) EndAll(ctx context.Context, identifier string) error {
+	if _, err := sm.tokens.RevokeAll(ctx, identifier); err != nil {
+		return err
+	}
+	return sm.redisClient.RevokeUserSessions(ctx, identifier)
+}
+
+// continueSession issues tokens for a rotated refresh token unless the
+// session was ended by logging out everywhere
+func (sm *SessionManager) continueSession(ctx context.Context, result *storage.RefreshResult) (*Session, error) {
+	family := result.Session
+	generation, err := sm.tokens.Generation(ctx, family.Identifier())
+	if err != nil {
+		return nil, err
+	}
+	if family.Generation < generation {
+		if err := sm.redisClient.RevokeRefreshSession(ctx, family.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	return sm.issue(ctx, family, result.Token, result.TokenExpiresAt)
+}
+
+// issue signs an access token for a session
+func (sm *SessionManager) issue(ctx context.Context, family *storage.RefreshSession, refreshToken string, refreshExpiresAt time.Time) (*Session, error) {
+	claims := &Claims{
+		Phone:         family.Phone,
+		Email:         family.Email,
+		TwoFAEnabled:  family.TwoFAEnabled,
+		TwoFAVerified: family.TwoFAVerified,
+		SessionID:     family.ID,
+	}
+	accessToken, accessExpiresAt, err := sm.tokens.GenerateToken(ctx, claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+	return &Session{
+		ID:               family.ID,
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
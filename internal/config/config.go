@@ -16,24 +16,54 @@ type Config struct {
 		IdleTimeout  time.Duration `mapstructure:"idle_timeout" validate:"required"`
 	}
 
-	// JWT configuration
+	// JWT configuration. HS256 tokens are signed with Secret; RS256, ES256
+	// and EdDSA tokens with the PEM encoded PrivateKey or PrivateKeyFile.
 	JWT struct {
-		Secret        EncryptedValue `mapstructure:"secret" validate:"required"`
-		TokenLifetime time.Duration  `mapstructure:"token_lifetime" validate:"required"`
-		Issuer        string         `mapstructure:"issuer" validate:"required"`
+		Algorithm      string         `mapstructure:"algorithm" validate:"required,oneof=HS256 RS256 ES256 EdDSA"`
+		Secret         EncryptedValue `mapstructure:"secret"`
+		PrivateKey     EncryptedValue `mapstructure:"private_key"`
+		PrivateKeyFile string         `mapstructure:"private_key_file"`
+		// KeyringFile is a keyring managed with cmd/jwtkeys. When set,
+		// tokens are signed with its active key instead.
+		KeyringFile string            `mapstructure:"keyring_file"`
+		Rotation    JWTRotationConfig `mapstructure:"rotation"`
+		Revocation  struct {
+			// CacheSize is how many revocation answers each instance caches
+			CacheSize int `mapstructure:"cache_size" validate:"required,min=1"`
+			// CacheTTL is how long a token may stay usable on other
+			// instances after it is revoked
+			CacheTTL time.Duration `mapstructure:"cache_ttl" validate:"required"`
+		} `mapstructure:"revocation"`
+		// KeyID is the kid of issued tokens; defaults to the JWK thumbprint
+		// of asymmetric keys
+		KeyID string `mapstructure:"key_id"`
+		// TokenLifetime is how long access tokens are valid for
+		TokenLifetime time.Duration `mapstructure:"token_lifetime" validate:"required"`
+		// RefreshTokenLifetime is how long an unused refresh token is valid for
+		RefreshTokenLifetime time.Duration `mapstructure:"refresh_token_lifetime" validate:"required"`
+		// MaxSessionLifetime is how long a session can be refreshed for after sign-in
+		MaxSessionLifetime time.Duration `mapstructure:"max_session_lifetime" validate:"required,gtefield=RefreshTokenLifetime"`
+		Issuer             string        `mapstructure:"issuer" validate:"required"`
+		Audience           string        `mapstructure:"audience" validate:"required"`
 	}
 
 	// Security configuration
 	Security struct {
-		MaxLoginAttempts int           `mapstructure:"max_login_attempts" validate:"required,min=1"`
-		LockoutDuration  time.Duration `mapstructure:"lockout_duration" validate:"required"`
-		OTPLength        int           `mapstructure:"otp_length" validate:"required,min=4,max=8"`
-		OTPExpiry        time.Duration `mapstructure:"otp_expiry" validate:"required"`
-		RateLimit        struct {
+		MaxLoginAttempts   int           `mapstructure:"max_login_attempts" validate:"required,min=1"`
+		LockoutDuration    time.Duration `mapstructure:"lockout_duration" validate:"required"`
+		MaxLockoutDuration time.Duration `mapstructure:"max_lockout_duration"`
+		OTPLength          int           `mapstructure:"otp_length" validate:"required,min=4,max=8"`
+		OTPExpiry          time.Duration `mapstructure:"otp_expiry" validate:"required"`
+		// IdempotencyWindow is how long a sendOtp response is replayed for
+		// requests repeating its Idempotency-Key
+		IdempotencyWindow time.Duration `mapstructure:"idempotency_window" validate:"required"`
+		RateLimit         struct {
 			RequestsPerMinute int `mapstructure:"requests_per_minute" validate:"required,min=1"`
 			BurstSize         int `mapstructure:"burst_size" validate:"required,min=1"`
 		} `mapstructure:"rate_limit"`
-		TwoFactor struct {
+		SMSThrottle SMSThrottleConfig   `mapstructure:"sms_throttle"`
+		Countries   CountryPolicyConfig `mapstructure:"countries"`
+		TwoFactor   struct {
 			Enabled   bool   `mapstructure:"enabled" validate:"required"`
 			Issuer    string `mapstructure:"issuer" validate:"required"`
 			Algorithm string `mapstructure:"algorithm" validate:"required,oneof=SHA1 SHA256 SHA512"`
@@ -45,11 +75,96 @@ type Config struct {
 
 	// SMS configuration
 	SMS struct {
-		Provider   string         `mapstructure:"provider" validate:"required,oneof=twilio"`
-		AccountSID EncryptedValue `mapstructure:"account_sid" validate:"required"`
-		AuthToken  EncryptedValue `mapstructure:"auth_token" validate:"required"`
-		FromNumber string         `mapstructure:"from_number" validate:"required"`
-		TemplateID string         `mapstructure:"template_id"`
+		Provider      string                        `mapstructure:"provider" validate:"required,oneof=twilio vonage sns messagebird console file memory"`
+		AccountSID    EncryptedValue                `mapstructure:"account_sid" validate:"required_if=Provider twilio"`
+		AuthToken     EncryptedValue                `mapstructure:"auth_token" validate:"required_if=Provider twilio"`
+		FromNumber    string                        `mapstructure:"from_number" validate:"required_if=Provider twilio"`
+		TemplateID    string                        `mapstructure:"template_id"`
+		TemplatesDir  string                        `mapstructure:"templates_dir"`
+		DefaultLocale string                        `mapstructure:"default_locale" validate:"required"`
+		BrandName     string                        `mapstructure:"brand_name"`
+		ClientApps    map[string]SMSClientAppConfig `mapstructure:"client_apps" validate:"dive"`
+		Twilio        TwilioConfig                  `mapstructure:"twilio"`
+		Vonage        VonageConfig                  `mapstructure:"vonage"`
+		SNS           SNSConfig                     `mapstructure:"sns"`
+		MessageBird   MessageBirdConfig             `mapstructure:"messagebird"`
+		Routing       SMSRoutingConfig              `mapstructure:"routing"`
+		Sink          SMSSinkConfig                 `mapstructure:"sink"`
+		Webhooks      SMSWebhooksConfig             `mapstructure:"webhooks"`
+	}
+
+	// Phone number handling
+	Phone struct {
+		DefaultRegion string `mapstructure:"default_region" validate:"omitempty,len=2,uppercase"`
+		MobileOnly    bool   `mapstructure:"mobile_only"`
+	}
+
+	// Email configuration
+	Email struct {
+		Enabled          bool       `mapstructure:"enabled"`
+		From             string     `mapstructure:"from" validate:"required_if=Enabled true,omitempty,email"`
+		FromName         string     `mapstructure:"from_name"`
+		Subject          string     `mapstructure:"subject"`
+		MagicLinkSubject string     `mapstructure:"magic_link_subject"`
+		SMTP             SMTPConfig `mapstructure:"smtp"`
+		Templates        struct {
+			HTML          string `mapstructure:"html"`
+			Text          string `mapstructure:"text"`
+			MagicLinkHTML string `mapstructure:"magic_link_html"`
+			MagicLinkText string `mapstructure:"magic_link_text"`
+		} `mapstructure:"templates"`
+	}
+
+	// Voice call configuration. Calls are placed with the Twilio account
+	// configured under sms.
+	Voice struct {
+		Enabled    bool   `mapstructure:"enabled"`
+		FromNumber string `mapstructure:"from_number" validate:"required_if=Enabled true"`
+		// BaseURL of the Twilio API; point it at a local fake for testing
+		BaseURL  string `mapstructure:"base_url" validate:"omitempty,url"`
+		Voice    string `mapstructure:"voice"`
+		Language string `mapstructure:"language"`
+		// Repeat is how many times the code is read out
+		Repeat int `mapstructure:"repeat" validate:"min=1,max=5"`
+	}
+
+	// Messaging app channels for phone numbers
+	Messaging struct {
+		WhatsApp WhatsAppConfig `mapstructure:"whatsapp"`
+		Telegram TelegramConfig `mapstructure:"telegram"`
+	}
+
+	// Magic link configuration
+	MagicLink struct {
+		Enabled     bool   `mapstructure:"enabled"`
+		BaseURL     string `mapstructure:"base_url" validate:"required_if=Enabled true,omitempty,url"`
+		RedirectURL string `mapstructure:"redirect_url" validate:"omitempty,url"`
+	}
+
+	// OpenID Connect provider configuration
+	OIDC struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Issuer is the public URL of this service and the iss of ID tokens
+		Issuer string `mapstructure:"issuer" validate:"required_if=Enabled true,omitempty,url"`
+		// LoginURL is the page that signs users in through the API and then
+		// returns them to the return_to URL it is given
+		LoginURL        string             `mapstructure:"login_url" validate:"required_if=Enabled true,omitempty,url"`
+		CodeLifetime    time.Duration      `mapstructure:"code_lifetime" validate:"required"`
+		IDTokenLifetime time.Duration      `mapstructure:"id_token_lifetime" validate:"required"`
+		Clients         []OIDCClientConfig `mapstructure:"clients" validate:"dive"`
+		Device          OIDCDeviceConfig   `mapstructure:"device"`
+	}
+
+	// Delivery queue configuration
+	Delivery struct {
+		Workers        int                    `mapstructure:"workers" validate:"required,min=1"`
+		MaxAttempts    int                    `mapstructure:"max_attempts" validate:"required,min=1"`
+		InitialBackoff time.Duration          `mapstructure:"initial_backoff" validate:"required"`
+		MaxBackoff     time.Duration          `mapstructure:"max_backoff" validate:"required"`
+		SendTimeout    time.Duration          `mapstructure:"send_timeout" validate:"required"`
+		StatusTTL      time.Duration          `mapstructure:"status_ttl" validate:"required"`
+		DeadLetterSize int                    `mapstructure:"dead_letter_size" validate:"required,min=1"`
+		Fallback       DeliveryFallbackConfig `mapstructure:"fallback"`
 	}
 
 	// Logging configuration
@@ -92,11 +207,222 @@ type RedisTTLConfig struct {
 	TwoFAAttempts time.Duration `mapstructure:"twofa_attempts"`
 }
 
+// JWTRotationConfig schedules keyring rotation by cmd/jwtkeys -rotate. A new
+// key is published PublishDelay before it replaces a key that has signed
+// tokens for Interval; PublishDelay should exceed how long services cache
+// the JWKS.
+type JWTRotationConfig struct {
+	Interval     time.Duration `mapstructure:"interval" validate:"required"`
+	PublishDelay time.Duration `mapstructure:"publish_delay" validate:"required,ltfield=Interval"`
+}
+
+// CountryPolicyConfig restricts which countries OTPs may be sent to. Regions
+// are ISO 3166-1 alpha-2 codes such as "US" or "GB". When Allow is non-empty
+// only those regions are served; regions in Deny are always refused.
+type CountryPolicyConfig struct {
+	Allow     []string                   `mapstructure:"allow" validate:"dive,len=2"`
+	Deny      []string                   `mapstructure:"deny" validate:"dive,len=2"`
+	Overrides map[string]CountryOverride `mapstructure:"overrides" validate:"dive,keys,len=2,endkeys"`
+}
+
+// CountryOverride replaces OTP settings for a single region. Zero values
+// fall back to the global settings.
+type CountryOverride struct {
+	OTPLength   int           `mapstructure:"otp_length" validate:"omitempty,min=4,max=8"`
+	OTPExpiry   time.Duration `mapstructure:"otp_expiry"`
+	SMSProvider string        `mapstructure:"sms_provider" validate:"omitempty,oneof=twilio vonage sns messagebird console file memory"`
+}
+
+// SMSThrottleConfig limits how many OTP messages can be sent, to protect
+// against SMS pumping (toll fraud). Zero disables an individual cap.
+type SMSThrottleConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	ResendCooldown  time.Duration `mapstructure:"resend_cooldown"`
+	DailyPerNumber  int           `mapstructure:"daily_per_number" validate:"min=0"`
+	DailyPerCountry int           `mapstructure:"daily_per_country" validate:"min=0"`
+	GlobalPerMinute int           `mapstructure:"global_per_minute" validate:"min=0"`
+	// Anomaly blocks destination prefixes whose traffic looks like pumping.
+	// High-risk prefixes are capped per minute; they and SpikePrefixes are
+	// also blocked when a minute spikes against their own recent average.
+	Anomaly struct {
+		Enabled       bool    `mapstructure:"enabled"`
+		SpikeMinCount int     `mapstructure:"spike_min_count" validate:"min=0"`
+		SpikeFactor   float64 `mapstructure:"spike_factor" validate:"min=0"`
+		// SpikePrefixes are watched for spikes in addition to the high-risk
+		// prefixes, such as a calling code that is not otherwise expected
+		SpikePrefixes []string `mapstructure:"spike_prefixes"`
+		// SpikeWindow is how far back the average volume is taken over
+		SpikeWindow time.Duration `mapstructure:"spike_window" validate:"required_if=Enabled true"`
+		// SpikeMinHistory is how much history a prefix needs before a spike
+		// can block it, so a cold start is not mistaken for a spike
+		SpikeMinHistory   time.Duration `mapstructure:"spike_min_history" validate:"ltefield=SpikeWindow"`
+		HighRiskPrefixes  []string      `mapstructure:"high_risk_prefixes"`
+		HighRiskPerMinute int           `mapstructure:"high_risk_per_minute" validate:"min=0"`
+		BlockDuration     time.Duration `mapstructure:"block_duration" validate:"required_if=Enabled true"`
+	} `mapstructure:"anomaly"`
+}
+
+// SMTPConfig holds connection settings for the outgoing mail server
+type SMTPConfig struct {
+	Host     string         `mapstructure:"host"`
+	Port     string         `mapstructure:"port"`
+	Username EncryptedValue `mapstructure:"username"`
+	Password EncryptedValue `mapstructure:"password"`
+	TLS      string         `mapstructure:"tls" validate:"omitempty,oneof=none starttls tls"`
+}
+
+// TwilioConfig holds settings for the Twilio Messages API. The account is
+// configured by sms.account_sid and sms.auth_token.
+type TwilioConfig struct {
+	BaseURL string `mapstructure:"base_url" validate:"omitempty,url"`
+}
+
+// VonageConfig holds credentials for the Vonage SMS API
+type VonageConfig struct {
+	APIKey    EncryptedValue `mapstructure:"api_key"`
+	APISecret EncryptedValue `mapstructure:"api_secret"`
+	From      string         `mapstructure:"from"`
+	BaseURL   string         `mapstructure:"base_url"`
+	// SignatureSecret verifies signed delivery receipts (HMAC-SHA256)
+	SignatureSecret EncryptedValue `mapstructure:"signature_secret"`
+}
+
+// SNSConfig holds credentials for the AWS SNS Publish API
+type SNSConfig struct {
+	AccessKeyID     EncryptedValue `mapstructure:"access_key_id"`
+	SecretAccessKey EncryptedValue `mapstructure:"secret_access_key"`
+	Region          string         `mapstructure:"region"`
+	SenderID        string         `mapstructure:"sender_id"`
+	BaseURL         string         `mapstructure:"base_url"`
+}
+
+// MessageBirdConfig holds credentials for the MessageBird REST API
+type MessageBirdConfig struct {
+	AccessKey  EncryptedValue `mapstructure:"access_key"`
+	Originator string         `mapstructure:"originator"`
+	BaseURL    string         `mapstructure:"base_url"`
+	// SigningKey verifies the MessageBird-Signature-JWT of status reports
+	SigningKey EncryptedValue `mapstructure:"signing_key"`
+}
+
+// WhatsAppConfig sends OTPs as WhatsApp authentication templates through
+// Twilio or the Meta Cloud API
+type WhatsAppConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Provider string `mapstructure:"provider" validate:"required_if=Enabled true,omitempty,oneof=twilio meta"`
+	// Twilio: a WhatsApp sender on the sms account and an approved Content
+	// template whose variable {{1}} is the code
+	FromNumber string `mapstructure:"from_number"`
+	ContentSID string `mapstructure:"content_sid"`
+	// Meta Cloud API: an approved authentication template with a copy code button
+	PhoneNumberID    string         `mapstructure:"phone_number_id"`
+	AccessToken      EncryptedValue `mapstructure:"access_token"`
+	TemplateName     string         `mapstructure:"template_name"`
+	TemplateLanguage string         `mapstructure:"template_language"`
+	BaseURL          string         `mapstructure:"base_url" validate:"omitempty,url"`
+}
+
+// TelegramConfig sends OTPs through the Telegram Gateway API
+type TelegramConfig struct {
+	Enabled     bool           `mapstructure:"enabled"`
+	AccessToken EncryptedValue `mapstructure:"access_token"`
+	BaseURL     string         `mapstructure:"base_url" validate:"omitempty,url"`
+}
+
+// OIDCClientConfig registers an application that signs users in through the
+// OpenID Connect provider
+type OIDCClientConfig struct {
+	ID   string `mapstructure:"id" validate:"required"`
+	Name string `mapstructure:"name"`
+	// Secret authenticates confidential clients. Public clients, such as
+	// single-page and native apps, have none and rely on PKCE alone.
+	Secret EncryptedValue `mapstructure:"secret"`
+	// RedirectURIs are the exact URIs codes may be sent to. Clients using
+	// the authorization code grant need at least one.
+	RedirectURIs []string `mapstructure:"redirect_uris" validate:"omitempty,dive,url"`
+	// GrantTypes the client may use; defaults to authorization_code
+	GrantTypes []string `mapstructure:"grant_types" validate:"omitempty,dive,oneof=authorization_code urn:ietf:params:oauth:grant-type:device_code"`
+}
+
+// OIDCDeviceConfig enables the device authorization grant (RFC 8628) for
+// CLIs and devices without a browser. The user approves the device on
+// another device by entering its user code.
+type OIDCDeviceConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// VerificationURI is the page where users sign in and enter user codes
+	// through the /api/v1/oidc/device API
+	VerificationURI string `mapstructure:"verification_uri" validate:"required_if=Enabled true,omitempty,url"`
+	// CodeLifetime is how long the user has to approve the device
+	CodeLifetime time.Duration `mapstructure:"code_lifetime" validate:"required"`
+	// Interval is how long devices must wait between token requests
+	Interval time.Duration `mapstructure:"interval" validate:"required,min=1s"`
+}
+
+// DeliveryFallbackConfig resends a code through another channel when its SMS
+// is reported undelivered
+type DeliveryFallbackConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Channel string `mapstructure:"channel" validate:"required_if=Enabled true,omitempty,oneof=sms voice"`
+	// SMSProvider is the provider used by the sms channel, usually a
+	// different one from sms.provider
+	SMSProvider string `mapstructure:"sms_provider"`
+}
+
+// SMSWebhooksConfig asks providers to report the delivery status of every
+// message to /api/v1/webhooks/sms/{provider}
+type SMSWebhooksConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// BaseURL is the public URL of this service as the providers reach it,
+	// e.g. https://auth.example.com. Signatures are checked against it.
+	BaseURL string `mapstructure:"base_url" validate:"required_if=Enabled true,omitempty,url"`
+}
+
+// SMSClientAppConfig enables automatic OTP fill for a client app, selected
+// by the client_app field of a sendOtp request
+type SMSClientAppConfig struct {
+	// WebOTPDomain is the origin of the web app, e.g. "app.example.com"
+	WebOTPDomain string `mapstructure:"webotp_domain" validate:"omitempty,hostname"`
+	// AndroidAppHash is the 11-character SMS Retriever hash of the Android app
+	AndroidAppHash string `mapstructure:"android_app_hash" validate:"omitempty,len=11"`
+}
+
+// SMSSinkConfig configures the development console, file and memory providers
+type SMSSinkConfig struct {
+	FilePath  string `mapstructure:"file_path"`
+	InboxSize int    `mapstructure:"inbox_size" validate:"min=0"`
+}
+
+// SMSRoutingConfig configures failover and weighted routing across SMS providers
+type SMSRoutingConfig struct {
+	Enabled   bool             `mapstructure:"enabled"`
+	Providers []string         `mapstructure:"providers" validate:"required_if=Enabled true,dive,oneof=twilio vonage sns messagebird console file memory"`
+	Routes    []SMSRouteConfig `mapstructure:"routes" validate:"dive"`
+}
+
+// SMSRouteConfig selects providers for destination numbers starting with Prefix
+type SMSRouteConfig struct {
+	Prefix    string           `mapstructure:"prefix" validate:"required"`
+	Providers []SMSRouteTarget `mapstructure:"providers" validate:"required,min=1,dive"`
+}
+
+// SMSRouteTarget is a provider candidate within a route. Lower priorities are
+// tried first; providers sharing a priority are picked in proportion to Weight.
+type SMSRouteTarget struct {
+	Name     string `mapstructure:"name" validate:"required"`
+	Weight   int    `mapstructure:"weight" validate:"min=0"`
+	Priority int    `mapstructure:"priority" validate:"min=0"`
+}
+
 // GetDecryptedJWTSecret returns the decrypted JWT secret
 func (c *Config) GetDecryptedJWTSecret() (string, error) {
 	return c.JWT.Secret.Decrypt()
 }
 
+// GetDecryptedJWTPrivateKey returns the decrypted PEM encoded JWT signing key
+func (c *Config) GetDecryptedJWTPrivateKey() (string, error) {
+	return c.JWT.PrivateKey.Decrypt()
+}
+
 // GetDecryptedSMSAccountSID returns the decrypted SMS account SID
 func (c *Config) GetDecryptedSMSAccountSID() (string, error) {
 	return c.SMS.AccountSID.Decrypt()
@@ -233,6 +233,11 @@ func GenerateKeyID() string {
 	return fmt.Sprintf("key_%x", b)
 }
 
+// EncryptionEnabled reports whether an encryption key is configured
+func EncryptionEnabled() bool {
+	return getPrimaryKey() != nil
+}
+
 // IsEncrypted checks if a value is encrypted
 func IsEncrypted(value string) bool {
 	return strings.HasPrefix(value, "ENC[") && strings.HasSuffix(value, "]")
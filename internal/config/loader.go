@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -61,16 +62,87 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.idle_timeout", "120s")
 
 	// JWT defaults
-	v.SetDefault("jwt.token_lifetime", "24h")
+	v.SetDefault("jwt.algorithm", "HS256")
+	v.SetDefault("jwt.token_lifetime", "15m")
+	v.SetDefault("jwt.refresh_token_lifetime", "168h")
+	v.SetDefault("jwt.max_session_lifetime", "720h")
+	v.SetDefault("jwt.rotation.interval", "720h")
+	v.SetDefault("jwt.rotation.publish_delay", "1h")
+	v.SetDefault("jwt.revocation.cache_size", 10000)
+	v.SetDefault("jwt.revocation.cache_ttl", "5s")
 	v.SetDefault("jwt.issuer", "passless-auth")
+	v.SetDefault("jwt.audience", "passless-auth")
 
 	// Security defaults
 	v.SetDefault("security.max_login_attempts", 3)
 	v.SetDefault("security.lockout_duration", "15m")
+	v.SetDefault("security.max_lockout_duration", "24h")
 	v.SetDefault("security.otp_length", 6)
 	v.SetDefault("security.otp_expiry", "5m")
+	v.SetDefault("security.idempotency_window", "10m")
 	v.SetDefault("security.rate_limit.requests_per_minute", 20)
 	v.SetDefault("security.rate_limit.burst_size", 5)
+	v.SetDefault("security.sms_throttle.enabled", true)
+	v.SetDefault("security.sms_throttle.resend_cooldown", "30s")
+	v.SetDefault("security.sms_throttle.daily_per_number", 10)
+	v.SetDefault("security.sms_throttle.daily_per_country", 1000)
+	v.SetDefault("security.sms_throttle.global_per_minute", 300)
+	v.SetDefault("security.sms_throttle.anomaly.enabled", true)
+	v.SetDefault("security.sms_throttle.anomaly.spike_min_count", 20)
+	v.SetDefault("security.sms_throttle.anomaly.spike_factor", 5)
+	v.SetDefault("security.sms_throttle.anomaly.spike_window", "1h")
+	v.SetDefault("security.sms_throttle.anomaly.spike_min_history", "30m")
+	v.SetDefault("security.sms_throttle.anomaly.high_risk_per_minute", 3)
+	v.SetDefault("security.sms_throttle.anomaly.block_duration", "1h")
+
+	// SMS defaults
+	v.SetDefault("sms.provider", "twilio")
+	v.SetDefault("sms.template_id", "otp")
+	v.SetDefault("sms.default_locale", "en")
+	v.SetDefault("sms.brand_name", "Passless")
+	v.SetDefault("sms.sns.region", "us-east-1")
+	v.SetDefault("sms.sink.inbox_size", 20)
+
+	// Email defaults
+	v.SetDefault("email.enabled", false)
+	v.SetDefault("email.subject", "Your verification code")
+	v.SetDefault("email.smtp.port", "587")
+	v.SetDefault("email.smtp.tls", "starttls")
+	v.SetDefault("email.magic_link_subject", "Your sign-in link")
+
+	// Phone defaults
+	v.SetDefault("phone.mobile_only", true)
+
+	// Voice defaults
+	v.SetDefault("voice.enabled", false)
+	v.SetDefault("voice.voice", "Polly.Joanna")
+	v.SetDefault("voice.language", "en-US")
+	v.SetDefault("voice.repeat", 2)
+
+	// Messaging defaults
+	v.SetDefault("messaging.whatsapp.provider", "twilio")
+	v.SetDefault("messaging.whatsapp.template_language", "en")
+
+	// Magic link defaults
+	v.SetDefault("magic_link.enabled", false)
+
+	// OpenID Connect defaults
+	v.SetDefault("oidc.enabled", false)
+	v.SetDefault("oidc.code_lifetime", "1m")
+	v.SetDefault("oidc.id_token_lifetime", "1h")
+	v.SetDefault("oidc.device.enabled", false)
+	v.SetDefault("oidc.device.code_lifetime", "10m")
+	v.SetDefault("oidc.device.interval", "5s")
+
+	// Delivery queue defaults
+	v.SetDefault("delivery.workers", 4)
+	v.SetDefault("delivery.max_attempts", 5)
+	v.SetDefault("delivery.initial_backoff", "1s")
+	v.SetDefault("delivery.max_backoff", "30s")
+	v.SetDefault("delivery.send_timeout", "10s")
+	v.SetDefault("delivery.status_ttl", "24h")
+	v.SetDefault("delivery.dead_letter_size", 1000)
+	v.SetDefault("delivery.fallback.channel", "sms")
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
@@ -92,9 +164,33 @@ func validateConfig(cfg *Config) error {
 	if err := validate.Struct(cfg); err != nil {
 		return err
 	}
+
+	// The Twilio account under sms is shared by every feature that uses Twilio
+	if usesTwilio(cfg) && (cfg.SMS.AccountSID.Value == "" || cfg.SMS.AuthToken.Value == "") {
+		return fmt.Errorf("sms.account_sid and sms.auth_token are required when Twilio sends SMS, voice calls or WhatsApp messages")
+	}
 	return nil
 }
 
+// usesTwilio reports whether anything is configured to send through Twilio
+func usesTwilio(cfg *Config) bool {
+	if cfg.SMS.Provider == "twilio" || cfg.Voice.Enabled || cfg.Delivery.Fallback.SMSProvider == "twilio" {
+		return true
+	}
+	if cfg.Messaging.WhatsApp.Enabled && cfg.Messaging.WhatsApp.Provider == "twilio" {
+		return true
+	}
+	if cfg.SMS.Routing.Enabled && slices.Contains(cfg.SMS.Routing.Providers, "twilio") {
+		return true
+	}
+	for _, override := range cfg.Security.Countries.Overrides {
+		if override.SMSProvider == "twilio" {
+			return true
+		}
+	}
+	return false
+}
+
 // GetConfigPath returns the path to the configuration file
 func GetConfigPath() string {
 	// Check environment variable
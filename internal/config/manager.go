@@ -70,6 +70,15 @@ func (cm *ConfigManager) GetConfig() *Config {
 	return cm.config
 }
 
+// SetConfig replaces the current configuration and notifies subscribers, as
+// a change to the configuration file does
+func (cm *ConfigManager) SetConfig(cfg *Config) {
+	cm.mu.Lock()
+	cm.config = cfg
+	cm.mu.Unlock()
+	cm.notifySubscribers()
+}
+
 // Subscribe returns a channel that will receive configuration updates
 func (cm *ConfigManager) Subscribe() <-chan *Config {
 	cm.mu.Lock()
@@ -3,7 +3,10 @@ package errors
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ErrorCode represents a specific type of error
@@ -17,20 +20,40 @@ const (
 	ErrNotFound           ErrorCode = "NOT_FOUND"
 	ErrInternalServer     ErrorCode = "INTERNAL_SERVER_ERROR"
 	ErrServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+	ErrRateLimited        ErrorCode = "RATE_LIMITED"
 
 	// Auth specific error codes
 	ErrInvalidOTP      ErrorCode = "INVALID_OTP"
 	ErrOTPExpired      ErrorCode = "OTP_EXPIRED"
+	ErrOTPAlreadyUsed  ErrorCode = "OTP_ALREADY_USED"
+	ErrOTPNotFound     ErrorCode = "OTP_NOT_FOUND"
 	ErrTooManyAttempts ErrorCode = "TOO_MANY_ATTEMPTS"
 	ErrInvalidToken    ErrorCode = "INVALID_TOKEN"
 	ErrTokenExpired    ErrorCode = "TOKEN_EXPIRED"
+
+	// Delivery specific error codes
+	ErrDestinationBlocked ErrorCode = "DESTINATION_BLOCKED"
+	ErrCountryNotAllowed  ErrorCode = "COUNTRY_NOT_ALLOWED"
+
+	// Idempotency specific error codes
+	ErrIdempotencyKeyReused ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	ErrRequestInProgress    ErrorCode = "REQUEST_IN_PROGRESS"
 )
 
+// FieldError describes a problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 // AppError represents an application error
 type AppError struct {
-	Code    ErrorCode `json:"code"`
-	Message string    `json:"message"`
-	Err     error     `json:"-"`
+	Code       ErrorCode     `json:"code"`
+	Message    string        `json:"message"`
+	Fields     []FieldError  `json:"fields,omitempty"`
+	Err        error         `json:"-"`
+	RetryAfter time.Duration `json:"-"`
 }
 
 // Error implements the error interface
@@ -50,6 +73,18 @@ func New(code ErrorCode, message string, err error) *AppError {
 	}
 }
 
+// WithRetryAfter sets how long the client should wait before retrying
+func (e *AppError) WithRetryAfter(d time.Duration) *AppError {
+	e.RetryAfter = d
+	return e
+}
+
+// WithField attaches a field-level validation error
+func (e *AppError) WithField(field, code, message string) *AppError {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+	return e
+}
+
 // HTTPStatus returns the appropriate HTTP status code for the error
 func (e *AppError) HTTPStatus() int {
 	switch e.Code {
@@ -65,7 +100,15 @@ func (e *AppError) HTTPStatus() int {
 		return http.StatusInternalServerError
 	case ErrServiceUnavailable:
 		return http.StatusServiceUnavailable
-	case ErrInvalidOTP, ErrOTPExpired, ErrTooManyAttempts, ErrInvalidToken, ErrTokenExpired:
+	case ErrTooManyAttempts, ErrRateLimited:
+		return http.StatusTooManyRequests
+	case ErrDestinationBlocked, ErrCountryNotAllowed:
+		return http.StatusForbidden
+	case ErrIdempotencyKeyReused:
+		return http.StatusUnprocessableEntity
+	case ErrRequestInProgress:
+		return http.StatusConflict
+	case ErrInvalidOTP, ErrOTPExpired, ErrOTPAlreadyUsed, ErrOTPNotFound, ErrInvalidToken, ErrTokenExpired:
 		return http.StatusUnauthorized
 	default:
 		return http.StatusInternalServerError
@@ -75,13 +118,21 @@ func (e *AppError) HTTPStatus() int {
 // WriteJSON writes the error as JSON to the response writer
 func (e *AppError) WriteJSON(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+	}
 	w.WriteHeader(e.HTTPStatus())
 
+	body := map[string]interface{}{
+		"code":    e.Code,
+		"message": e.Message,
+	}
+	if len(e.Fields) > 0 {
+		body["fields"] = e.Fields
+	}
+
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": map[string]interface{}{
-			"code":    e.Code,
-			"message": e.Message,
-		},
+		"error": body,
 	})
 }
 
@@ -110,6 +161,10 @@ func NewServiceUnavailable(message string, err error) *AppError {
 	return New(ErrServiceUnavailable, message, err)
 }
 
+func NewRateLimited(message string, err error) *AppError {
+	return New(ErrRateLimited, message, err)
+}
+
 func NewInvalidOTP(message string, err error) *AppError {
 	return New(ErrInvalidOTP, message, err)
 }
@@ -118,6 +173,14 @@ func NewOTPExpired(message string, err error) *AppError {
 	return New(ErrOTPExpired, message, err)
 }
 
+func NewOTPAlreadyUsed(message string, err error) *AppError {
+	return New(ErrOTPAlreadyUsed, message, err)
+}
+
+func NewOTPNotFound(message string, err error) *AppError {
+	return New(ErrOTPNotFound, message, err)
+}
+
 func NewTooManyAttempts(message string, err error) *AppError {
 	return New(ErrTooManyAttempts, message, err)
 }
@@ -129,3 +192,19 @@ func NewInvalidToken(message string, err error) *AppError {
 func NewTokenExpired(message string, err error) *AppError {
 	return New(ErrTokenExpired, message, err)
 }
+
+func NewDestinationBlocked(message string, err error) *AppError {
+	return New(ErrDestinationBlocked, message, err)
+}
+
+func NewCountryNotAllowed(message string, err error) *AppError {
+	return New(ErrCountryNotAllowed, message, err)
+}
+
+func NewIdempotencyKeyReused(message string, err error) *AppError {
+	return New(ErrIdempotencyKeyReused, message, err)
+}
+
+func NewRequestInProgress(message string, err error) *AppError {
+	return New(ErrRequestInProgress, message, err)
+}
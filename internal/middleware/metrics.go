@@ -37,6 +37,17 @@ var (
 		Help: "Total number of OTP verifications",
 	}, []string{"status"})
 
+	// Lockout metrics
+	loginFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "login_failed_attempts_total",
+		Help: "Total number of failed login attempts",
+	}, []string{"channel"})
+
+	loginLockouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "login_lockouts_total",
+		Help: "Total number of lockouts triggered by failed login attempts",
+	}, []string{"scope"})
+
 	// 2FA metrics
 	twoFAEnrollments = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "twofa_enrollments_total",
@@ -107,3 +118,13 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 		}
 	})
 }
+
+// RecordLoginFailure counts a failed login attempt on channel
+func RecordLoginFailure(channel string) {
+	loginFailures.WithLabelValues(channel).Inc()
+}
+
+// RecordLockout counts a lockout; scope is "challenge", "account" or "device"
+func RecordLockout(scope string) {
+	loginLockouts.WithLabelValues(scope).Inc()
+}
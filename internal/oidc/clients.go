@@ -0,0 +1,139 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// Grant types
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Client is an application registered to sign users in
+type Client struct {
+	ID           string
+	Name         string
+	RedirectURIs []string
+	GrantTypes   []string
+	secret       string
+}
+
+// Public reports whether the client has no secret, like single-page and
+// native apps that cannot keep one
+func (c *Client) Public() bool {
+	return c.secret == ""
+}
+
+// Authenticate reports whether secret is the client's secret
+func (c *Client) Authenticate(secret string) bool {
+	if c.Public() {
+		return false
+	}
+	// Compare hashes so the comparison does not leak the secret's length
+	want := sha256.Sum256([]byte(c.secret))
+	got := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1
+}
+
+// Allows reports whether the client may use grantType
+func (c *Client) Allows(grantType string) bool {
+	for _, allowed := range c.GrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRedirectURI reports whether uri is one of the client's redirect URIs.
+// URIs must match exactly.
+func (c *Client) ValidRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if uri == registered {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientStore holds the clients registered under oidc.clients
+type ClientStore struct {
+	clients map[string]*Client
+}
+
+func NewClientStore(cfg *config.Config) (*ClientStore, error) {
+	store := &ClientStore{
+		clients: make(map[string]*Client),
+	}
+
+	for _, clientCfg := range cfg.OIDC.Clients {
+		if _, ok := store.clients[clientCfg.ID]; ok {
+			return nil, fmt.Errorf("oidc client %q is registered twice", clientCfg.ID)
+		}
+
+		secret, err := clientCfg.Secret.Decrypt()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret of oidc client %q: %w", clientCfg.ID, err)
+		}
+		for _, uri := range clientCfg.RedirectURIs {
+			if err := checkRedirectURI(uri); err != nil {
+				return nil, fmt.Errorf("oidc client %q: %w", clientCfg.ID, err)
+			}
+		}
+
+		client := &Client{
+			ID:           clientCfg.ID,
+			Name:         clientCfg.Name,
+			RedirectURIs: clientCfg.RedirectURIs,
+			GrantTypes:   clientCfg.GrantTypes,
+			secret:       secret,
+		}
+		if len(client.GrantTypes) == 0 {
+			client.GrantTypes = []string{GrantAuthorizationCode}
+		}
+		if client.Allows(GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("oidc client %q needs a redirect URI for the authorization code grant", clientCfg.ID)
+		}
+		store.clients[clientCfg.ID] = client
+	}
+	return store, nil
+}
+
+// Get returns the client with id, or nil
+func (s *ClientStore) Get(id string) *Client {
+	return s.clients[id]
+}
+
+// checkRedirectURI requires absolute URIs without a fragment, served over
+// HTTPS unless they point at the loopback interface
+func checkRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q is not an absolute URL", uri)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q must not have a fragment", uri)
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	if u.Scheme == "http" && isLoopback(u.Hostname()) {
+		return nil
+	}
+	return fmt.Errorf("redirect URI %q must use https", uri)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,80 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+func newTestClientStore(t *testing.T, redirectURIs ...string) (*ClientStore, error) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.OIDC.Clients = []config.OIDCClientConfig{{
+		ID:           "app",
+		RedirectURIs: redirectURIs,
+	}}
+	return NewClientStore(cfg)
+}
+
+func TestValidRedirectURIMatchesExactly(t *testing.T) {
+	store, err := newTestClientStore(t, "https://app.example.com/callback", "http://127.0.0.1:8765/cb")
+	if err != nil {
+		t.Fatalf("NewClientStore() error = %v", err)
+	}
+	client := store.Get("app")
+
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"http://127.0.0.1:8765/cb", true},
+		{"https://app.example.com/callback/", false},
+		{"https://app.example.com/callback?next=/admin", false},
+		{"https://app.example.com/callback#frag", false},
+		{"https://APP.example.com/callback", false},
+		{"https://app.example.com/Callback", false},
+		{"https://app.example.com:443/callback", false},
+		{"http://app.example.com/callback", false},
+		{"https://app.example.com/callback/../callback", false},
+		{"https://app.example.com.evil.test/callback", false},
+		{"http://127.0.0.1:8766/cb", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := client.ValidRedirectURI(tt.uri); got != tt.want {
+			t.Errorf("ValidRedirectURI(%q) = %v, want %v", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestNewClientStoreChecksRedirectURIs(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{"https://app.example.com/callback", false},
+		{"http://localhost:8080/callback", false},
+		{"http://127.0.0.1/callback", false},
+		{"http://[::1]:8080/callback", false},
+		{"http://app.example.com/callback", true},
+		{"http://192.168.1.10/callback", true},
+		{"http://localhost.example.com/callback", true},
+		{"https://app.example.com/callback#token", true},
+		{"/callback", true},
+		{"com.example.app:/callback", true},
+		{"ftp://app.example.com/callback", true},
+	}
+	for _, tt := range tests {
+		_, err := newTestClientStore(t, tt.uri)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewClientStore() with %q error = %v, wantErr %v", tt.uri, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewClientStoreRequiresRedirectURI(t *testing.T) {
+	if _, err := newTestClientStore(t); err == nil {
+		t.Error("NewClientStore() accepted an authorization code client without a redirect URI")
+	}
+}
@@ -0,0 +1,220 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// DeviceAuthorizationPath is the device authorization endpoint (RFC 8628)
+const DeviceAuthorizationPath = "/api/v1/oidc/device/code"
+
+const (
+	// userCodeAlphabet has no vowels, so codes do not spell words, and no
+	// characters that are easily confused (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeLength gives 20^8, about 2^34, codes
+	userCodeLength = 8
+	// userCodeAttempts is how many times a fresh user code is drawn when
+	// the first is already in use
+	userCodeAttempts = 5
+)
+
+// DeviceRequest is a device authorization request
+type DeviceRequest struct {
+	ClientID     string
+	ClientSecret string
+	Scope        string
+}
+
+// DeviceAuthorizationResponse tells a device the codes to show its user
+// (RFC 8628 section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// Device is a device waiting for its user to approve it
+type Device struct {
+	Client    *Client
+	Scope     string
+	ExpiresAt time.Time
+}
+
+// AuthorizeDevice starts a device authorization and returns the device code
+// the device polls with and the user code its user enters
+func (p *Provider) AuthorizeDevice(ctx context.Context, req *DeviceRequest) (*DeviceAuthorizationResponse, error) {
+	client, err := p.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Allows(GrantDeviceCode) {
+		return nil, newError(ErrUnauthorizedClient, "the client may not use the device code grant", http.StatusBadRequest)
+	}
+
+	scope := req.Scope
+	if scope == "" {
+		scope = ScopeOpenID
+	}
+	if !hasScope(scope, ScopeOpenID) {
+		return nil, newError(ErrInvalidScope, "the openid scope is required", http.StatusBadRequest)
+	}
+
+	deviceCfg := p.config.OIDC.Device
+	device := &storage.DeviceAuthorization{
+		ClientID:  client.ID,
+		Scope:     grantedScope(scope),
+		Interval:  deviceCfg.Interval,
+		ExpiresAt: time.Now().Add(deviceCfg.CodeLifetime),
+	}
+	var deviceCode string
+	for i := 0; i < userCodeAttempts && deviceCode == ""; i++ {
+		device.UserCode, err = generateUserCode()
+		if err != nil {
+			return nil, serverError("failed to generate user code", err)
+		}
+		code, created, err := p.redisClient.CreateDeviceAuthorization(ctx, device)
+		if err != nil {
+			return nil, serverError("failed to create device authorization", err)
+		}
+		if created {
+			deviceCode = code
+		}
+	}
+	if deviceCode == "" {
+		return nil, serverError("failed to find an unused user code", nil)
+	}
+
+	userCode := FormatUserCode(device.UserCode)
+	complete, _ := url.Parse(deviceCfg.VerificationURI)
+	query := complete.Query()
+	query.Set("user_code", userCode)
+	complete.RawQuery = query.Encode()
+
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         deviceCfg.VerificationURI,
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               int(deviceCfg.CodeLifetime.Seconds()),
+		Interval:                int(deviceCfg.Interval.Seconds()),
+	}, nil
+}
+
+// Device returns the pending device authorization with userCode, or nil
+func (p *Provider) Device(ctx context.Context, userCode string) (*Device, error) {
+	userCode = NormalizeUserCode(userCode)
+	if len(userCode) != userCodeLength {
+		return nil, nil
+	}
+	device, err := p.redisClient.GetDeviceAuthorization(ctx, userCode)
+	if err != nil || device == nil {
+		return nil, err
+	}
+	client := p.clients.Get(device.ClientID)
+	if client == nil {
+		// The client has been removed from the configuration
+		return nil, nil
+	}
+	return &Device{
+		Client:    client,
+		Scope:     device.Scope,
+		ExpiresAt: device.ExpiresAt,
+	}, nil
+}
+
+// ApproveDevice lets the device with userCode sign in as the signed in user.
+// It returns false if there is no pending device with userCode.
+func (p *Provider) ApproveDevice(ctx context.Context, userCode string, claims *auth.Claims) (bool, error) {
+	userCode = NormalizeUserCode(userCode)
+	if len(userCode) != userCodeLength {
+		return false, nil
+	}
+	user, err := p.userAuthorization(ctx, claims)
+	if err != nil {
+		return false, err
+	}
+	return p.redisClient.ApproveDeviceAuthorization(ctx, userCode, user)
+}
+
+// DenyDevice refuses the device with userCode. It returns false if there is
+// no pending device with userCode.
+func (p *Provider) DenyDevice(ctx context.Context, userCode string) (bool, error) {
+	userCode = NormalizeUserCode(userCode)
+	if len(userCode) != userCodeLength {
+		return false, nil
+	}
+	return p.redisClient.DenyDeviceAuthorization(ctx, userCode)
+}
+
+// exchangeDeviceCode answers a device polling for its tokens
+func (p *Provider) exchangeDeviceCode(ctx context.Context, client *Client, req *TokenRequest) (*TokenResponse, error) {
+	if req.DeviceCode == "" {
+		return nil, newError(ErrInvalidRequest, "device_code is required", http.StatusBadRequest)
+	}
+
+	poll, err := p.redisClient.PollDeviceAuthorization(ctx, req.DeviceCode, client.ID)
+	if err != nil {
+		return nil, serverError("failed to poll device authorization", err)
+	}
+	switch poll.Status {
+	case storage.DeviceApproved:
+		return p.issueTokens(ctx, client, poll.Scope, "", poll.User)
+	case storage.DevicePending:
+		return nil, newError(ErrAuthorizationPending, "the user has not approved the device yet", http.StatusBadRequest)
+	case storage.DeviceSlowDown:
+		return nil, newError(ErrSlowDown, "poll less often", http.StatusBadRequest)
+	case storage.DeviceDenied:
+		return nil, newError(ErrAccessDenied, "the user denied the device", http.StatusBadRequest)
+	case storage.DeviceExpired:
+		return nil, newError(ErrExpiredToken, "the device code has expired", http.StatusBadRequest)
+	default:
+		return nil, newError(ErrInvalidGrant, "device code is invalid or already used", http.StatusBadRequest)
+	}
+}
+
+// generateUserCode returns a random user code in its normalized form
+func generateUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, userCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// FormatUserCode splits a normalized user code in two halves for display,
+// as in BDFG-HJKL
+func FormatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
+
+// NormalizeUserCode undoes formatting and capitalization the user may have
+// typed, so BDFG-HJKL, bdfg hjkl and BDFGHJKL are the same code
+func NormalizeUserCode(code string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '-' || unicode.IsSpace(c) {
+			return -1
+		}
+		return unicode.ToUpper(c)
+	}, code)
+}
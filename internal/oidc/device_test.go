@@ -0,0 +1,114 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// newDeviceProvider returns a provider with a public client allowed to use
+// the device code grant. Approved devices need a token manager, so only the
+// other outcomes can be exchanged.
+func newDeviceProvider(t *testing.T) *Provider {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	cfg := &config.Config{}
+	cfg.Redis.Host, cfg.Redis.Port, _ = strings.Cut(mr.Addr(), ":")
+	cfg.OIDC.Device.Enabled = true
+	cfg.OIDC.Device.VerificationURI = "https://auth.example.com/device"
+	cfg.OIDC.Device.Interval = 5 * time.Second
+	cfg.OIDC.Device.CodeLifetime = 10 * time.Minute
+	cfg.OIDC.Clients = []config.OIDCClientConfig{{ID: "tv", GrantTypes: []string{GrantDeviceCode}}}
+
+	redisClient, err := storage.NewRedisClient(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	clients, err := NewClientStore(cfg)
+	if err != nil {
+		t.Fatalf("NewClientStore() error = %v", err)
+	}
+	return &Provider{config: cfg, clients: clients, redisClient: redisClient}
+}
+
+func assertOIDCError(t *testing.T, err error, code string) {
+	t.Helper()
+	var oidcErr *Error
+	if !errors.As(err, &oidcErr) || oidcErr.Code != code {
+		t.Errorf("error = %v, want %s", err, code)
+	}
+}
+
+func TestExchangeDeviceCode(t *testing.T) {
+	p := newDeviceProvider(t)
+	ctx := context.Background()
+
+	resp, err := p.AuthorizeDevice(ctx, &DeviceRequest{ClientID: "tv"})
+	if err != nil {
+		t.Fatalf("AuthorizeDevice() error = %v", err)
+	}
+	if resp.Interval != 5 || resp.ExpiresIn != 600 {
+		t.Errorf("interval = %d, expires_in = %d, want 5 and 600", resp.Interval, resp.ExpiresIn)
+	}
+	if want := "https://auth.example.com/device?user_code=" + resp.UserCode; resp.VerificationURIComplete != want {
+		t.Errorf("verification_uri_complete = %q, want %q", resp.VerificationURIComplete, want)
+	}
+
+	poll := &TokenRequest{GrantType: GrantDeviceCode, ClientID: "tv", DeviceCode: resp.DeviceCode}
+	_, err = p.Exchange(ctx, poll)
+	assertOIDCError(t, err, ErrAuthorizationPending)
+	_, err = p.Exchange(ctx, poll)
+	assertOIDCError(t, err, ErrSlowDown)
+
+	// The user code is accepted as the user may type it
+	device, err := p.Device(ctx, strings.ToLower(strings.ReplaceAll(resp.UserCode, "-", " ")))
+	if err != nil || device == nil || device.Client.ID != "tv" {
+		t.Fatalf("Device() = %+v, %v, want the pending device", device, err)
+	}
+	denied, err := p.DenyDevice(ctx, resp.UserCode)
+	if err != nil || !denied {
+		t.Fatalf("DenyDevice() = %v, %v, want denied", denied, err)
+	}
+
+	_, err = p.Exchange(ctx, poll)
+	assertOIDCError(t, err, ErrAccessDenied)
+	_, err = p.Exchange(ctx, poll)
+	assertOIDCError(t, err, ErrInvalidGrant)
+}
+
+func TestExchangeDeviceCodeRequiresDeviceGrant(t *testing.T) {
+	p := newDeviceProvider(t)
+	ctx := context.Background()
+
+	resp, err := p.AuthorizeDevice(ctx, &DeviceRequest{ClientID: "tv"})
+	if err != nil {
+		t.Fatalf("AuthorizeDevice() error = %v", err)
+	}
+
+	_, err = p.Exchange(ctx, &TokenRequest{GrantType: GrantDeviceCode, ClientID: "tv"})
+	assertOIDCError(t, err, ErrInvalidRequest)
+
+	p.config.OIDC.Device.Enabled = false
+	_, err = p.Exchange(ctx, &TokenRequest{GrantType: GrantDeviceCode, ClientID: "tv", DeviceCode: resp.DeviceCode})
+	assertOIDCError(t, err, ErrUnsupportedGrantType)
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for _, code := range []string{"BDFG-HJKL", "bdfg hjkl", "BDFGHJKL", " bdfg-hjkl\t"} {
+		if got := NormalizeUserCode(code); got != "BDFGHJKL" {
+			t.Errorf("NormalizeUserCode(%q) = %q, want BDFGHJKL", code, got)
+		}
+	}
+	if got := FormatUserCode("BDFGHJKL"); got != "BDFG-HJKL" {
+		t.Errorf("FormatUserCode() = %q, want BDFG-HJKL", got)
+	}
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import "net/http"
+
+// Error codes of OAuth 2.0 and OpenID Connect error responses
+const (
+	ErrInvalidRequest          = "invalid_request"
+	ErrInvalidClient           = "invalid_client"
+	ErrInvalidGrant            = "invalid_grant"
+	ErrUnsupportedGrantType    = "unsupported_grant_type"
+	ErrUnsupportedResponseType = "unsupported_response_type"
+	ErrInvalidScope            = "invalid_scope"
+	ErrLoginRequired           = "login_required"
+	ErrServerError             = "server_error"
+	ErrInvalidToken            = "invalid_token"
+	ErrInsufficientScope       = "insufficient_scope"
+	ErrUnauthorizedClient      = "unauthorized_client"
+	ErrAccessDenied            = "access_denied"
+	// Device authorization grant (RFC 8628 section 3.5)
+	ErrAuthorizationPending = "authorization_pending"
+	ErrSlowDown             = "slow_down"
+	ErrExpiredToken         = "expired_token"
+)
+
+// Error is an OAuth 2.0 error response (RFC 6749 section 5.2)
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	// Status is the HTTP status of the response when the error is not
+	// returned through a redirect
+	Status int `json:"-"`
+	// Err is the underlying error of server errors
+	Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Description + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Description
+}
+
+func newError(code, description string, status int) *Error {
+	return &Error{Code: code, Description: description, Status: status}
+}
+
+func serverError(description string, err error) *Error {
+	return &Error{Code: ErrServerError, Description: description, Status: http.StatusInternalServerError, Err: err}
+}
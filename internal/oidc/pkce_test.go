@@ -0,0 +1,93 @@
+package oidc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The example of RFC 7636 appendix B
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		want     bool
+	}{
+		{"matching verifier", rfcVerifier, true},
+		{"wrong verifier", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXK", false},
+		{"challenge as a plain verifier", rfcChallenge, false},
+		{"too short", rfcVerifier[:42], false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"reserved character", rfcVerifier[:42] + "+", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeVerifier(tt.verifier, rfcChallenge); got != tt.want {
+				t.Errorf("verifyCodeVerifier(%q) = %v, want %v", tt.verifier, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCodeChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		want      bool
+	}{
+		{rfcChallenge, true},
+		// A plain challenge is the verifier itself, which is not a hash
+		{rfcVerifier + "abc", false},
+		{rfcChallenge[:42], false},
+		{rfcChallenge + "=", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validCodeChallenge(tt.challenge); got != tt.want {
+			t.Errorf("validCodeChallenge(%q) = %v, want %v", tt.challenge, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRequestRequiresS256(t *testing.T) {
+	p := &Provider{}
+	tests := []struct {
+		name      string
+		method    string
+		challenge string
+		wantErr   bool
+	}{
+		{"S256", CodeChallengeS256, rfcChallenge, false},
+		{"plain", "plain", rfcVerifier, true},
+		{"plain with a hash-shaped challenge", "plain", rfcChallenge, true},
+		{"no method", "", rfcChallenge, true},
+		{"lowercase method", "s256", rfcChallenge, true},
+		{"no challenge", CodeChallengeS256, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &AuthorizeRequest{
+				ResponseType:        "code",
+				Scope:               ScopeOpenID,
+				CodeChallenge:       tt.challenge,
+				CodeChallengeMethod: tt.method,
+			}
+			err := p.CheckRequest(req)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("CheckRequest() error = %v", err)
+				}
+				return
+			}
+			var oidcErr *Error
+			if !errors.As(err, &oidcErr) || oidcErr.Code != ErrInvalidRequest {
+				t.Errorf("CheckRequest() error = %v, want %s", err, ErrInvalidRequest)
+			}
+		})
+	}
+}
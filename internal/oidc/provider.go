@@ -0,0 +1,484 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/auth"
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// Endpoint paths
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks.json"
+	AuthorizePath = "/api/v1/oidc/authorize"
+	TokenPath     = "/api/v1/oidc/token"
+	UserInfoPath  = "/api/v1/oidc/userinfo"
+)
+
+// Scopes
+const (
+	ScopeOpenID = "openid"
+	ScopePhone  = "phone"
+	ScopeEmail  = "email"
+)
+
+// supportedScopes are granted when requested; other scopes are ignored
+var supportedScopes = []string{ScopeOpenID, ScopePhone, ScopeEmail}
+
+// AuthorizeRequest is an authorization request (OpenID Connect Core 3.1.2.1)
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+// Values encodes the request as query parameters
+func (r *AuthorizeRequest) Values() url.Values {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("client_id", r.ClientID)
+	set("redirect_uri", r.RedirectURI)
+	set("response_type", r.ResponseType)
+	set("scope", r.Scope)
+	set("state", r.State)
+	set("nonce", r.Nonce)
+	set("code_challenge", r.CodeChallenge)
+	set("code_challenge_method", r.CodeChallengeMethod)
+	set("prompt", r.Prompt)
+	return v
+}
+
+// TokenRequest is a token request with the client's credentials
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	DeviceCode   string
+	ClientID     string
+	ClientSecret string
+}
+
+// TokenResponse is a successful token response
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+// IDTokenClaims are the claims of an ID token
+type IDTokenClaims struct {
+	Nonce               string           `json:"nonce,omitempty"`
+	AuthTime            *jwt.NumericDate `json:"auth_time,omitempty"`
+	AuthMethods         []string         `json:"amr,omitempty"`
+	AuthorizedParty     string           `json:"azp,omitempty"`
+	SessionID           string           `json:"sid,omitempty"`
+	PhoneNumber         string           `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool            `json:"phone_number_verified,omitempty"`
+	Email               string           `json:"email,omitempty"`
+	EmailVerified       *bool            `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Discovery is the OpenID Provider metadata document
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	ResponseModesSupported            []string `json:"response_modes_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	AuthorizationResponseISSSupported bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// Provider is an OpenID Connect provider that signs users in to registered
+// clients with the authorization code flow and PKCE
+type Provider struct {
+	config      *config.Config
+	clients     *ClientStore
+	tokens      *auth.TokenManager
+	redisClient *storage.RedisClient
+}
+
+func NewProvider(cfg *config.Config, tokens *auth.TokenManager, redisClient *storage.RedisClient) (*Provider, error) {
+	// Clients verify ID tokens with the published keys, which never
+	// include HMAC secrets
+	if tokens.Algorithm() == jwt.SigningMethodHS256.Alg() {
+		return nil, fmt.Errorf("oidc requires jwt.algorithm RS256, ES256 or EdDSA")
+	}
+
+	clients, err := NewClientStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		config:      cfg,
+		clients:     clients,
+		tokens:      tokens,
+		redisClient: redisClient,
+	}, nil
+}
+
+// Discovery returns the provider metadata
+func (p *Provider) Discovery() *Discovery {
+	issuer := p.issuer()
+	discovery := &Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + AuthorizePath,
+		TokenEndpoint:                     issuer + TokenPath,
+		UserInfoEndpoint:                  issuer + UserInfoPath,
+		JWKSURI:                           issuer + JWKSPath,
+		ScopesSupported:                   supportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		ResponseModesSupported:            []string{"query"},
+		GrantTypesSupported:               []string{GrantAuthorizationCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{p.tokens.Algorithm()},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeS256},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "azp", "sid",
+			"phone_number", "phone_number_verified", "email", "email_verified",
+		},
+		AuthorizationResponseISSSupported: true,
+	}
+	if p.config.OIDC.Device.Enabled {
+		discovery.DeviceAuthorizationEndpoint = issuer + DeviceAuthorizationPath
+		discovery.GrantTypesSupported = append(discovery.GrantTypesSupported, GrantDeviceCode)
+	}
+	return discovery
+}
+
+// CheckRedirect checks the client and redirect URI of req. Errors must be
+// shown to the user rather than sent to the unverified redirect URI.
+func (p *Provider) CheckRedirect(req *AuthorizeRequest) error {
+	client := p.clients.Get(req.ClientID)
+	if client == nil {
+		return newError(ErrInvalidClient, "unknown client_id", http.StatusBadRequest)
+	}
+	if !client.Allows(GrantAuthorizationCode) {
+		return newError(ErrUnauthorizedClient, "the client may not use the authorization code grant", http.StatusBadRequest)
+	}
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	if !client.ValidRedirectURI(req.RedirectURI) {
+		return newError(ErrInvalidRequest, "redirect_uri is not registered for this client", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// CheckRequest checks the remaining parameters of a request that passed
+// CheckRedirect. Errors are sent to the redirect URI.
+func (p *Provider) CheckRequest(req *AuthorizeRequest) error {
+	if req.ResponseType != "code" {
+		return newError(ErrUnsupportedResponseType, "only the code response type is supported", http.StatusBadRequest)
+	}
+	if !hasScope(req.Scope, ScopeOpenID) {
+		return newError(ErrInvalidScope, "the openid scope is required", http.StatusBadRequest)
+	}
+	if req.CodeChallengeMethod != CodeChallengeS256 || !validCodeChallenge(req.CodeChallenge) {
+		return newError(ErrInvalidRequest, "an S256 code_challenge is required", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// Authorize issues an authorization code for the signed in user
+func (p *Provider) Authorize(ctx context.Context, req *AuthorizeRequest, claims *auth.Claims) (string, error) {
+	user, err := p.userAuthorization(ctx, claims)
+	if err != nil {
+		return "", err
+	}
+
+	code := &storage.AuthorizationCode{
+		ClientID:            req.ClientID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               grantedScope(req.Scope),
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		UserAuthorization:   *user,
+	}
+	token, err := p.redisClient.CreateAuthorizationCode(ctx, code, p.config.OIDC.CodeLifetime)
+	if err != nil {
+		return "", serverError("failed to create authorization code", err)
+	}
+	return token, nil
+}
+
+// ResponseURL returns the redirect URI of req with params, the state and
+// the issuer added
+func (p *Provider) ResponseURL(req *AuthorizeRequest, params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	params.Set("iss", p.issuer())
+
+	u, _ := url.Parse(req.RedirectURI)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ErrorURL returns the redirect URI of req with err
+func (p *Provider) ErrorURL(req *AuthorizeRequest, err *Error) string {
+	params := url.Values{"error": {err.Code}}
+	if err.Description != "" {
+		params.Set("error_description", err.Description)
+	}
+	return p.ResponseURL(req, params)
+}
+
+// LoginURL returns the sign-in page, told to return to req once the user
+// has signed in
+func (p *Provider) LoginURL(req *AuthorizeRequest) string {
+	returnTo := p.issuer() + AuthorizePath + "?" + req.Values().Encode()
+
+	u, _ := url.Parse(p.config.OIDC.LoginURL)
+	query := u.Query()
+	query.Set("return_to", returnTo)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Exchange redeems an authorization code or an approved device code for an
+// access token and ID token
+func (p *Provider) Exchange(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	grantType := req.GrantType
+	if grantType == GrantDeviceCode && !p.config.OIDC.Device.Enabled {
+		grantType = ""
+	}
+	if grantType != GrantAuthorizationCode && grantType != GrantDeviceCode {
+		return nil, newError(ErrUnsupportedGrantType, "grant_type is not supported", http.StatusBadRequest)
+	}
+
+	client, err := p.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Allows(grantType) {
+		return nil, newError(ErrUnauthorizedClient, "the client may not use this grant type", http.StatusBadRequest)
+	}
+
+	if grantType == GrantDeviceCode {
+		return p.exchangeDeviceCode(ctx, client, req)
+	}
+	return p.exchangeCode(ctx, client, req)
+}
+
+// exchangeCode redeems an authorization code
+func (p *Provider) exchangeCode(ctx context.Context, client *Client, req *TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, newError(ErrInvalidRequest, "code and code_verifier are required", http.StatusBadRequest)
+	}
+
+	code, err := p.redisClient.ConsumeAuthorizationCode(ctx, req.Code)
+	if err != nil {
+		return nil, serverError("failed to redeem authorization code", err)
+	}
+	switch {
+	case code == nil:
+		return nil, newError(ErrInvalidGrant, "authorization code is invalid, expired or already used", http.StatusBadRequest)
+	case code.ClientID != client.ID:
+		return nil, newError(ErrInvalidGrant, "authorization code was issued to another client", http.StatusBadRequest)
+	case code.RedirectURI != req.RedirectURI:
+		return nil, newError(ErrInvalidGrant, "redirect_uri does not match the authorization request", http.StatusBadRequest)
+	case !verifyCodeVerifier(req.CodeVerifier, code.CodeChallenge):
+		return nil, newError(ErrInvalidGrant, "code_verifier does not match the code_challenge", http.StatusBadRequest)
+	}
+
+	return p.issueTokens(ctx, client, code.Scope, code.Nonce, &code.UserAuthorization)
+}
+
+// authenticateClient returns the client with id. Confidential clients must
+// present their secret; public clients must not present one.
+func (p *Provider) authenticateClient(id, secret string) (*Client, error) {
+	client := p.clients.Get(id)
+	switch {
+	case client == nil:
+		return nil, newError(ErrInvalidClient, "unknown client", http.StatusUnauthorized)
+	case client.Public() && secret != "":
+		return nil, newError(ErrInvalidClient, "public clients have no secret", http.StatusUnauthorized)
+	case !client.Public() && !client.Authenticate(secret):
+		return nil, newError(ErrInvalidClient, "client authentication failed", http.StatusUnauthorized)
+	}
+	return client, nil
+}
+
+// issueTokens signs the access token and ID token of a grant
+func (p *Provider) issueTokens(ctx context.Context, client *Client, scope, nonce string, user *storage.UserAuthorization) (*TokenResponse, error) {
+	accessToken, accessExpiresAt, err := p.tokens.GenerateToken(ctx, &auth.Claims{
+		Phone:         user.Phone,
+		Email:         user.Email,
+		TwoFAEnabled:  user.TwoFAEnabled,
+		TwoFAVerified: user.TwoFAVerified,
+		SessionID:     user.SessionID,
+		ClientID:      client.ID,
+		Scope:         scope,
+	})
+	if err != nil {
+		return nil, serverError("failed to generate access token", err)
+	}
+
+	idToken, err := p.idToken(client, scope, nonce, user)
+	if err != nil {
+		return nil, serverError("failed to generate ID token", err)
+	}
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(accessExpiresAt).Seconds()),
+		IDToken:     idToken,
+		Scope:       scope,
+	}, nil
+}
+
+// UserInfo returns the claims of the user an access token was issued to,
+// limited to the token's scope
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	claims, err := p.tokens.ValidateToken(ctx, accessToken)
+	switch {
+	case err == auth.ErrTokenExpired, err == auth.ErrTokenRevoked, err == auth.ErrInvalidToken:
+		return nil, newError(ErrInvalidToken, err.Error(), http.StatusUnauthorized)
+	case err != nil:
+		return nil, serverError("failed to validate access token", err)
+	}
+	if claims.ClientID == "" || !hasScope(claims.Scope, ScopeOpenID) {
+		return nil, newError(ErrInsufficientScope, "the access token was not issued for the openid scope", http.StatusForbidden)
+	}
+
+	info := map[string]interface{}{
+		"sub": claims.Subject,
+	}
+	if claims.Phone != "" && hasScope(claims.Scope, ScopePhone) {
+		info["phone_number"] = claims.Phone
+		info["phone_number_verified"] = true
+	}
+	if claims.Email != "" && hasScope(claims.Scope, ScopeEmail) {
+		info["email"] = claims.Email
+		info["email_verified"] = true
+	}
+	return info, nil
+}
+
+// idToken signs an ID token for user
+func (p *Provider) idToken(client *Client, scope, nonce string, user *storage.UserAuthorization) (string, error) {
+	now := time.Now()
+	subject := user.Phone
+	if subject == "" {
+		subject = user.Email
+	}
+
+	claims := &IDTokenClaims{
+		Nonce:           nonce,
+		AuthTime:        jwt.NewNumericDate(user.AuthTime),
+		AuthMethods:     user.AuthMethods,
+		AuthorizedParty: client.ID,
+		SessionID:       user.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.issuer(),
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(p.config.OIDC.IDTokenLifetime)),
+		},
+	}
+	// Codes are only sent once the user has proved control of the phone
+	// number or email address
+	verified := true
+	if user.Phone != "" && hasScope(scope, ScopePhone) {
+		claims.PhoneNumber = user.Phone
+		claims.PhoneNumberVerified = &verified
+	}
+	if user.Email != "" && hasScope(scope, ScopeEmail) {
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+
+	return p.tokens.Sign(claims)
+}
+
+// userAuthorization records the signed in user and when and how they
+// signed in, as told by their session
+func (p *Provider) userAuthorization(ctx context.Context, claims *auth.Claims) (*storage.UserAuthorization, error) {
+	user := &storage.UserAuthorization{
+		Phone:         claims.Phone,
+		Email:         claims.Email,
+		TwoFAEnabled:  claims.TwoFAEnabled,
+		TwoFAVerified: claims.TwoFAVerified,
+		SessionID:     claims.SessionID,
+		AuthTime:      claims.IssuedAt.Time,
+	}
+	if claims.SessionID == "" {
+		return user, nil
+	}
+
+	session, err := p.redisClient.GetRefreshSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, serverError("failed to get session", err)
+	}
+	if session != nil {
+		user.AuthTime = session.CreatedAt
+		user.AuthMethods = session.AuthMethods
+	}
+	return user, nil
+}
+
+func (p *Provider) issuer() string {
+	return strings.TrimSuffix(p.config.OIDC.Issuer, "/")
+}
+
+// hasScope reports whether the space-separated scope list contains scope
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// grantedScope returns the supported scopes of a requested scope list
+func grantedScope(requested string) string {
+	var granted []string
+	for _, scope := range supportedScopes {
+		if hasScope(requested, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return strings.Join(granted, " ")
+}
@@ -0,0 +1,118 @@
+package phone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/nyaruka/phonenumbers"
+)
+
+// ValidationError describes why a phone number was rejected. Code is a stable
+// machine-readable reason suitable for field-level API errors.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrRequired           = &ValidationError{Code: "required", Message: "phone number is required"}
+	ErrMissingCountryCode = &ValidationError{Code: "missing_country_code", Message: "phone number must include a country code, e.g. +14155550100"}
+	ErrInvalidFormat      = &ValidationError{Code: "invalid_format", Message: "phone number could not be parsed"}
+	ErrInvalidNumber      = &ValidationError{Code: "invalid_number", Message: "phone number is not a valid number"}
+	ErrNotMobile          = &ValidationError{Code: "not_mobile", Message: "phone number is not a mobile number"}
+)
+
+// Number is a parsed and validated phone number
+type Number struct {
+	// E164 is the canonical form, e.g. "+16052504547". It is the only form
+	// that should be stored, used as a key or sent to providers.
+	E164 string
+	// Region is the ISO 3166-1 alpha-2 region the number belongs to, e.g. "US"
+	Region string
+	// CountryCode is the international calling code, e.g. 1
+	CountryCode int32
+	// Mobile reports whether the number can receive SMS
+	Mobile bool
+}
+
+// CallingCode returns the international calling code with a leading plus, e.g. "+1"
+func (n *Number) CallingCode() string {
+	return fmt.Sprintf("+%d", n.CountryCode)
+}
+
+// Parser normalizes user supplied phone numbers according to the phone
+// configuration
+type Parser struct {
+	config *config.Config
+}
+
+func NewParser(cfg *config.Config) *Parser {
+	return &Parser{
+		config: cfg,
+	}
+}
+
+// Parse validates raw and returns it in canonical form. Errors are always
+// *ValidationError.
+func (p *Parser) Parse(raw string) (*Number, error) {
+	num, err := parse(raw, p.config.Phone.DefaultRegion)
+	if err != nil {
+		return nil, err
+	}
+	if p.config.Phone.MobileOnly && !num.Mobile {
+		return nil, ErrNotMobile
+	}
+	return num, nil
+}
+
+// Normalize returns the E.164 form of raw
+func (p *Parser) Normalize(raw string) (string, error) {
+	num, err := p.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return num.E164, nil
+}
+
+// CallingCode returns the calling code of an E.164 number, such as "+44", or
+// an empty string if it cannot be determined
+func CallingCode(e164 string) string {
+	num, err := parse(e164, "")
+	if err != nil {
+		return ""
+	}
+	return num.CallingCode()
+}
+
+func parse(raw, defaultRegion string) (*Number, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, ErrRequired
+	}
+
+	num, err := phonenumbers.Parse(raw, defaultRegion)
+	switch {
+	case err == phonenumbers.ErrInvalidCountryCode:
+		return nil, ErrMissingCountryCode
+	case err != nil:
+		return nil, ErrInvalidFormat
+	}
+
+	if !phonenumbers.IsValidNumber(num) {
+		return nil, ErrInvalidNumber
+	}
+
+	numberType := phonenumbers.GetNumberType(num)
+	return &Number{
+		E164:        phonenumbers.Format(num, phonenumbers.E164),
+		Region:      phonenumbers.GetRegionCodeForNumber(num),
+		CountryCode: num.GetCountryCode(),
+		// Regions such as the US do not distinguish mobile from fixed line ranges
+		Mobile: numberType == phonenumbers.MOBILE || numberType == phonenumbers.FIXED_LINE_OR_MOBILE,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package phone
+
+import (
+	"testing"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+func newTestParser(defaultRegion string, mobileOnly bool) *Parser {
+	cfg := &config.Config{}
+	cfg.Phone.DefaultRegion = defaultRegion
+	cfg.Phone.MobileOnly = mobileOnly
+	return NewParser(cfg)
+}
+
+func TestParserParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		defaultRegion string
+		mobileOnly    bool
+		want          string
+		wantRegion    string
+		wantErr       error
+	}{
+		{"formatted", "+1 (605) 250-4547", "", false, "+16052504547", "US", nil},
+		{"default region", "(605) 250-4547", "US", false, "+16052504547", "US", nil},
+		{"national with default region", "07400 123456", "GB", true, "+447400123456", "GB", nil},
+		{"missing country code", "605 250 4547", "", false, "", "", ErrMissingCountryCode},
+		{"empty", "  ", "US", false, "", "", ErrRequired},
+		{"not a number", "+1 call me", "", false, "", "", ErrInvalidFormat},
+		{"invalid number", "+1 200 555 0100", "", false, "", "", ErrInvalidNumber},
+		{"fixed line", "+44 20 7946 0958", "", false, "+442079460958", "GB", nil},
+		{"fixed line mobile only", "+44 20 7946 0958", "", true, "", "", ErrNotMobile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			num, err := newTestParser(tt.defaultRegion, tt.mobileOnly).Parse(tt.raw)
+			if err != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, want %v", tt.raw, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if num.E164 != tt.want || num.Region != tt.wantRegion {
+				t.Errorf("Parse(%q) = %s in %s, want %s in %s", tt.raw, num.E164, num.Region, tt.want, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestCallingCode(t *testing.T) {
+	tests := map[string]string{
+		"+16052504547":  "+1",
+		"+447400123456": "+44",
+		"16052504547":   "",
+	}
+	for e164, want := range tests {
+		if got := CallingCode(e164); got != want {
+			t.Errorf("CallingCode(%q) = %q, want %q", e164, got, want)
+		}
+	}
+}
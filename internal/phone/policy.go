@@ -0,0 +1,86 @@
+package phone
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// ErrCountryNotAllowed is returned for numbers in a region the country policy refuses
+var ErrCountryNotAllowed = &ValidationError{Code: "country_not_allowed", Message: "phone numbers from this country are not supported"}
+
+// CountryRules are the effective OTP settings for a destination
+type CountryRules struct {
+	Region      string
+	OTPLength   int
+	OTPExpiry   time.Duration
+	SMSProvider string
+}
+
+// CountryPolicy applies security.countries. It reads the configuration on
+// every call so changes picked up by the ConfigManager apply immediately.
+type CountryPolicy struct {
+	configManager *config.ConfigManager
+}
+
+func NewCountryPolicy(cm *config.ConfigManager) *CountryPolicy {
+	return &CountryPolicy{
+		configManager: cm,
+	}
+}
+
+// Defaults returns the global OTP settings, used for non-phone channels
+func (p *CountryPolicy) Defaults() *CountryRules {
+	cfg := p.configManager.GetConfig()
+	return &CountryRules{
+		OTPLength: cfg.Security.OTPLength,
+		OTPExpiry: cfg.Security.OTPExpiry,
+	}
+}
+
+// Rules returns the settings for the E.164 number e164, or
+// ErrCountryNotAllowed if OTPs may not be sent to its region
+func (p *CountryPolicy) Rules(e164 string) (*CountryRules, error) {
+	num, err := parse(e164, "")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := p.configManager.GetConfig()
+	countries := cfg.Security.Countries
+	region := num.Region
+
+	if containsRegion(countries.Deny, region) {
+		return nil, ErrCountryNotAllowed
+	}
+	if len(countries.Allow) > 0 && !containsRegion(countries.Allow, region) {
+		return nil, ErrCountryNotAllowed
+	}
+
+	rules := &CountryRules{
+		Region:    region,
+		OTPLength: cfg.Security.OTPLength,
+		OTPExpiry: cfg.Security.OTPExpiry,
+	}
+	// Map keys are lower-cased when the configuration is loaded
+	if override, ok := countries.Overrides[strings.ToLower(region)]; ok {
+		if override.OTPLength > 0 {
+			rules.OTPLength = override.OTPLength
+		}
+		if override.OTPExpiry > 0 {
+			rules.OTPExpiry = override.OTPExpiry
+		}
+		rules.SMSProvider = override.SMSProvider
+	}
+	return rules, nil
+}
+
+func containsRegion(regions []string, region string) bool {
+	for _, r := range regions {
+		if strings.EqualFold(r, region) {
+			return true
+		}
+	}
+	return false
+}
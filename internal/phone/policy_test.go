@@ -0,0 +1,121 @@
+package phone
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const (
+	usNumber = "+16052504547"
+	gbNumber = "+447400123456"
+)
+
+func newTestPolicy(countries config.CountryPolicyConfig) (*CountryPolicy, *config.ConfigManager) {
+	cm := &config.ConfigManager{}
+	cm.SetConfig(newPolicyTestConfig(countries))
+	return NewCountryPolicy(cm), cm
+}
+
+func newPolicyTestConfig(countries config.CountryPolicyConfig) *config.Config {
+	cfg := &config.Config{}
+	cfg.Security.OTPLength = 6
+	cfg.Security.OTPExpiry = 5 * time.Minute
+	cfg.Security.Countries = countries
+	return cfg
+}
+
+func TestCountryPolicyAllowDeny(t *testing.T) {
+	tests := []struct {
+		name      string
+		countries config.CountryPolicyConfig
+		number    string
+		allowed   bool
+	}{
+		{"empty allowlist allows everything", config.CountryPolicyConfig{}, gbNumber, true},
+		{"allowlisted", config.CountryPolicyConfig{Allow: []string{"US", "GB"}}, gbNumber, true},
+		{"not allowlisted", config.CountryPolicyConfig{Allow: []string{"US"}}, gbNumber, false},
+		{"allowlist ignores case", config.CountryPolicyConfig{Allow: []string{"gb"}}, gbNumber, true},
+		{"denied", config.CountryPolicyConfig{Deny: []string{"GB"}}, gbNumber, false},
+		{"deny only refuses its regions", config.CountryPolicyConfig{Deny: []string{"GB"}}, usNumber, true},
+		{"deny beats allow", config.CountryPolicyConfig{Allow: []string{"GB", "US"}, Deny: []string{"GB"}}, gbNumber, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, _ := newTestPolicy(tt.countries)
+			_, err := policy.Rules(tt.number)
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Fatalf("Rules(%s) error = %v, want allowed %v", tt.number, err, tt.allowed)
+			}
+			if err != nil && err != ErrCountryNotAllowed {
+				t.Errorf("Rules(%s) error = %v, want ErrCountryNotAllowed", tt.number, err)
+			}
+		})
+	}
+}
+
+func TestCountryPolicyOverrides(t *testing.T) {
+	// Overrides are keyed by lower-case region, as viper loads map keys
+	policy, _ := newTestPolicy(config.CountryPolicyConfig{
+		Overrides: map[string]config.CountryOverride{
+			"gb": {OTPLength: 8, OTPExpiry: 10 * time.Minute, SMSProvider: "vonage"},
+		},
+	})
+
+	rules, err := policy.Rules(gbNumber)
+	if err != nil {
+		t.Fatalf("Rules() error = %v", err)
+	}
+	if rules.Region != "GB" || rules.OTPLength != 8 || rules.OTPExpiry != 10*time.Minute || rules.SMSProvider != "vonage" {
+		t.Errorf("Rules(%s) = %+v, want the GB override", gbNumber, rules)
+	}
+
+	rules, err = policy.Rules(usNumber)
+	if err != nil {
+		t.Fatalf("Rules() error = %v", err)
+	}
+	if rules.Region != "US" || rules.OTPLength != 6 || rules.OTPExpiry != 5*time.Minute || rules.SMSProvider != "" {
+		t.Errorf("Rules(%s) = %+v, want the global settings", usNumber, rules)
+	}
+}
+
+func TestCountryPolicyOverrideKeepsUnsetSettings(t *testing.T) {
+	policy, _ := newTestPolicy(config.CountryPolicyConfig{
+		Overrides: map[string]config.CountryOverride{"gb": {SMSProvider: "sns"}},
+	})
+
+	rules, err := policy.Rules(gbNumber)
+	if err != nil {
+		t.Fatalf("Rules() error = %v", err)
+	}
+	if rules.OTPLength != 6 || rules.OTPExpiry != 5*time.Minute || rules.SMSProvider != "sns" {
+		t.Errorf("Rules(%s) = %+v, want the global length and expiry with sns", gbNumber, rules)
+	}
+}
+
+func TestCountryPolicyReload(t *testing.T) {
+	policy, cm := newTestPolicy(config.CountryPolicyConfig{})
+	if _, err := policy.Rules(gbNumber); err != nil {
+		t.Fatalf("Rules() error = %v", err)
+	}
+
+	cm.SetConfig(newPolicyTestConfig(config.CountryPolicyConfig{
+		Deny:      []string{"GB"},
+		Overrides: map[string]config.CountryOverride{"us": {OTPLength: 4}},
+	}))
+
+	if _, err := policy.Rules(gbNumber); err != ErrCountryNotAllowed {
+		t.Errorf("Rules(%s) after reload error = %v, want ErrCountryNotAllowed", gbNumber, err)
+	}
+	rules, err := policy.Rules(usNumber)
+	if err != nil {
+		t.Fatalf("Rules() error = %v", err)
+	}
+	if rules.OTPLength != 4 {
+		t.Errorf("Rules(%s) after reload OTPLength = %d, want 4", usNumber, rules.OTPLength)
+	}
+	if defaults := policy.Defaults(); defaults.OTPLength != 6 {
+		t.Errorf("Defaults() OTPLength = %d, want 6", defaults.OTPLength)
+	}
+}
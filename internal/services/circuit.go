@@ -6,7 +6,11 @@ import (
 	"github.com/sony/gobreaker"
 )
 
-func NewCircuitBreaker(name string) *gobreaker.CircuitBreaker {
+// NewCircuitBreaker creates a circuit breaker for an external dependency.
+// isSuccessful is optional and decides which errors count as failures; by
+// default every error does. onStateChange is optional and is called whenever
+// the breaker changes state.
+func NewCircuitBreaker(name string, isSuccessful func(err error) bool, onStateChange func(name string, from, to gobreaker.State)) *gobreaker.CircuitBreaker {
 	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
 		Name:        name,
 		MaxRequests: 3,
@@ -16,5 +20,7 @@ func NewCircuitBreaker(name string) *gobreaker.CircuitBreaker {
 			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
 			return counts.Requests >= 3 && failureRatio >= 0.6
 		},
+		IsSuccessful:  isSuccessful,
+		OnStateChange: onStateChange,
 	})
 }
@@ -0,0 +1,35 @@
+package delivery
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	deliveryJobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "otp_delivery_jobs_total",
+		Help: "Total number of OTP delivery jobs by outcome (queued, sent, retried, dead_lettered)",
+	}, []string{"channel", "result"})
+
+	deliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "otp_delivery_latency_seconds",
+		Help:    "Time from queueing an OTP to the provider accepting it",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"channel"})
+
+	deliveryFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "otp_delivery_fallbacks_total",
+		Help: "Total number of codes resent through another channel after a failed or undelivered message",
+	}, []string{"channel"})
+
+	smsReceipts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_delivery_receipts_total",
+		Help: "Total number of final SMS delivery receipts (delivered or undelivered) per country and carrier",
+	}, []string{"provider", "country", "carrier", "status"})
+
+	smsDeliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sms_delivery_latency_seconds",
+		Help:    "Time from the provider accepting an SMS to its delivered receipt",
+		Buckets: []float64{1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"provider", "country", "carrier"})
+)
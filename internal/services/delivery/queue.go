@@ -0,0 +1,363 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/services/email"
+	"github.com/lmousom/passless-auth/internal/services/messaging"
+	"github.com/lmousom/passless-auth/internal/services/sms"
+	"github.com/lmousom/passless-auth/internal/services/voice"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/otpdata"
+)
+
+const (
+	// readBlock is how long a worker waits for new jobs before checking for shutdown
+	readBlock = 5 * time.Second
+	// redisTimeout bounds the Redis calls made around a send
+	redisTimeout = 5 * time.Second
+	// scheduleInterval is how often due retries are queued again
+	scheduleInterval = time.Second
+	// claimInterval is how often jobs abandoned by a crashed worker are reclaimed
+	claimInterval = 30 * time.Second
+	// batchSize is the number of jobs read, promoted or claimed at once
+	batchSize = 10
+)
+
+// Queue delivers OTP codes asynchronously. Jobs are stored in a Redis stream
+// and processed by a pool of workers, which retry failed sends with
+// exponential backoff and move jobs that cannot be delivered to a
+// dead-letter list.
+type Queue struct {
+	config       *config.Config
+	redisClient  *storage.RedisClient
+	smsProviders *sms.Pool
+	smsGuard     *sms.Guard
+	magicLinks   *sms.Templates
+	caller       voice.Caller
+	messengers   map[string]messaging.Messenger
+	emailSender  email.Sender
+	consumer     string
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewQueue creates a Queue. caller and emailSender may be nil when the voice
+// and email channels are disabled; messengers holds the enabled messaging
+// app channels. smsGuard limits the codes the queue resends on its own.
+// magicLinks renders magic link messages and may be nil when magic links are
+// disabled.
+func NewQueue(cfg *config.Config, redisClient *storage.RedisClient, smsProviders *sms.Pool, smsGuard *sms.Guard, magicLinks *sms.Templates, caller voice.Caller, messengers map[string]messaging.Messenger, emailSender email.Sender) *Queue {
+	hostname, _ := os.Hostname()
+	return &Queue{
+		config:       cfg,
+		redisClient:  redisClient,
+		smsProviders: smsProviders,
+		smsGuard:     smsGuard,
+		magicLinks:   magicLinks,
+		caller:       caller,
+		messengers:   messengers,
+		emailSender:  emailSender,
+		consumer:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
+
+// Start launches the workers and the retry scheduler. Outside development it
+// refuses to start without an encryption key, as queued codes and magic
+// links would otherwise be stored in Redis in plaintext.
+func (q *Queue) Start() error {
+	if !config.EncryptionEnabled() {
+		if q.config.Server.Environment != "development" {
+			return fmt.Errorf("an encryption key (%s or %s) is required to queue OTP codes", config.EncryptionKeyEnv, config.EncryptionKeysEnv)
+		}
+		log.Printf("Warning: no %s is set, queued OTP codes are stored in Redis in plaintext", config.EncryptionKeyEnv)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := q.redisClient.EnsureDeliveryGroup(ctx); err != nil {
+		cancel()
+		return fmt.Errorf("failed to create delivery queue: %w", err)
+	}
+	q.cancel = cancel
+
+	for i := 0; i < q.config.Delivery.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx, fmt.Sprintf("%s-%d", q.consumer, i))
+	}
+	q.wg.Add(1)
+	go q.schedule(ctx)
+	return nil
+}
+
+// Stop waits for in-flight sends to finish and stops the workers. Jobs still
+// queued are picked up when a worker next starts.
+func (q *Queue) Stop() {
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.wg.Wait()
+}
+
+// Enqueue queues job for delivery and returns its delivery ID
+func (q *Queue) Enqueue(ctx context.Context, job *storage.DeliveryJob) (string, error) {
+	job.CreatedAt = time.Now().UTC()
+	if err := q.redisClient.EnqueueDelivery(ctx, job, q.config.Delivery.StatusTTL); err != nil {
+		return "", err
+	}
+	deliveryJobs.WithLabelValues(job.Channel, "queued").Inc()
+	return job.ID, nil
+}
+
+// Status returns the status of a delivery, or nil if it is unknown
+func (q *Queue) Status(ctx context.Context, id string) (*storage.DeliveryRecord, error) {
+	return q.redisClient.GetDeliveryStatus(ctx, id)
+}
+
+// work processes new jobs until ctx is cancelled
+func (q *Queue) work(ctx context.Context, consumer string) {
+	defer q.wg.Done()
+
+	for ctx.Err() == nil {
+		messages, err := q.redisClient.ReadDeliveries(ctx, consumer, batchSize, readBlock)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to read delivery queue: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, msg := range messages {
+			q.process(msg)
+		}
+	}
+}
+
+// schedule requeues due retries and reclaims jobs abandoned by other workers
+func (q *Queue) schedule(ctx context.Context) {
+	defer q.wg.Done()
+
+	promote := time.NewTicker(scheduleInterval)
+	defer promote.Stop()
+	claim := time.NewTicker(claimInterval)
+	defer claim.Stop()
+
+	// A job idle for longer than a full send attempt belongs to a dead worker
+	minIdle := q.config.Delivery.SendTimeout + 2*redisTimeout
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-promote.C:
+			if _, err := q.redisClient.PromoteDueRetries(ctx, batchSize); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to requeue delivery retries: %v", err)
+			}
+		case <-claim.C:
+			messages, err := q.redisClient.ClaimStaleDeliveries(ctx, q.consumer, minIdle, batchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Failed to claim stale deliveries: %v", err)
+				}
+				continue
+			}
+			for _, msg := range messages {
+				q.process(msg)
+			}
+		}
+	}
+}
+
+// process makes one delivery attempt and records its outcome. It runs on a
+// fresh context so that shutdown does not interrupt a send half way.
+func (q *Queue) process(msg storage.DeliveryMessage) {
+	job := msg.Job
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.Delivery.SendTimeout+2*redisTimeout)
+	defer cancel()
+
+	// A job that cannot be decoded would be redelivered forever
+	if msg.Err != nil {
+		log.Printf("Failed to decode delivery %s (stream entry %s): %v", job.ID, msg.StreamID, msg.Err)
+		if job.ID == "" {
+			if err := q.redisClient.AckDelivery(ctx, msg.StreamID); err != nil {
+				log.Printf("Failed to drop stream entry %s: %v", msg.StreamID, err)
+			}
+			return
+		}
+		q.deadLetter(ctx, msg, "job could not be decoded")
+		return
+	}
+
+	if time.Now().After(job.ExpiresAt) {
+		q.deadLetter(ctx, msg, "code expired before it could be delivered")
+		return
+	}
+
+	job.Attempt++
+	if err := q.redisClient.SetDeliveryStatus(ctx, job.ID, storage.DeliverySending, job.Attempt, "", time.Time{}); err != nil {
+		log.Printf("Failed to update delivery %s: %v", job.ID, err)
+	}
+
+	sendCtx, cancelSend := context.WithTimeout(ctx, q.config.Delivery.SendTimeout)
+	sendCtx, receipt := sms.WithReceipt(sendCtx)
+	err := q.send(sendCtx, job)
+	cancelSend()
+
+	if err == nil {
+		if receipt.MessageID != "" {
+			// Keep the code for a fallback resend until the receipt arrives
+			keepJob := q.canFallBack(job)
+			if err := q.redisClient.TrackSMSMessage(ctx, receipt.Provider, receipt.MessageID, job, q.config.Delivery.StatusTTL, keepJob); err != nil {
+				log.Printf("Failed to track delivery %s: %v", job.ID, err)
+			}
+		}
+		if err := q.redisClient.AckDelivery(ctx, msg.StreamID); err != nil {
+			log.Printf("Failed to acknowledge delivery %s: %v", job.ID, err)
+		}
+		if err := q.redisClient.SetDeliveryStatus(ctx, job.ID, storage.DeliverySent, job.Attempt, "", time.Time{}); err != nil {
+			log.Printf("Failed to update delivery %s: %v", job.ID, err)
+		}
+		deliveryJobs.WithLabelValues(job.Channel, "sent").Inc()
+		deliveryLatency.WithLabelValues(job.Channel).Observe(time.Since(job.CreatedAt).Seconds())
+		return
+	}
+
+	log.Printf("Delivery %s attempt %d failed: %v", job.ID, job.Attempt, err)
+
+	// The recipient may not use the messaging app at all, so rather than
+	// retrying fall back to SMS straight away
+	if job.Channel == otpdata.ChannelWhatsApp || job.Channel == otpdata.ChannelTelegram {
+		q.deadLetter(ctx, msg, err.Error())
+		if err := q.resend(ctx, job, otpdata.ChannelSMS, job.Provider); err != nil {
+			log.Printf("Failed to resend delivery %s by SMS: %v", job.ID, err)
+		}
+		return
+	}
+
+	// Retrying a recipient the provider refused would only be refused again
+	next := time.Now().Add(q.backoff(job.Attempt))
+	if job.Attempt >= q.config.Delivery.MaxAttempts || next.After(job.ExpiresAt) || sms.IsRecipientError(err) {
+		q.deadLetter(ctx, msg, err.Error())
+		// The SMS could not be sent at all; try the fallback channel while the code is valid
+		if job.Channel == otpdata.ChannelSMS && q.canFallBack(job) {
+			if err := q.fallback(ctx, job); err != nil {
+				log.Printf("Failed to resend delivery %s through the fallback channel: %v", job.ID, err)
+			}
+		}
+		return
+	}
+
+	if err := q.redisClient.ScheduleDeliveryRetry(ctx, msg.StreamID, job, next); err != nil {
+		// The job stays pending and is reclaimed later
+		log.Printf("Failed to schedule retry of delivery %s: %v", job.ID, err)
+		return
+	}
+	if err := q.redisClient.SetDeliveryStatus(ctx, job.ID, storage.DeliveryRetrying, job.Attempt, err.Error(), next); err != nil {
+		log.Printf("Failed to update delivery %s: %v", job.ID, err)
+	}
+	deliveryJobs.WithLabelValues(job.Channel, "retried").Inc()
+}
+
+// canFallBack reports whether job may be resent through the fallback channel
+// if it is not delivered. Magic links are not resent, as the fallback may
+// be a voice call.
+func (q *Queue) canFallBack(job *storage.DeliveryJob) bool {
+	return q.config.Delivery.Fallback.Enabled && !job.Fallback && job.Link == ""
+}
+
+// send delivers the job's code or magic link through its channel
+func (q *Queue) send(ctx context.Context, job *storage.DeliveryJob) error {
+	if job.Link != "" {
+		return q.sendLink(ctx, job)
+	}
+
+	switch job.Channel {
+	case otpdata.ChannelEmail:
+		if q.emailSender == nil {
+			return errors.New("email delivery is not enabled")
+		}
+		return q.emailSender.SendOTP(ctx, job.Recipient, job.Code)
+	case otpdata.ChannelVoice:
+		if q.caller == nil {
+			return errors.New("voice delivery is not enabled")
+		}
+		return q.caller.CallOTP(ctx, job.Recipient, job.Code)
+	case otpdata.ChannelWhatsApp, otpdata.ChannelTelegram:
+		messenger, ok := q.messengers[job.Channel]
+		if !ok {
+			return fmt.Errorf("%s delivery is not enabled", job.Channel)
+		}
+		return messenger.SendOTP(ctx, job.Recipient, job.Code, job.Expiry)
+	default:
+		provider, err := q.smsProviders.Get(job.Provider)
+		if err != nil {
+			return err
+		}
+		ctx = sms.WithOTPExpiry(ctx, job.Expiry)
+		ctx = sms.WithLocale(ctx, job.Locale)
+		ctx = sms.WithClientApp(ctx, job.ClientApp)
+		return provider.SendOTP(ctx, job.Recipient, job.Code)
+	}
+}
+
+// sendLink delivers the job's magic link by email or SMS
+func (q *Queue) sendLink(ctx context.Context, job *storage.DeliveryJob) error {
+	switch job.Channel {
+	case otpdata.ChannelEmail:
+		if q.emailSender == nil {
+			return errors.New("email delivery is not enabled")
+		}
+		return q.emailSender.SendMagicLink(ctx, job.Recipient, job.Link)
+	case otpdata.ChannelSMS:
+		if q.magicLinks == nil {
+			return errors.New("magic links are not enabled")
+		}
+		msg, err := q.magicLinks.RenderLink(job.Locale, job.Link, job.Expiry)
+		if err != nil {
+			return err
+		}
+		provider, err := q.smsProviders.Get(job.Provider)
+		if err != nil {
+			return err
+		}
+		return provider.SendMessage(ctx, job.Recipient, msg.Body)
+	default:
+		return fmt.Errorf("magic links cannot be sent by %s", job.Channel)
+	}
+}
+
+// deadLetter gives up on a job
+func (q *Queue) deadLetter(ctx context.Context, msg storage.DeliveryMessage, reason string) {
+	job := msg.Job
+	log.Printf("Delivery %s failed permanently after %d attempt(s): %s", job.ID, job.Attempt, reason)
+
+	if err := q.redisClient.DeadLetterDelivery(ctx, msg.StreamID, job, reason, q.config.Delivery.DeadLetterSize); err != nil {
+		log.Printf("Failed to dead-letter delivery %s: %v", job.ID, err)
+	}
+	if err := q.redisClient.SetDeliveryStatus(ctx, job.ID, storage.DeliveryFailed, job.Attempt, reason, time.Time{}); err != nil {
+		log.Printf("Failed to update delivery %s: %v", job.ID, err)
+	}
+	deliveryJobs.WithLabelValues(job.Channel, "dead_lettered").Inc()
+}
+
+// backoff returns the delay before retry number attempt: the initial backoff
+// doubled for each previous attempt, capped, with jitter so that retries
+// after a provider outage do not arrive all at once
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.config.Delivery.InitialBackoff
+	for i := 1; i < attempt && delay < q.config.Delivery.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.config.Delivery.MaxBackoff {
+		delay = q.config.Delivery.MaxBackoff
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
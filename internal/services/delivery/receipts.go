@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/services/sms"
+	"github.com/lmousom/passless-auth/internal/storage"
+	"github.com/lmousom/passless-auth/models/otpdata"
+)
+
+// minFallbackValidity is the least time a code must have left to be worth resending
+const minFallbackValidity = 30 * time.Second
+
+// HandleReport records an SMS delivery receipt and, when the message was
+// reported undelivered, resends the code through the fallback channel
+func (q *Queue) HandleReport(ctx context.Context, report *sms.StatusReport) error {
+	msg, final, err := q.redisClient.UpdateSMSMessage(ctx, report.Provider, report.MessageID,
+		string(report.Status), report.Status.Final(), report.Carrier, report.ErrorCode)
+	if err != nil {
+		return err
+	}
+	if msg == nil {
+		// Sent before receipts were tracked, or tracked longer ago than delivery.status_ttl
+		log.Printf("Ignoring %s receipt for unknown message %s", report.Provider, report.MessageID)
+		return nil
+	}
+	if !final {
+		return nil
+	}
+
+	country, carrier := labelValue(msg.Region), labelValue(msg.Carrier)
+	smsReceipts.WithLabelValues(report.Provider, country, carrier, string(report.Status)).Inc()
+	if report.Status == sms.ReportDelivered {
+		smsDeliveryLatency.WithLabelValues(report.Provider, country, carrier).Observe(msg.UpdatedAt.Sub(msg.SentAt).Seconds())
+	} else {
+		log.Printf("Delivery %s reported %s by %s (error code %q)", msg.DeliveryID, report.RawStatus, report.Provider, report.ErrorCode)
+	}
+
+	// The kept job is no longer needed once the message reached the handset
+	job, err := q.redisClient.TakeDeliveryJob(ctx, msg.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if job == nil || report.Status == sms.ReportDelivered {
+		return nil
+	}
+	return q.fallback(ctx, job)
+}
+
+// fallback queues job again through the configured fallback channel
+func (q *Queue) fallback(ctx context.Context, job *storage.DeliveryJob) error {
+	fallback := q.config.Delivery.Fallback
+	return q.resend(ctx, job, fallback.Channel, fallback.SMSProvider)
+}
+
+// resend queues job's code again through channel. smsProvider selects the
+// provider when channel is sms. Resends count towards the SMS send limits
+// like any other code, so a failing destination cannot be used to send more
+// messages than the guard allows.
+func (q *Queue) resend(ctx context.Context, job *storage.DeliveryJob, channel, smsProvider string) error {
+	remaining := time.Until(job.ExpiresAt)
+	if remaining < minFallbackValidity {
+		return nil
+	}
+
+	if channel != otpdata.ChannelEmail {
+		if err := q.smsGuard.AllowResend(ctx, job.Recipient); err != nil {
+			log.Printf("Delivery %s not resent through %s: %v", job.ID, channel, err)
+			return nil
+		}
+	}
+
+	resend := &storage.DeliveryJob{
+		Channel:   channel,
+		Recipient: job.Recipient,
+		Code:      job.Code,
+		Locale:    job.Locale,
+		Region:    job.Region,
+		// Quote the time the code has left rather than its full lifetime
+		Expiry:    remaining,
+		ExpiresAt: job.ExpiresAt,
+		Fallback:  true,
+	}
+	if channel == otpdata.ChannelSMS {
+		resend.ClientApp = job.ClientApp
+		resend.Provider = smsProvider
+	}
+
+	id, err := q.Enqueue(ctx, resend)
+	if err != nil {
+		return err
+	}
+
+	deliveryFallbacks.WithLabelValues(channel).Inc()
+	log.Printf("Delivery %s resent through %s as %s", job.ID, channel, id)
+	return q.redisClient.SetDeliveryFallback(ctx, job.ID, id)
+}
+
+// labelValue keeps metric labels non-empty
+func labelValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
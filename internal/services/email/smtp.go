@@ -0,0 +1,309 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const defaultSMTPTimeout = 10 * time.Second
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// Sender delivers OTP codes and sign-in links by email
+type Sender interface {
+	SendOTP(ctx context.Context, to, otp string) error
+	SendMagicLink(ctx context.Context, to, link string) error
+}
+
+// templateData is passed to the email templates
+type templateData struct {
+	Code          string
+	Link          string
+	Email         string
+	ExpiryMinutes int
+}
+
+// emailTemplate is a pair of text and HTML bodies rendered into one message
+type emailTemplate struct {
+	subject string
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// SMTPSender sends OTP emails through an SMTP server
+type SMTPSender struct {
+	host      string
+	port      string
+	username  string
+	password  string
+	tlsMode   string
+	from      mail.Address
+	otp       *emailTemplate
+	magicLink *emailTemplate
+	otpExpiry time.Duration
+	// rootCAs verifies the server's certificate; nil uses the system roots
+	rootCAs *x509.CertPool
+}
+
+func NewSMTPSender(cfg *config.Config) (*SMTPSender, error) {
+	if cfg.Email.SMTP.Host == "" {
+		return nil, fmt.Errorf("email.smtp.host is required when email is enabled")
+	}
+	if cfg.Email.From == "" {
+		return nil, fmt.Errorf("email.from is required when email is enabled")
+	}
+
+	username, err := cfg.Email.SMTP.Username.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SMTP username: %w", err)
+	}
+
+	password, err := cfg.Email.SMTP.Password.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SMTP password: %w", err)
+	}
+
+	otp, err := loadEmailTemplate(cfg.Email.Subject, cfg.Email.Templates.HTML, cfg.Email.Templates.Text, "otp")
+	if err != nil {
+		return nil, err
+	}
+
+	magicLink, err := loadEmailTemplate(cfg.Email.MagicLinkSubject, cfg.Email.Templates.MagicLinkHTML, cfg.Email.Templates.MagicLinkText, "magic_link")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMTPSender{
+		host:      cfg.Email.SMTP.Host,
+		port:      cfg.Email.SMTP.Port,
+		username:  username,
+		password:  password,
+		tlsMode:   cfg.Email.SMTP.TLS,
+		from:      mail.Address{Name: cfg.Email.FromName, Address: cfg.Email.From},
+		otp:       otp,
+		magicLink: magicLink,
+		otpExpiry: cfg.Security.OTPExpiry,
+	}, nil
+}
+
+// loadEmailTemplate parses the HTML and text templates for a message, using
+// the embedded templates/<name>.html and templates/<name>.txt when no override is configured
+func loadEmailTemplate(subject, htmlPath, textPath, name string) (*emailTemplate, error) {
+	htmlSrc, err := loadTemplate(htmlPath, "templates/"+name+".html")
+	if err != nil {
+		return nil, err
+	}
+	html, err := htmltemplate.New(name + ".html").Parse(htmlSrc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML email template %s: %w", name, err)
+	}
+
+	textSrc, err := loadTemplate(textPath, "templates/"+name+".txt")
+	if err != nil {
+		return nil, err
+	}
+	text, err := texttemplate.New(name + ".txt").Parse(textSrc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse text email template %s: %w", name, err)
+	}
+
+	return &emailTemplate{
+		subject: subject,
+		html:    html,
+		text:    text,
+	}, nil
+}
+
+// loadTemplate reads a template override from path, falling back to the embedded default
+func loadTemplate(path, fallback string) (string, error) {
+	if path == "" {
+		b, err := defaultTemplates.ReadFile(fallback)
+		if err != nil {
+			return "", fmt.Errorf("failed to read default email template: %w", err)
+		}
+		return string(b), nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read email template %s: %w", path, err)
+	}
+	return string(b), nil
+}
+
+func (s *SMTPSender) SendOTP(ctx context.Context, to, otp string) error {
+	return s.send(ctx, to, s.otp, templateData{
+		Code:          otp,
+		Email:         to,
+		ExpiryMinutes: int(s.otpExpiry.Minutes()),
+	})
+}
+
+func (s *SMTPSender) SendMagicLink(ctx context.Context, to, link string) error {
+	return s.send(ctx, to, s.magicLink, templateData{
+		Link:          link,
+		Email:         to,
+		ExpiryMinutes: int(s.otpExpiry.Minutes()),
+	})
+}
+
+// send renders tmpl with data and delivers it to a single recipient
+func (s *SMTPSender) send(ctx context.Context, to string, tmpl *emailTemplate, data templateData) error {
+	msg, err := s.buildMessage(to, tmpl, data)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if s.tlsMode == "starttls" {
+		if err := client.StartTLS(s.tlsConfig()); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return fmt.Errorf("failed to authenticate with SMTP server: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("failed to set recipient: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the SMTP server, using implicit TLS when configured
+func (s *SMTPSender) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.host, s.port)
+	dialer := &net.Dialer{Timeout: defaultSMTPTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSMTPTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if s.tlsMode == "tls" {
+		conn = tls.Client(conn, s.tlsConfig())
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (s *SMTPSender) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: s.host, RootCAs: s.rootCAs}
+}
+
+// buildMessage renders a multipart/alternative message with text and HTML bodies
+func (s *SMTPSender) buildMessage(to string, tmpl *emailTemplate, data templateData) ([]byte, error) {
+	var textBody, htmlBody bytes.Buffer
+	if err := tmpl.text.Execute(&textBody, data); err != nil {
+		return nil, fmt.Errorf("failed to render text email: %w", err)
+	}
+	if err := tmpl.html.Execute(&htmlBody, data); err != nil {
+		return nil, fmt.Errorf("failed to render HTML email: %w", err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	var headers bytes.Buffer
+	fmt.Fprintf(&headers, "From: %s\r\n", s.from.String())
+	fmt.Fprintf(&headers, "To: %s\r\n", (&mail.Address{Address: to}).String())
+	fmt.Fprintf(&headers, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", tmpl.subject))
+	fmt.Fprintf(&headers, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&headers, "Message-ID: %s\r\n", messageID(s.from.Address))
+	fmt.Fprintf(&headers, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&headers, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	if err := writePart(mw, "text/plain; charset=utf-8", textBody.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writePart(mw, "text/html; charset=utf-8", htmlBody.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize email: %w", err)
+	}
+
+	return append(headers.Bytes(), buf.Bytes()...), nil
+}
+
+func writePart(mw *multipart.Writer, contentType string, body []byte) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create email part: %w", err)
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write(body); err != nil {
+		return fmt.Errorf("failed to write email part: %w", err)
+	}
+	return qp.Close()
+}
+
+// messageID generates a unique Message-ID using the sender's domain
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%x@%s>", b, domain)
+}
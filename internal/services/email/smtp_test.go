@@ -0,0 +1,265 @@
+package email
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// smtpMessage is a message received by the test SMTP server
+type smtpMessage struct {
+	auth string
+	from string
+	to   []string
+	tls  bool
+	data string
+}
+
+// smtpServer is an in-process stand-in for an SMTP server. It speaks enough
+// of the protocol for net/smtp: EHLO, STARTTLS, AUTH, MAIL, RCPT and DATA.
+type smtpServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	// implicit serves TLS from the first byte instead of offering STARTTLS
+	implicit bool
+	messages chan smtpMessage
+}
+
+// newSMTPServer starts a server for tlsMode, one of none, starttls or tls,
+// and returns it with the pool that trusts its certificate
+func newSMTPServer(t *testing.T, tlsMode string) (*smtpServer, *x509.CertPool) {
+	t.Helper()
+	cert, pool := newTestCertificate(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	srv := &smtpServer{listener: listener, messages: make(chan smtpMessage, 1)}
+	switch tlsMode {
+	case "tls":
+		srv.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+		srv.implicit = true
+	case "starttls":
+		srv.tls = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv, pool
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var msg smtpMessage
+	if s.implicit {
+		conn = tls.Server(conn, s.tls)
+		msg.tls = true
+	}
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP test")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			if s.tls != nil && !msg.tls {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			conn = tls.Server(conn, s.tls)
+			tp = textproto.NewConn(conn)
+			msg.tls = true
+		case "AUTH":
+			msg.auth = arg
+			tp.PrintfLine("235 Authentication successful")
+		case "MAIL":
+			msg.from = arg
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			tp.PrintfLine("250 OK")
+			s.messages <- msg
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// newTestCertificate creates a self-signed certificate for 127.0.0.1
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func newTestSender(t *testing.T, addr, tlsMode string, rootCAs *x509.CertPool) *SMTPSender {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Email.From = "auth@example.com"
+	cfg.Email.FromName = "Acme"
+	cfg.Email.Subject = "Votre code de vérification"
+	cfg.Email.MagicLinkSubject = "Your sign-in link"
+	cfg.Email.SMTP.Host, cfg.Email.SMTP.Port, _ = strings.Cut(addr, ":")
+	cfg.Email.SMTP.TLS = tlsMode
+	cfg.Email.SMTP.Username = config.EncryptedValue{Value: "mailer"}
+	cfg.Email.SMTP.Password = config.EncryptedValue{Value: "secret"}
+	cfg.Security.OTPExpiry = 5 * time.Minute
+
+	sender, err := NewSMTPSender(cfg)
+	if err != nil {
+		t.Fatalf("NewSMTPSender() error = %v", err)
+	}
+	sender.rootCAs = rootCAs
+	return sender
+}
+
+func TestSMTPSenderSendOTP(t *testing.T) {
+	for _, tlsMode := range []string{"none", "starttls", "tls"} {
+		t.Run(tlsMode, func(t *testing.T) {
+			srv, pool := newSMTPServer(t, tlsMode)
+			sender := newTestSender(t, srv.listener.Addr().String(), tlsMode, pool)
+
+			if err := sender.SendOTP(context.Background(), "user@example.com", "493817"); err != nil {
+				t.Fatalf("SendOTP() error = %v", err)
+			}
+			msg := <-srv.messages
+
+			if msg.tls != (tlsMode != "none") {
+				t.Errorf("TLS = %v, want %v", msg.tls, tlsMode != "none")
+			}
+			if want := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00mailer\x00secret")); msg.auth != want {
+				t.Errorf("AUTH = %q, want %q", msg.auth, want)
+			}
+			if msg.from != "FROM:<auth@example.com>" {
+				t.Errorf("MAIL = %q, want FROM:<auth@example.com>", msg.from)
+			}
+			if len(msg.to) != 1 || msg.to[0] != "TO:<user@example.com>" {
+				t.Errorf("RCPT = %q, want [TO:<user@example.com>]", msg.to)
+			}
+
+			assertOTPMessage(t, msg.data, "493817", "Votre code de vérification")
+		})
+	}
+}
+
+// assertOTPMessage checks the headers of data and that both parts of its
+// multipart/alternative body contain code
+func assertOTPMessage(t *testing.T, data, code, subject string) {
+	t.Helper()
+	m, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+
+	raw := m.Header.Get("Subject")
+	if !strings.HasPrefix(raw, "=?utf-8?q?") {
+		t.Errorf("Subject = %q, want a Q-encoded word", raw)
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(raw)
+	if err != nil || decoded != subject {
+		t.Errorf("decoded Subject = %q, %v, want %q", decoded, err, subject)
+	}
+	if from := m.Header.Get("From"); from != `"Acme" <auth@example.com>` {
+		t.Errorf("From = %q", from)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v, want multipart/alternative", mediaType, err)
+	}
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		contentType := part.Header.Get("Content-Type")
+		types = append(types, contentType)
+		if !strings.Contains(string(body), code) {
+			t.Errorf("%s part does not contain the code: %s", contentType, body)
+		}
+	}
+	if len(types) != 2 || types[0] != "text/plain; charset=utf-8" || types[1] != "text/html; charset=utf-8" {
+		t.Errorf("parts = %q, want text and HTML", types)
+	}
+}
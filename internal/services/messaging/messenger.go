@@ -0,0 +1,54 @@
+package messaging
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/models/otpdata"
+)
+
+// defaultHTTPTimeout bounds every outbound call to a messaging API
+const defaultHTTPTimeout = 10 * time.Second
+
+// Messenger delivers OTP codes through a messaging app
+type Messenger interface {
+	// Name returns the provider's name
+	Name() string
+	// SendOTP sends otp, valid for expiry, to the app account of phoneNumber
+	SendOTP(ctx context.Context, phoneNumber, otp string, expiry time.Duration) error
+}
+
+// NewMessengers creates the enabled messaging channels, keyed by channel name
+func NewMessengers(cfg *config.Config) (map[string]Messenger, error) {
+	messengers := make(map[string]Messenger)
+
+	if cfg.Messaging.WhatsApp.Enabled {
+		var whatsapp Messenger
+		var err error
+		if cfg.Messaging.WhatsApp.Provider == "meta" {
+			whatsapp, err = NewMetaWhatsApp(cfg)
+		} else {
+			whatsapp, err = NewTwilioWhatsApp(cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		messengers[otpdata.ChannelWhatsApp] = whatsapp
+	}
+
+	if cfg.Messaging.Telegram.Enabled {
+		telegram, err := NewTelegramGateway(cfg)
+		if err != nil {
+			return nil, err
+		}
+		messengers[otpdata.ChannelTelegram] = telegram
+	}
+
+	return messengers, nil
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: defaultHTTPTimeout}
+}
@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// TwilioWhatsApp sends an approved WhatsApp Content template through Twilio
+type TwilioWhatsApp struct {
+	client     *twilio.RestClient
+	fromNumber string
+	contentSID string
+}
+
+// NewTwilioWhatsApp creates a TwilioWhatsApp with the Twilio account
+// configured under sms
+func NewTwilioWhatsApp(cfg *config.Config) (*TwilioWhatsApp, error) {
+	whatsapp := cfg.Messaging.WhatsApp
+	if whatsapp.FromNumber == "" || whatsapp.ContentSID == "" {
+		return nil, fmt.Errorf("WhatsApp through Twilio requires messaging.whatsapp.from_number and content_sid")
+	}
+
+	accountSID, err := cfg.GetDecryptedSMSAccountSID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account SID: %w", err)
+	}
+
+	authToken, err := cfg.GetDecryptedSMSAuthToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	}
+
+	return &TwilioWhatsApp{
+		client: twilio.NewRestClientWithParams(twilio.ClientParams{
+			Username: accountSID,
+			Password: authToken,
+		}),
+		fromNumber: whatsapp.FromNumber,
+		contentSID: whatsapp.ContentSID,
+	}, nil
+}
+
+func (w *TwilioWhatsApp) Name() string {
+	return "twilio"
+}
+
+func (w *TwilioWhatsApp) SendOTP(ctx context.Context, phoneNumber, otp string, expiry time.Duration) error {
+	variables, err := json.Marshal(map[string]string{"1": otp})
+	if err != nil {
+		return err
+	}
+
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo("whatsapp:" + phoneNumber)
+	params.SetFrom("whatsapp:" + w.fromNumber)
+	params.SetContentSid(w.contentSID)
+	params.SetContentVariables(string(variables))
+
+	if _, err := w.client.Api.CreateMessage(params); err != nil {
+		return fmt.Errorf("failed to send WhatsApp message: %w", err)
+	}
+	return nil
+}
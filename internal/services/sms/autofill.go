@@ -0,0 +1,32 @@
+package sms
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Autofill describes the lines appended to an OTP message so that a client
+// app can read the code without the user typing it
+type Autofill struct {
+	// WebOTPDomain adds the origin-bound "@domain #code" line read by the
+	// WebOTP API. It must be the last line of the message.
+	WebOTPDomain string
+	// AndroidAppHash adds the 11-character app hash the Android SMS Retriever
+	// API uses to route the message to the app
+	AndroidAppHash string
+}
+
+// apply returns body with the autofill lines for code appended
+func (a Autofill) apply(body, code string) string {
+	var lines []string
+	if a.AndroidAppHash != "" {
+		lines = append(lines, a.AndroidAppHash)
+	}
+	if a.WebOTPDomain != "" {
+		lines = append(lines, fmt.Sprintf("@%s #%s", a.WebOTPDomain, code))
+	}
+	if len(lines) == 0 {
+		return body
+	}
+	return body + "\n\n" + strings.Join(lines, "\n")
+}
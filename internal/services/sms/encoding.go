@@ -0,0 +1,72 @@
+package sms
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// Encoding is the character set an SMS is transmitted in
+type Encoding string
+
+const (
+	EncodingGSM7 Encoding = "GSM-7"
+	EncodingUCS2 Encoding = "UCS-2"
+)
+
+// Per-segment limits. Multi-part messages lose room to the concatenation header.
+const (
+	gsm7SingleSegment = 160
+	gsm7MultiSegment  = 153
+	ucs2SingleSegment = 70
+	ucs2MultiSegment  = 67
+)
+
+// gsm7Basic is the GSM 03.38 default alphabet
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension characters are sent as an escape plus a character, so they count twice
+const gsm7Extension = "\f^{}\\[~]|€"
+
+// Length describes how a message body will be transmitted
+type Length struct {
+	Encoding Encoding
+	// Units is the number of GSM-7 septets or UCS-2 code units
+	Units    int
+	Segments int
+}
+
+// MessageLength returns the encoding, size and segment count of body
+func MessageLength(body string) Length {
+	units, ok := gsm7Units(body)
+	if ok {
+		return Length{Encoding: EncodingGSM7, Units: units, Segments: segments(units, gsm7SingleSegment, gsm7MultiSegment)}
+	}
+
+	units = len(utf16.Encode([]rune(body)))
+	return Length{Encoding: EncodingUCS2, Units: units, Segments: segments(units, ucs2SingleSegment, ucs2MultiSegment)}
+}
+
+// gsm7Units counts the septets needed for body, reporting false if it
+// contains a character outside the GSM-7 alphabet
+func gsm7Units(body string) (int, bool) {
+	units := 0
+	for _, r := range body {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			units++
+		case strings.ContainsRune(gsm7Extension, r):
+			units += 2
+		default:
+			return 0, false
+		}
+	}
+	return units, true
+}
+
+func segments(units, single, multi int) int {
+	if units <= single {
+		return 1
+	}
+	return (units + multi - 1) / multi
+}
@@ -0,0 +1,61 @@
+package sms
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// SendError is an error response from a provider's API
+type SendError struct {
+	Provider   string
+	StatusCode int
+	Code       string
+	Message    string
+	// Recipient is set when the provider refused the recipient or the
+	// message itself, e.g. an invalid or opted out number, which any other
+	// provider would refuse too
+	Recipient bool
+}
+
+func (e *SendError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("failed to send SMS: %s error %s (status %d): %s", e.Provider, e.Code, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("failed to send SMS: %s returned status %d", e.Provider, e.StatusCode)
+}
+
+// Temporary reports whether the provider failed on its side or asked to be
+// called less often, so the send may succeed later or elsewhere
+func (e *SendError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsRecipientError reports whether err is a provider refusing the recipient
+// or message, in which case retrying or failing over is pointless
+func IsRecipientError(err error) bool {
+	var sendErr *SendError
+	return errors.As(err, &sendErr) && sendErr.Recipient
+}
+
+// Unauthorized reports whether the provider refused our credentials, e.g.
+// because they were revoked or the account was suspended
+func (e *SendError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// isProviderFailure reports whether err says something is wrong with the
+// provider: a transport error, a 5xx, a 429, or a 401 or 403 refusing our
+// credentials, which fails every send until an operator fixes them. Errors
+// the provider returned for the request itself, such as an invalid
+// number, do not.
+func isProviderFailure(err error) bool {
+	if err == nil || errors.Is(err, errSendAborted) {
+		return false
+	}
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.Temporary() || sendErr.Unauthorized()
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package sms
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/errors"
+	"github.com/lmousom/passless-auth/internal/phone"
+	"github.com/lmousom/passless-auth/internal/storage"
+)
+
+// unknownCountry groups numbers whose calling code cannot be determined
+const unknownCountry = "unknown"
+
+// Guard protects the SMS budget against abuse such as SMS pumping (toll
+// fraud). It enforces per-number resend cooldowns, daily caps per number and
+// per country, a global per-minute budget, and blocks high-risk and watched
+// destination prefixes whose traffic spikes.
+type Guard struct {
+	config      *config.Config
+	redisClient *storage.RedisClient
+}
+
+func NewGuard(cfg *config.Config, redisClient *storage.RedisClient) *Guard {
+	return &Guard{
+		config:      cfg,
+		redisClient: redisClient,
+	}
+}
+
+// Allow records an attempted send to phoneNumber and returns an AppError if
+// it must be refused
+func (g *Guard) Allow(ctx context.Context, phoneNumber string) error {
+	return g.allow(ctx, phoneNumber, g.config.Security.SMSThrottle.ResendCooldown)
+}
+
+// AllowResend is Allow for a code the service resends on its own, such as
+// a fallback after a failed delivery. It skips the resend cooldown, which
+// paces users rather than the service, but applies every other limit.
+func (g *Guard) AllowResend(ctx context.Context, phoneNumber string) error {
+	return g.allow(ctx, phoneNumber, 0)
+}
+
+func (g *Guard) allow(ctx context.Context, phoneNumber string, cooldown time.Duration) error {
+	throttle := g.config.Security.SMSThrottle
+	if !throttle.Enabled {
+		return nil
+	}
+
+	country := callingCode(phoneNumber)
+	highRisk := matchingPrefixes(phoneNumber, throttle.Anomaly.HighRiskPrefixes)
+	watched := matchingPrefixes(phoneNumber, throttle.Anomaly.SpikePrefixes)
+
+	blocked, retryAfter, err := g.redisClient.GetBlockedPrefix(ctx, append(highRisk, watched...))
+	if err != nil {
+		return errors.NewInternalServer("Failed to check blocked destinations", err)
+	}
+	if blocked != "" {
+		smsThrottled.WithLabelValues("blocked_prefix").Inc()
+		return errors.NewDestinationBlocked("SMS to this destination is temporarily blocked", nil).WithRetryAfter(retryAfter)
+	}
+
+	result, err := g.redisClient.CheckSendLimits(ctx, phoneNumber, country, storage.SendLimits{
+		ResendCooldown:  cooldown,
+		DailyPerNumber:  throttle.DailyPerNumber,
+		DailyPerCountry: throttle.DailyPerCountry,
+		GlobalPerMinute: throttle.GlobalPerMinute,
+	})
+	if err != nil {
+		return errors.NewInternalServer("Failed to check SMS send limits", err)
+	}
+
+	switch result.Limit {
+	case "":
+		// Only sends that passed the limits count towards a spike
+		if throttle.Anomaly.Enabled {
+			return g.detectAnomalies(ctx, highRisk, watched)
+		}
+		return nil
+	case "cooldown":
+		smsThrottled.WithLabelValues(result.Limit).Inc()
+		return errors.NewRateLimited("Please wait before requesting another code", nil).WithRetryAfter(result.RetryAfter)
+	case "global":
+		smsThrottled.WithLabelValues(result.Limit).Inc()
+		return errors.NewServiceUnavailable("SMS capacity temporarily exhausted", nil).WithRetryAfter(result.RetryAfter)
+	default:
+		smsThrottled.WithLabelValues(result.Limit).Inc()
+		return errors.NewRateLimited("Too many codes requested for this destination", nil).WithRetryAfter(result.RetryAfter)
+	}
+}
+
+// detectAnomalies records the send against the high-risk and watched
+// prefixes it matches, blocking them when their volume looks like pumping
+func (g *Guard) detectAnomalies(ctx context.Context, highRisk, watched []string) error {
+	anomaly := g.config.Security.SMSThrottle.Anomaly
+
+	spike := storage.PrefixRule{
+		SpikeMinCount: anomaly.SpikeMinCount,
+		SpikeFactor:   anomaly.SpikeFactor,
+		Window:        anomaly.SpikeWindow,
+		MinHistory:    anomaly.SpikeMinHistory,
+		BlockFor:      anomaly.BlockDuration,
+	}
+	rules := make(map[string]storage.PrefixRule)
+	for _, prefix := range watched {
+		rules[prefix] = spike
+	}
+	for _, prefix := range highRisk {
+		// High-risk prefixes are also blocked on an absolute per-minute volume
+		rule := spike
+		rule.PerMinute = anomaly.HighRiskPerMinute
+		rules[prefix] = rule
+	}
+
+	var blocked bool
+	for prefix, rule := range rules {
+		reason, err := g.redisClient.RecordPrefixSend(ctx, prefix, rule)
+		if err != nil {
+			return errors.NewInternalServer("Failed to record SMS destination", err)
+		}
+		if reason != "" {
+			log.Printf("ALERT: possible SMS pumping to %s (%s), blocking for %s", prefix, reason, anomaly.BlockDuration)
+			smsPumpingAlerts.WithLabelValues(prefix, reason).Inc()
+			blocked = true
+		}
+	}
+
+	if blocked {
+		smsThrottled.WithLabelValues("blocked_prefix").Inc()
+		return errors.NewDestinationBlocked("SMS to this destination is temporarily blocked", nil).WithRetryAfter(anomaly.BlockDuration)
+	}
+	return nil
+}
+
+// callingCode returns the international calling code of phoneNumber, such as "+44"
+func callingCode(phoneNumber string) string {
+	if code := phone.CallingCode(phoneNumber); code != "" {
+		return code
+	}
+	return unknownCountry
+}
+
+// matchingPrefixes returns the entries of prefixes that phoneNumber starts with
+func matchingPrefixes(phoneNumber string, prefixes []string) []string {
+	var matches []string
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(phoneNumber, prefix) {
+			matches = append(matches, prefix)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,132 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const messageBirdDefaultBaseURL = "https://rest.messagebird.com"
+
+func init() {
+	Register("messagebird", func(cfg *config.Config) (Provider, error) {
+		return NewMessageBirdService(cfg)
+	})
+}
+
+// MessageBirdService sends SMS through the MessageBird REST API
+type MessageBirdService struct {
+	client     *http.Client
+	baseURL    string
+	accessKey  string
+	originator string
+	reportURL  string
+	templates  *Templates
+}
+
+type messageBirdRequest struct {
+	Originator string   `json:"originator"`
+	Recipients []string `json:"recipients"`
+	Body       string   `json:"body"`
+	ReportURL  string   `json:"reportUrl,omitempty"`
+}
+
+type messageBirdResponse struct {
+	ID string `json:"id"`
+}
+
+type messageBirdErrorResponse struct {
+	Errors []struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+		Parameter   string `json:"parameter"`
+	} `json:"errors"`
+}
+
+func NewMessageBirdService(cfg *config.Config) (*MessageBirdService, error) {
+	accessKey, err := cfg.SMS.MessageBird.AccessKey.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MessageBird access key: %w", err)
+	}
+
+	baseURL := cfg.SMS.MessageBird.BaseURL
+	if baseURL == "" {
+		baseURL = messageBirdDefaultBaseURL
+	}
+
+	templates, err := NewTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageBirdService{
+		client:     newHTTPClient(),
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		accessKey:  accessKey,
+		originator: cfg.SMS.MessageBird.Originator,
+		reportURL:  webhookURL(cfg, "messagebird"),
+		templates:  templates,
+	}, nil
+}
+
+func (s *MessageBirdService) Name() string {
+	return "messagebird"
+}
+
+func (s *MessageBirdService) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	body, err := s.templates.RenderOTP(ctx, otp)
+	if err != nil {
+		return err
+	}
+	return s.SendMessage(ctx, phoneNumber, body)
+}
+
+func (s *MessageBirdService) SendMessage(ctx context.Context, phoneNumber, text string) error {
+	body, err := json.Marshal(messageBirdRequest{
+		Originator: s.originator,
+		Recipients: []string{strings.TrimPrefix(phoneNumber, "+")},
+		Body:       text,
+		ReportURL:  s.reportURL,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode MessageBird request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create MessageBird request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "AccessKey "+s.accessKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		var result messageBirdResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			recordReceipt(ctx, s.Name(), result.ID)
+		}
+		return nil
+	}
+
+	sendErr := &SendError{Provider: s.Name(), StatusCode: resp.StatusCode}
+	var errResp messageBirdErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && len(errResp.Errors) > 0 {
+		first := errResp.Errors[0]
+		sendErr.Code = strconv.Itoa(first.Code)
+		sendErr.Message = first.Description
+		// Code 9 with the recipients parameter means no valid recipient was given
+		sendErr.Recipient = first.Code == 9 && first.Parameter == "recipients"
+	}
+	return sendErr
+}
@@ -0,0 +1,77 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestMessageBirdService(t *testing.T, handler http.HandlerFunc) *MessageBirdService {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := newProviderTestConfig()
+	cfg.SMS.MessageBird.AccessKey.Value = "live_key"
+	cfg.SMS.MessageBird.Originator = "Acme"
+	cfg.SMS.MessageBird.BaseURL = server.URL
+
+	s, err := NewMessageBirdService(cfg)
+	if err != nil {
+		t.Fatalf("NewMessageBirdService() error = %v", err)
+	}
+	return s
+}
+
+func TestMessageBirdSendMessage(t *testing.T) {
+	s := newTestMessageBirdService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/messages" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "AccessKey live_key" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		var req messageBirdRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		// MessageBird takes numbers without the leading +
+		if req.Originator != "Acme" || len(req.Recipients) != 1 || req.Recipients[0] != "15550100" || req.Body != "hello" {
+			t.Errorf("request = %+v", req)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "mb-1"}`))
+	})
+
+	ctx, receipt := WithReceipt(context.Background())
+	if err := s.SendMessage(ctx, "+15550100", "hello"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if receipt.Provider != "messagebird" || receipt.MessageID != "mb-1" {
+		t.Errorf("receipt = %+v, want messagebird mb-1", receipt)
+	}
+}
+
+func TestMessageBirdSendMessageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   SendError
+	}{
+		{"no valid recipients", http.StatusUnprocessableEntity, `{"errors": [{"code": 9, "description": "no (correct) recipients found", "parameter": "recipients"}]}`, SendError{StatusCode: 422, Code: "9", Recipient: true}},
+		{"bad access key", http.StatusUnauthorized, `{"errors": [{"code": 2, "description": "Request not allowed (incorrect access_key)", "parameter": null}]}`, SendError{StatusCode: 401, Code: "2"}},
+		{"server error", http.StatusInternalServerError, `{"errors": [{"code": 99, "description": "internal error"}]}`, SendError{StatusCode: 500, Code: "99"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestMessageBirdService(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			assertSendError(t, s.SendMessage(context.Background(), "+15550100", "hello"), tt.want)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package sms
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sony/gobreaker"
+)
+
+var (
+	providerSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_provider_sends_total",
+		Help: "Total number of SMS send attempts per provider",
+	}, []string{"provider", "status"})
+
+	providerSendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "sms_provider_send_duration_seconds",
+		Help: "Duration of SMS send calls per provider",
+	}, []string{"provider"})
+
+	providerCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sms_provider_circuit_state",
+		Help: "Circuit breaker state per SMS provider (0=closed, 1=half-open, 2=open)",
+	}, []string{"provider"})
+
+	providerFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_provider_failovers_total",
+		Help: "Total number of times a send failed over away from a provider",
+	}, []string{"provider", "reason"})
+
+	smsThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_throttled_total",
+		Help: "Total number of SMS sends refused by throttling or fraud protection",
+	}, []string{"reason"})
+
+	smsPumpingAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_pumping_alerts_total",
+		Help: "Total number of destination prefixes blocked for suspected SMS pumping",
+	}, []string{"prefix", "reason"})
+)
+
+// circuitStateValue maps a breaker state to its gauge value
+func circuitStateValue(state gobreaker.State) float64 {
+	switch state {
+	case gobreaker.StateHalfOpen:
+		return 1
+	case gobreaker.StateOpen:
+		return 2
+	default:
+		return 0
+	}
+}
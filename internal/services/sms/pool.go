@@ -0,0 +1,50 @@
+package sms
+
+import (
+	"sync"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// Pool hands out the default provider and, on demand, the providers named by
+// per-country overrides. Override providers are created on first use and
+// reused afterwards. When the default provider is a Router, overrides are
+// sent through it so they keep its circuit breakers, failover and metrics.
+type Pool struct {
+	config    *config.Config
+	fallback  Provider
+	mu        sync.Mutex
+	providers map[string]Provider
+}
+
+func NewPool(cfg *config.Config, fallback Provider) *Pool {
+	return &Pool{
+		config:    cfg,
+		fallback:  fallback,
+		providers: make(map[string]Provider),
+	}
+}
+
+// Get returns the provider registered under name, or the default provider
+// when name is empty
+func (p *Pool) Get(name string) (Provider, error) {
+	if name == "" || name == p.fallback.Name() {
+		return p.fallback, nil
+	}
+	if router, ok := p.fallback.(*Router); ok {
+		return router.Prefer(name)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if provider, ok := p.providers[name]; ok {
+		return provider, nil
+	}
+	provider, err := NewProviderByName(name, p.config)
+	if err != nil {
+		return nil, err
+	}
+	p.providers[name] = provider
+	return provider, nil
+}
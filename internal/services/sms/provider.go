@@ -0,0 +1,125 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// defaultHTTPTimeout bounds every outbound call to an SMS provider API
+const defaultHTTPTimeout = 10 * time.Second
+
+// Provider delivers OTP codes over SMS
+type Provider interface {
+	// Name returns the provider's registry key
+	Name() string
+	// SendOTP sends otp to phoneNumber
+	SendOTP(ctx context.Context, phoneNumber, otp string) error
+	// SendMessage sends an arbitrary text message to phoneNumber
+	SendMessage(ctx context.Context, phoneNumber, body string) error
+}
+
+// Factory creates a Provider from the application configuration
+type Factory func(cfg *config.Config) (Provider, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a provider available under name. It panics if name is
+// already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("sms: provider %q registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Providers returns the names of all registered providers
+func Providers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider creates the provider selected by sms.provider, or a Router
+// across several providers when sms.routing is enabled
+func NewProvider(cfg *config.Config) (Provider, error) {
+	if cfg.SMS.Routing.Enabled {
+		return NewRouter(cfg)
+	}
+	return NewProviderByName(cfg.SMS.Provider, cfg)
+}
+
+// NewProviderByName creates the provider registered under name
+func NewProviderByName(name string, cfg *config.Config) (Provider, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown SMS provider: %s", name)
+	}
+	return factory(cfg)
+}
+
+type (
+	otpExpiryKey struct{}
+	localeKey    struct{}
+	clientAppKey struct{}
+)
+
+// WithOTPExpiry returns a context that makes SendOTP quote expiry in the
+// message instead of security.otp_expiry, for codes with a per-country expiry
+func WithOTPExpiry(ctx context.Context, expiry time.Duration) context.Context {
+	return context.WithValue(ctx, otpExpiryKey{}, expiry)
+}
+
+// WithLocale returns a context that makes SendOTP render the message in the
+// best match for preference, a language tag or Accept-Language header value
+func WithLocale(ctx context.Context, preference string) context.Context {
+	return context.WithValue(ctx, localeKey{}, preference)
+}
+
+func localeFromContext(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
+
+// WithClientApp returns a context that makes SendOTP append the autofill
+// lines configured for the client app in sms.client_apps
+func WithClientApp(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, clientAppKey{}, name)
+}
+
+func clientAppFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(clientAppKey{}).(string)
+	return name
+}
+
+// otpExpiry returns the expiry set by WithOTPExpiry, or fallback
+func otpExpiry(ctx context.Context, fallback time.Duration) time.Duration {
+	if expiry, ok := ctx.Value(otpExpiryKey{}).(time.Duration); ok && expiry > 0 {
+		return expiry
+	}
+	return fallback
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: defaultHTTPTimeout}
+}
@@ -0,0 +1,31 @@
+package sms
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// newProviderTestConfig returns the configuration every provider needs to
+// render its messages
+func newProviderTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.SMS.DefaultLocale = "en"
+	cfg.SMS.BrandName = "Acme"
+	cfg.Security.OTPExpiry = 5 * time.Minute
+	return cfg
+}
+
+// assertSendError checks that err is a SendError classified as want
+func assertSendError(t *testing.T, err error, want SendError) {
+	t.Helper()
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("error = %v, want a SendError", err)
+	}
+	if sendErr.StatusCode != want.StatusCode || sendErr.Code != want.Code || sendErr.Recipient != want.Recipient {
+		t.Errorf("error = %+v, want status %d, code %q, recipient %v", sendErr, want.StatusCode, want.Code, want.Recipient)
+	}
+}
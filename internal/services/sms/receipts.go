@@ -0,0 +1,46 @@
+package sms
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// webhookPath is where providers post delivery receipts, followed by the
+// provider name
+const webhookPath = "/api/v1/webhooks/sms/"
+
+// Receipt records the provider that accepted a message and the ID it
+// assigned, so that later delivery receipts can be matched to the message
+type Receipt struct {
+	Provider  string
+	MessageID string
+}
+
+type receiptKey struct{}
+
+// WithReceipt returns a context in which the provider that accepts a message
+// fills in the returned Receipt. Providers that do not report delivery leave
+// it empty.
+func WithReceipt(ctx context.Context) (context.Context, *Receipt) {
+	receipt := &Receipt{}
+	return context.WithValue(ctx, receiptKey{}, receipt), receipt
+}
+
+// recordReceipt fills in the Receipt carried by ctx, if any
+func recordReceipt(ctx context.Context, provider, messageID string) {
+	if receipt, ok := ctx.Value(receiptKey{}).(*Receipt); ok {
+		receipt.Provider = provider
+		receipt.MessageID = messageID
+	}
+}
+
+// webhookURL returns the status callback URL for provider, or "" when
+// delivery receipts are disabled
+func webhookURL(cfg *config.Config, provider string) string {
+	if !cfg.SMS.Webhooks.Enabled {
+		return ""
+	}
+	return strings.TrimSuffix(cfg.SMS.Webhooks.BaseURL, "/") + webhookPath + provider
+}
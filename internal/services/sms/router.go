@@ -0,0 +1,285 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/services"
+	"github.com/sony/gobreaker"
+)
+
+// ErrNoProviderAvailable is returned when every candidate provider failed or had an open circuit
+var ErrNoProviderAvailable = errors.New("no SMS provider available")
+
+// errSendAborted marks a send that failed because the caller's context
+// ended, which says nothing about the provider
+var errSendAborted = errors.New("SMS send aborted")
+
+// routedProvider is a provider guarded by its own circuit breaker
+type routedProvider struct {
+	provider Provider
+	breaker  *gobreaker.CircuitBreaker
+}
+
+// route is a set of weighted, prioritised targets for numbers matching prefix
+type route struct {
+	prefix  string
+	targets []config.SMSRouteTarget
+}
+
+// Router sends OTPs through one of several providers, choosing by
+// destination prefix, priority and weight, and failing over to the next
+// candidate when a provider errors or its circuit breaker is open. Only
+// transport errors, 5xx and 429 responses count against a provider's
+// breaker, and a provider refusing the recipient ends the send, as every
+// other provider would refuse it too.
+type Router struct {
+	config       *config.Config
+	mu           sync.RWMutex
+	providers    map[string]*routedProvider
+	routes       []route
+	defaultRoute []config.SMSRouteTarget
+}
+
+func NewRouter(cfg *config.Config) (*Router, error) {
+	routing := cfg.SMS.Routing
+	if len(routing.Providers) == 0 {
+		return nil, fmt.Errorf("SMS routing requires at least one provider")
+	}
+
+	r := &Router{
+		config:    cfg,
+		providers: make(map[string]*routedProvider),
+	}
+
+	for i, name := range routing.Providers {
+		if err := r.addProvider(name, cfg); err != nil {
+			return nil, err
+		}
+		// Without an explicit route, providers are tried in the order they are listed
+		r.defaultRoute = append(r.defaultRoute, config.SMSRouteTarget{Name: name, Weight: 1, Priority: i})
+	}
+
+	for _, rc := range routing.Routes {
+		for _, target := range rc.Providers {
+			if _, ok := r.providers[target.Name]; !ok {
+				return nil, fmt.Errorf("SMS route %s references provider %s which is not in sms.routing.providers", rc.Prefix, target.Name)
+			}
+		}
+		r.routes = append(r.routes, route{prefix: rc.Prefix, targets: rc.Providers})
+	}
+
+	// Longest prefix first so the most specific route wins
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].prefix) > len(r.routes[j].prefix)
+	})
+
+	return r, nil
+}
+
+func (r *Router) addProvider(name string, cfg *config.Config) error {
+	if name == "router" {
+		return fmt.Errorf("SMS router cannot route to itself")
+	}
+
+	provider, err := NewProviderByName(name, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create SMS provider %s: %w", name, err)
+	}
+
+	breaker := services.NewCircuitBreaker("sms:"+name, func(err error) bool {
+		return !isProviderFailure(err)
+	}, func(_ string, from, to gobreaker.State) {
+		log.Printf("SMS provider %s circuit breaker changed from %s to %s", name, from, to)
+		providerCircuitState.WithLabelValues(name).Set(circuitStateValue(to))
+	})
+	providerCircuitState.WithLabelValues(name).Set(circuitStateValue(breaker.State()))
+
+	r.providers[name] = &routedProvider{
+		provider: provider,
+		breaker:  breaker,
+	}
+	return nil
+}
+
+func (r *Router) Name() string {
+	return "router"
+}
+
+// SendOTP tries each candidate provider for phoneNumber in turn until one succeeds
+func (r *Router) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	return r.send(ctx, phoneNumber, "", func(p Provider) error {
+		return p.SendOTP(ctx, phoneNumber, otp)
+	})
+}
+
+// SendMessage tries each candidate provider for phoneNumber in turn until one succeeds
+func (r *Router) SendMessage(ctx context.Context, phoneNumber, body string) error {
+	return r.send(ctx, phoneNumber, "", func(p Provider) error {
+		return p.SendMessage(ctx, phoneNumber, body)
+	})
+}
+
+// Prefer returns a Provider that sends through the provider registered under
+// name first and then fails over to the usual candidates for the number.
+// Providers missing from sms.routing.providers, such as those named by
+// per-country overrides, get their own circuit breaker on first use.
+func (r *Router) Prefer(name string) (Provider, error) {
+	r.mu.RLock()
+	_, ok := r.providers[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.providers[name]; !ok {
+			if err := r.addProvider(name, r.config); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &preferredRoute{router: r, name: name}, nil
+}
+
+// send runs fn against each candidate provider until one succeeds, starting
+// with preferred when it is set
+func (r *Router) send(ctx context.Context, phoneNumber, preferred string, fn func(Provider) error) error {
+	var errs []error
+	for _, name := range r.candidates(phoneNumber, preferred) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.mu.RLock()
+		rp := r.providers[name]
+		r.mu.RUnlock()
+		start := time.Now()
+		_, err := rp.breaker.Execute(func() (interface{}, error) {
+			err := fn(rp.provider)
+			if err != nil && ctx.Err() != nil {
+				return nil, fmt.Errorf("%w: %w", errSendAborted, err)
+			}
+			return nil, err
+		})
+
+		if err == nil {
+			providerSendDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			providerSends.WithLabelValues(name, "success").Inc()
+			return nil
+		}
+
+		switch {
+		case errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests):
+			providerSends.WithLabelValues(name, "circuit_open").Inc()
+			providerFailovers.WithLabelValues(name, "circuit_open").Inc()
+		case errors.Is(err, errSendAborted):
+			return err
+		case IsRecipientError(err):
+			providerSendDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			providerSends.WithLabelValues(name, "rejected").Inc()
+			return fmt.Errorf("%s: %w", name, err)
+		default:
+			providerSendDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			providerSends.WithLabelValues(name, "failure").Inc()
+			providerFailovers.WithLabelValues(name, "error").Inc()
+			log.Printf("SMS provider %s failed, failing over: %v", name, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+
+	return fmt.Errorf("%w: %w", ErrNoProviderAvailable, errors.Join(errs...))
+}
+
+// candidates returns provider names in the order they should be tried.
+// preferred, if set, comes first. Lower priority values are tried next;
+// providers that share a priority are ordered by a weighted random draw.
+func (r *Router) candidates(phoneNumber, preferred string) []string {
+	targets := r.defaultRoute
+	for _, rt := range r.routes {
+		if strings.HasPrefix(phoneNumber, rt.prefix) {
+			targets = rt.targets
+			break
+		}
+	}
+
+	tiers := make(map[int][]config.SMSRouteTarget)
+	var priorities []int
+	for _, t := range targets {
+		if _, ok := tiers[t.Priority]; !ok {
+			priorities = append(priorities, t.Priority)
+		}
+		tiers[t.Priority] = append(tiers[t.Priority], t)
+	}
+	sort.Ints(priorities)
+
+	names := make([]string, 0, len(targets)+1)
+	if preferred != "" {
+		names = append(names, preferred)
+	}
+	for _, p := range priorities {
+		for _, name := range weightedOrder(tiers[p]) {
+			if name != preferred {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// weightedOrder draws targets without replacement, proportionally to their weight
+func weightedOrder(targets []config.SMSRouteTarget) []string {
+	remaining := make([]config.SMSRouteTarget, len(targets))
+	copy(remaining, targets)
+
+	names := make([]string, 0, len(targets))
+	for len(remaining) > 0 {
+		total := 0
+		for _, t := range remaining {
+			total += max(t.Weight, 1)
+		}
+
+		pick := rand.IntN(total)
+		idx := 0
+		for i, t := range remaining {
+			pick -= max(t.Weight, 1)
+			if pick < 0 {
+				idx = i
+				break
+			}
+		}
+
+		names = append(names, remaining[idx].Name)
+		remaining = append(remaining[:idx], remaining[idx+1:]...)
+	}
+	return names
+}
+
+// preferredRoute sends through the router, trying one provider first
+type preferredRoute struct {
+	router *Router
+	name   string
+}
+
+func (p *preferredRoute) Name() string {
+	return p.name
+}
+
+func (p *preferredRoute) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	return p.router.send(ctx, phoneNumber, p.name, func(provider Provider) error {
+		return provider.SendOTP(ctx, phoneNumber, otp)
+	})
+}
+
+func (p *preferredRoute) SendMessage(ctx context.Context, phoneNumber, body string) error {
+	return p.router.send(ctx, phoneNumber, p.name, func(provider Provider) error {
+		return provider.SendMessage(ctx, phoneNumber, body)
+	})
+}
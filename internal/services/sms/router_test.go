@@ -0,0 +1,144 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/lmousom/passless-auth/internal/services"
+	"github.com/sony/gobreaker"
+)
+
+// fakeProvider fails every send with err and counts its calls. onSend, if
+// set, runs during each send.
+type fakeProvider struct {
+	name   string
+	err    error
+	calls  int
+	onSend func()
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+
+func (p *fakeProvider) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	return p.SendMessage(ctx, phoneNumber, otp)
+}
+
+func (p *fakeProvider) SendMessage(ctx context.Context, phoneNumber, body string) error {
+	p.calls++
+	if p.onSend != nil {
+		p.onSend()
+	}
+	return p.err
+}
+
+func newTestRouter(providers ...*fakeProvider) *Router {
+	r := &Router{providers: make(map[string]*routedProvider)}
+	for i, p := range providers {
+		r.providers[p.name] = &routedProvider{
+			provider: p,
+			breaker: services.NewCircuitBreaker("sms:"+p.name, func(err error) bool {
+				return !isProviderFailure(err)
+			}, nil),
+		}
+		r.defaultRoute = append(r.defaultRoute, config.SMSRouteTarget{Name: p.name, Weight: 1, Priority: i})
+	}
+	return r
+}
+
+func TestRouterFailsOverOnProviderFailure(t *testing.T) {
+	primary := &fakeProvider{name: "primary", err: &SendError{Provider: "primary", StatusCode: http.StatusServiceUnavailable}}
+	secondary := &fakeProvider{name: "secondary"}
+	r := newTestRouter(primary, secondary)
+
+	if err := r.SendMessage(context.Background(), "+15550100", "hi"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if primary.calls != 1 || secondary.calls != 1 {
+		t.Errorf("calls = %d, %d, want 1, 1", primary.calls, secondary.calls)
+	}
+}
+
+func TestRouterDoesNotFailOverOnRecipientError(t *testing.T) {
+	primary := &fakeProvider{name: "primary", err: &SendError{Provider: "primary", StatusCode: http.StatusBadRequest, Recipient: true}}
+	secondary := &fakeProvider{name: "secondary"}
+	r := newTestRouter(primary, secondary)
+
+	err := r.SendMessage(context.Background(), "+15550100", "hi")
+	if !IsRecipientError(err) {
+		t.Fatalf("SendMessage() error = %v, want a recipient error", err)
+	}
+	if errors.Is(err, ErrNoProviderAvailable) {
+		t.Errorf("SendMessage() error = %v, want no ErrNoProviderAvailable", err)
+	}
+	if secondary.calls != 0 {
+		t.Errorf("secondary calls = %d, want 0", secondary.calls)
+	}
+}
+
+func TestRouterPreferTriesProviderFirst(t *testing.T) {
+	primary := &fakeProvider{name: "primary"}
+	override := &fakeProvider{name: "override", err: &SendError{Provider: "override", StatusCode: http.StatusInternalServerError}}
+	r := newTestRouter(primary, override)
+
+	provider, err := r.Prefer("override")
+	if err != nil {
+		t.Fatalf("Prefer() error = %v", err)
+	}
+	if err := provider.SendMessage(context.Background(), "+15550100", "hi"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if override.calls != 1 || primary.calls != 1 {
+		t.Errorf("calls = %d, %d, want 1, 1", override.calls, primary.calls)
+	}
+}
+
+func TestRouterBreakerCountsOnlyProviderFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantOpen bool
+	}{
+		{"transport error", errors.New("connection refused"), true},
+		{"server error", &SendError{StatusCode: http.StatusBadGateway}, true},
+		{"rate limited", &SendError{StatusCode: http.StatusTooManyRequests}, true},
+		{"bad credentials", &SendError{StatusCode: http.StatusUnauthorized}, true},
+		{"account suspended", &SendError{StatusCode: http.StatusForbidden}, true},
+		{"bad request", &SendError{StatusCode: http.StatusBadRequest}, false},
+		{"recipient refused", &SendError{StatusCode: http.StatusBadRequest, Recipient: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeProvider{name: "primary", err: tt.err}
+			r := newTestRouter(p)
+			for i := 0; i < 5; i++ {
+				r.SendMessage(context.Background(), "+15550100", "hi")
+			}
+			if open := r.providers["primary"].breaker.State() == gobreaker.StateOpen; open != tt.wantOpen {
+				t.Errorf("breaker open = %v, want %v", open, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestRouterIgnoresCallerCancellation(t *testing.T) {
+	p := &fakeProvider{name: "primary", err: context.Canceled}
+	secondary := &fakeProvider{name: "secondary"}
+	r := newTestRouter(p, secondary)
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.onSend = cancel
+		if err := r.SendMessage(ctx, "+15550100", "hi"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("SendMessage() error = %v, want context.Canceled", err)
+		}
+	}
+	if state := r.providers["primary"].breaker.State(); state != gobreaker.StateClosed {
+		t.Errorf("breaker state = %v, want closed", state)
+	}
+	if secondary.calls != 0 {
+		t.Errorf("secondary calls = %d, want 0", secondary.calls)
+	}
+}
@@ -0,0 +1,214 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const defaultInboxSize = 20
+
+func init() {
+	Register("console", func(cfg *config.Config) (Provider, error) {
+		return NewSinkService("console", cfg)
+	})
+	Register("file", func(cfg *config.Config) (Provider, error) {
+		return NewSinkService("file", cfg)
+	})
+	Register("memory", func(cfg *config.Config) (Provider, error) {
+		return NewSinkService("memory", cfg)
+	})
+}
+
+// SinkMessage is an OTP message captured by a sink provider instead of being delivered
+type SinkMessage struct {
+	To     string    `json:"to"`
+	Body   string    `json:"body"`
+	Code   string    `json:"code,omitempty"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Inbox keeps the most recent messages captured for each phone number
+type Inbox struct {
+	mu       sync.RWMutex
+	size     int
+	messages map[string][]SinkMessage
+}
+
+var devInbox = NewInbox(defaultInboxSize)
+
+// DevInbox returns the inbox shared by all sink providers
+func DevInbox() *Inbox {
+	return devInbox
+}
+
+func NewInbox(size int) *Inbox {
+	return &Inbox{
+		size:     size,
+		messages: make(map[string][]SinkMessage),
+	}
+}
+
+// Add records msg, evicting the oldest message for its recipient when full
+func (i *Inbox) Add(msg SinkMessage) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	msgs := append(i.messages[msg.To], msg)
+	if len(msgs) > i.size {
+		msgs = msgs[len(msgs)-i.size:]
+	}
+	i.messages[msg.To] = msgs
+}
+
+// Resize changes how many messages are kept per recipient
+func (i *Inbox) Resize(size int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.size = size
+	for phone, msgs := range i.messages {
+		if len(msgs) > size {
+			i.messages[phone] = msgs[len(msgs)-size:]
+		}
+	}
+}
+
+// Messages returns up to limit messages for phone, newest first
+func (i *Inbox) Messages(phone string, limit int) []SinkMessage {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	msgs := i.messages[phone]
+	if limit <= 0 || limit > len(msgs) {
+		limit = len(msgs)
+	}
+
+	result := make([]SinkMessage, 0, limit)
+	for j := len(msgs) - 1; j >= 0 && len(result) < limit; j-- {
+		result = append(result, msgs[j])
+	}
+	return result
+}
+
+// sinkProviders are the providers that capture messages instead of sending them
+var sinkProviders = []string{"console", "file", "memory"}
+
+// UsesSink reports whether any SMS is sent through a sink provider, either
+// as sms.provider or as one of the routed providers
+func UsesSink(cfg *config.Config) bool {
+	if slices.Contains(sinkProviders, cfg.SMS.Provider) {
+		return true
+	}
+	if cfg.SMS.Routing.Enabled {
+		for _, name := range cfg.SMS.Routing.Providers {
+			if slices.Contains(sinkProviders, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SinkService is a development provider that logs or stores OTP messages
+// instead of sending them. Every message is also kept in the dev inbox.
+type SinkService struct {
+	mode      string
+	filePath  string
+	fileMu    sync.Mutex
+	inbox     *Inbox
+	templates *Templates
+}
+
+func NewSinkService(mode string, cfg *config.Config) (*SinkService, error) {
+	if cfg.Server.Environment == "production" {
+		return nil, fmt.Errorf("SMS provider %s is not allowed in production", mode)
+	}
+
+	if mode == "file" && cfg.SMS.Sink.FilePath == "" {
+		return nil, fmt.Errorf("sms.sink.file_path is required for the file SMS provider")
+	}
+
+	if cfg.SMS.Sink.InboxSize > 0 {
+		devInbox.Resize(cfg.SMS.Sink.InboxSize)
+	}
+
+	templates, err := NewTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SinkService{
+		mode:      mode,
+		filePath:  cfg.SMS.Sink.FilePath,
+		inbox:     devInbox,
+		templates: templates,
+	}, nil
+}
+
+func (s *SinkService) Name() string {
+	return s.mode
+}
+
+func (s *SinkService) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	body, err := s.templates.RenderOTP(ctx, otp)
+	if err != nil {
+		return err
+	}
+	return s.capture(SinkMessage{
+		To:     phoneNumber,
+		Body:   body,
+		Code:   otp,
+		SentAt: time.Now().UTC(),
+	})
+}
+
+func (s *SinkService) SendMessage(ctx context.Context, phoneNumber, body string) error {
+	return s.capture(SinkMessage{
+		To:     phoneNumber,
+		Body:   body,
+		SentAt: time.Now().UTC(),
+	})
+}
+
+// capture logs or stores msg according to the sink mode and adds it to the inbox
+func (s *SinkService) capture(msg SinkMessage) error {
+	switch s.mode {
+	case "console":
+		log.Printf("SMS to %s: %s", msg.To, msg.Body)
+	case "file":
+		if err := s.appendToFile(msg); err != nil {
+			return fmt.Errorf("failed to write SMS to file: %w", err)
+		}
+	}
+
+	s.inbox.Add(msg)
+	return nil
+}
+
+// appendToFile writes msg as a JSON line to the sink file
+func (s *SinkService) appendToFile(msg SinkMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+
+	f, err := os.OpenFile(s.filePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
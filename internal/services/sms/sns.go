@@ -0,0 +1,199 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const (
+	snsAPIVersion   = "2010-03-31"
+	snsService      = "sns"
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+)
+
+func init() {
+	Register("sns", func(cfg *config.Config) (Provider, error) {
+		return NewSNSService(cfg)
+	})
+}
+
+// SNSService sends SMS through the AWS SNS Publish API
+type SNSService struct {
+	client          *http.Client
+	endpoint        *url.URL
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	senderID        string
+	templates       *Templates
+}
+
+type snsErrorResponse struct {
+	Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+func NewSNSService(cfg *config.Config) (*SNSService, error) {
+	accessKeyID, err := cfg.SMS.SNS.AccessKeyID.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SNS access key ID: %w", err)
+	}
+
+	secretAccessKey, err := cfg.SMS.SNS.SecretAccessKey.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SNS secret access key: %w", err)
+	}
+
+	baseURL := cfg.SMS.SNS.BaseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://sns.%s.amazonaws.com", cfg.SMS.SNS.Region)
+	}
+	endpoint, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNS base URL: %w", err)
+	}
+	if endpoint.Path == "" {
+		endpoint.Path = "/"
+	}
+
+	templates, err := NewTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SNSService{
+		client:          newHTTPClient(),
+		endpoint:        endpoint,
+		region:          cfg.SMS.SNS.Region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		senderID:        cfg.SMS.SNS.SenderID,
+		templates:       templates,
+	}, nil
+}
+
+func (s *SNSService) Name() string {
+	return "sns"
+}
+
+func (s *SNSService) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	body, err := s.templates.RenderOTP(ctx, otp)
+	if err != nil {
+		return err
+	}
+	return s.SendMessage(ctx, phoneNumber, body)
+}
+
+func (s *SNSService) SendMessage(ctx context.Context, phoneNumber, message string) error {
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", snsAPIVersion)
+	form.Set("PhoneNumber", phoneNumber)
+	form.Set("Message", message)
+	// OTPs are transactional so SNS optimises for delivery rather than cost
+	form.Set("MessageAttributes.entry.1.Name", "AWS.SNS.SMS.SMSType")
+	form.Set("MessageAttributes.entry.1.Value.DataType", "String")
+	form.Set("MessageAttributes.entry.1.Value.StringValue", "Transactional")
+	if s.senderID != "" {
+		form.Set("MessageAttributes.entry.2.Name", "AWS.SNS.SMS.SenderID")
+		form.Set("MessageAttributes.entry.2.Value.DataType", "String")
+		form.Set("MessageAttributes.entry.2.Value.StringValue", s.senderID)
+	}
+	body := form.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint.String(), strings.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SNS request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	sendErr := &SendError{Provider: s.Name(), StatusCode: resp.StatusCode}
+	var errResp snsErrorResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error.Code != "" {
+		sendErr.Code = errResp.Error.Code
+		sendErr.Message = errResp.Error.Message
+		switch {
+		case errResp.Error.Code == "Throttling" || errResp.Error.Code == "Throttled":
+			// SNS throttles with a 400
+			sendErr.StatusCode = http.StatusTooManyRequests
+		case errResp.Error.Code == "InvalidParameter" && strings.Contains(errResp.Error.Message, "PhoneNumber"):
+			sendErr.Recipient = true
+		}
+	}
+	return sendErr
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *SNSService) sign(req *http.Request, body string, now time.Time) {
+	amzDate := now.Format(sigV4TimeFormat)
+	date := now.Format("20060102")
+	host := req.URL.Host
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalHeaders := "content-type:" + req.Header.Get("Content-Type") + "\n" +
+		"host:" + host + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region, snsService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, snsService)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,82 @@
+package sms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var snsAuthorization = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/eu-west-1/sns/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=[0-9a-f]{64}$`)
+
+func newTestSNSService(t *testing.T, handler http.HandlerFunc) *SNSService {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := newProviderTestConfig()
+	cfg.SMS.SNS.AccessKeyID.Value = "AKID"
+	cfg.SMS.SNS.SecretAccessKey.Value = "secret"
+	cfg.SMS.SNS.Region = "eu-west-1"
+	cfg.SMS.SNS.SenderID = "Acme"
+	cfg.SMS.SNS.BaseURL = server.URL
+
+	s, err := NewSNSService(cfg)
+	if err != nil {
+		t.Fatalf("NewSNSService() error = %v", err)
+	}
+	return s
+}
+
+func TestSNSSendMessage(t *testing.T) {
+	s := newTestSNSService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); !snsAuthorization.MatchString(auth) {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if r.Header.Get("X-Amz-Date") == "" {
+			t.Error("X-Amz-Date is not set")
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		form := r.PostForm
+		if form.Get("Action") != "Publish" || form.Get("PhoneNumber") != "+15550100" || form.Get("Message") != "hello" {
+			t.Errorf("form = %v", form)
+		}
+		if form.Get("MessageAttributes.entry.1.Value.StringValue") != "Transactional" || form.Get("MessageAttributes.entry.2.Value.StringValue") != "Acme" {
+			t.Errorf("message attributes = %v", form)
+		}
+		w.Write([]byte(`<PublishResponse><PublishResult><MessageId>m-1</MessageId></PublishResult></PublishResponse>`))
+	})
+
+	if err := s.SendMessage(context.Background(), "+15550100", "hello"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+}
+
+func TestSNSSendMessageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   SendError
+	}{
+		{"invalid number", http.StatusBadRequest, `<ErrorResponse><Error><Code>InvalidParameter</Code><Message>Invalid parameter: PhoneNumber Reason: +1 is not valid</Message></Error></ErrorResponse>`, SendError{StatusCode: 400, Code: "InvalidParameter", Recipient: true}},
+		{"bad signature", http.StatusForbidden, `<ErrorResponse><Error><Code>SignatureDoesNotMatch</Code><Message>Signature mismatch</Message></Error></ErrorResponse>`, SendError{StatusCode: 403, Code: "SignatureDoesNotMatch"}},
+		{"throttled", http.StatusBadRequest, `<ErrorResponse><Error><Code>Throttling</Code><Message>Rate exceeded</Message></Error></ErrorResponse>`, SendError{StatusCode: 429, Code: "Throttling"}},
+		{"server error", http.StatusServiceUnavailable, ``, SendError{StatusCode: 503}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSNSService(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			assertSendError(t, s.SendMessage(context.Background(), "+15550100", "hello"), tt.want)
+		})
+	}
+}
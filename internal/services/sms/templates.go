@@ -0,0 +1,329 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"golang.org/x/text/language"
+)
+
+// defaultTemplateID is used when sms.template_id is empty
+const defaultTemplateID = "otp"
+
+// magicLinkTemplateID is the template set of magic link messages
+const magicLinkTemplateID = "magic_link"
+
+// maxOTPLength is the longest code security.otp_length allows, used to check
+// that templates always fit a single SMS
+const maxOTPLength = 8
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+// TemplateData is passed to the SMS templates
+type TemplateData struct {
+	Brand         string
+	Code          string
+	ExpiryMinutes int
+	// Link is the sign-in link of a magic link message
+	Link string
+}
+
+// Message is a rendered SMS body
+type Message struct {
+	Locale string
+	Body   string
+	Length
+}
+
+// Templates renders localized OTP messages. A template set is a directory of
+// <locale>.txt files, one per BCP 47 language tag, selected by sms.template_id.
+type Templates struct {
+	id        string
+	brand     string
+	otpExpiry time.Duration
+	// singleSegment replaces messages that would need several segments with
+	// the default locale's, which is checked to fit in one
+	singleSegment bool
+	// locales lists the available locales, default first
+	locales    []language.Tag
+	matcher    language.Matcher
+	templates  map[string]*template.Template
+	clientApps map[string]Autofill
+}
+
+func NewTemplates(cfg *config.Config) (*Templates, error) {
+	id := cfg.SMS.TemplateID
+	if id == "" {
+		id = defaultTemplateID
+	}
+
+	t, err := newTemplates(cfg, id)
+	if err != nil {
+		return nil, err
+	}
+	t.singleSegment = true
+
+	// Every other locale falls back to the default one when it would be too
+	// long, so the default must always fit in a single segment, including
+	// the autofill lines of every client app
+	autofills := []Autofill{{}}
+	for _, autofill := range t.clientApps {
+		autofills = append(autofills, autofill)
+	}
+	for _, autofill := range autofills {
+		msg, err := t.render(t.locales[0], worstCaseData(t.brand), autofill)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Segments > 1 {
+			return nil, fmt.Errorf("SMS template %s/%s needs %d %s segments; shorten it or sms.brand_name", id, t.locales[0], msg.Segments, msg.Encoding)
+		}
+	}
+
+	return t, nil
+}
+
+// NewMagicLinkTemplates creates the Templates of magic link messages, the
+// magic_link set. Links do not fit in a single segment, so messages are
+// sent in the matching locale whatever their length.
+func NewMagicLinkTemplates(cfg *config.Config) (*Templates, error) {
+	return newTemplates(cfg, magicLinkTemplateID)
+}
+
+func newTemplates(cfg *config.Config, id string) (*Templates, error) {
+	defaultLocale, err := language.Parse(cfg.SMS.DefaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sms.default_locale %q: %w", cfg.SMS.DefaultLocale, err)
+	}
+
+	sources, err := loadSMSTemplates(id, cfg.SMS.TemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{
+		id:         id,
+		brand:      cfg.SMS.BrandName,
+		otpExpiry:  cfg.Security.OTPExpiry,
+		templates:  make(map[string]*template.Template),
+		clientApps: make(map[string]Autofill),
+	}
+
+	for name, app := range cfg.SMS.ClientApps {
+		t.clientApps[strings.ToLower(name)] = Autofill{
+			WebOTPDomain:   app.WebOTPDomain,
+			AndroidAppHash: app.AndroidAppHash,
+		}
+	}
+
+	var others []language.Tag
+	for locale, src := range sources {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("SMS template %s has an invalid locale %q: %w", id, locale, err)
+		}
+		tmpl, err := template.New(id + "/" + locale).Option("missingkey=error").Parse(strings.TrimSpace(src))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SMS template %s/%s: %w", id, locale, err)
+		}
+		t.templates[tag.String()] = tmpl
+		if tag != defaultLocale {
+			others = append(others, tag)
+		}
+	}
+
+	if _, ok := t.templates[defaultLocale.String()]; !ok {
+		return nil, fmt.Errorf("SMS template %s has no %s locale", id, defaultLocale)
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].String() < others[j].String() })
+	t.locales = append([]language.Tag{defaultLocale}, others...)
+	t.matcher = language.NewMatcher(t.locales)
+	return t, nil
+}
+
+// ID returns the template set in use
+func (t *Templates) ID() string {
+	return t.id
+}
+
+// Locales returns the available locales, default first
+func (t *Templates) Locales() []string {
+	locales := make([]string, len(t.locales))
+	for i, tag := range t.locales {
+		locales[i] = tag.String()
+	}
+	return locales
+}
+
+// ClientApp returns the autofill settings of a configured client app
+func (t *Templates) ClientApp(name string) (Autofill, bool) {
+	autofill, ok := t.clientApps[strings.ToLower(name)]
+	return autofill, ok
+}
+
+// Render renders the OTP message for the best match of preference, which may
+// be a single language tag or an Accept-Language header value. A message that
+// would need more than one SMS segment is replaced by the default locale's.
+func (t *Templates) Render(preference, code string, expiry time.Duration, autofill Autofill) (*Message, error) {
+	return t.renderMatch(preference, TemplateData{
+		Brand:         t.brand,
+		Code:          code,
+		ExpiryMinutes: int(expiry.Minutes()),
+	}, autofill)
+}
+
+// RenderLink renders a magic link message for the best match of preference
+func (t *Templates) RenderLink(preference, link string, expiry time.Duration) (*Message, error) {
+	return t.renderMatch(preference, TemplateData{
+		Brand:         t.brand,
+		ExpiryMinutes: int(expiry.Minutes()),
+		Link:          link,
+	}, Autofill{})
+}
+
+func (t *Templates) renderMatch(preference string, data TemplateData, autofill Autofill) (*Message, error) {
+	locale := t.match(preference)
+	msg, err := t.render(locale, data, autofill)
+	if err != nil {
+		return nil, err
+	}
+	if t.singleSegment && msg.Segments > 1 && locale != t.locales[0] {
+		log.Printf("SMS template %s/%s needs %d segments, falling back to %s", t.id, locale, msg.Segments, t.locales[0])
+		return t.render(t.locales[0], data, autofill)
+	}
+	return msg, nil
+}
+
+// Preview renders locale exactly, without matching or falling back
+func (t *Templates) Preview(locale, code string, expiry time.Duration, autofill Autofill) (*Message, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid locale %q: %w", locale, err)
+	}
+	if _, ok := t.templates[tag.String()]; !ok {
+		return nil, fmt.Errorf("SMS template %s has no %s locale", t.id, tag)
+	}
+	return t.render(tag, TemplateData{
+		Brand:         t.brand,
+		Code:          code,
+		ExpiryMinutes: int(expiry.Minutes()),
+	}, autofill)
+}
+
+// Check renders every locale with the longest code and expiry it can receive
+func (t *Templates) Check(autofill Autofill) ([]*Message, error) {
+	messages := make([]*Message, 0, len(t.locales))
+	for _, locale := range t.locales {
+		msg, err := t.render(locale, worstCaseData(t.brand), autofill)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// RenderOTP renders the OTP message using the locale, expiry and client app
+// carried by ctx
+func (t *Templates) RenderOTP(ctx context.Context, otp string) (string, error) {
+	autofill, _ := t.ClientApp(clientAppFromContext(ctx))
+	msg, err := t.Render(localeFromContext(ctx), otp, otpExpiry(ctx, t.otpExpiry), autofill)
+	if err != nil {
+		return "", err
+	}
+	return msg.Body, nil
+}
+
+// match returns the supported locale closest to preference
+func (t *Templates) match(preference string) language.Tag {
+	if preference == "" {
+		return t.locales[0]
+	}
+	prefs, _, err := language.ParseAcceptLanguage(preference)
+	if err != nil || len(prefs) == 0 {
+		return t.locales[0]
+	}
+	_, idx, confidence := t.matcher.Match(prefs...)
+	if confidence == language.No {
+		return t.locales[0]
+	}
+	return t.locales[idx]
+}
+
+func (t *Templates) render(locale language.Tag, data TemplateData, autofill Autofill) (*Message, error) {
+	var buf bytes.Buffer
+	if err := t.templates[locale.String()].Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render SMS template %s/%s: %w", t.id, locale, err)
+	}
+	body := autofill.apply(buf.String(), data.Code)
+	return &Message{
+		Locale: locale.String(),
+		Body:   body,
+		Length: MessageLength(body),
+	}, nil
+}
+
+// worstCaseData fills a template with the longest values it can receive
+func worstCaseData(brand string) TemplateData {
+	return TemplateData{
+		Brand:         brand,
+		Code:          strings.Repeat("0", maxOTPLength),
+		ExpiryMinutes: 999,
+	}
+}
+
+// loadSMSTemplates returns the sources of template set id keyed by locale.
+// Files in dir/id override the embedded templates of the same locale.
+func loadSMSTemplates(id, dir string) (map[string]string, error) {
+	sources := make(map[string]string)
+
+	embedded, err := fs.Sub(defaultTemplates, path.Join("templates", id))
+	if err != nil {
+		return nil, err
+	}
+	if err := readTemplateDir(embedded, sources); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read default SMS templates: %w", err)
+	}
+
+	if dir != "" {
+		if err := readTemplateDir(os.DirFS(filepath.Join(dir, id)), sources); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read SMS templates from %s: %w", dir, err)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("unknown SMS template: %s", id)
+	}
+	return sources, nil
+}
+
+func readTemplateDir(fsys fs.FS, sources map[string]string) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".txt") {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return err
+		}
+		sources[strings.TrimSuffix(entry.Name(), ".txt")] = string(b)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package sms
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderLinkKeepsLocaleOfLongMessages(t *testing.T) {
+	templates, err := NewMagicLinkTemplates(newProviderTestConfig())
+	if err != nil {
+		t.Fatalf("NewMagicLinkTemplates() error = %v", err)
+	}
+
+	link := "https://auth.example.com/api/v1/magic/" + strings.Repeat("x", 43)
+	msg, err := templates.RenderLink("es-MX,es;q=0.9", link, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("RenderLink() error = %v", err)
+	}
+	if msg.Locale != "es" {
+		t.Errorf("locale = %s, want es", msg.Locale)
+	}
+	if !strings.HasSuffix(msg.Body, "\n"+link) || !strings.Contains(msg.Body, "10 min") {
+		t.Errorf("body = %q, want the expiry and the link on its own line", msg.Body)
+	}
+	if msg.Segments < 2 {
+		t.Errorf("segments = %d, want a message longer than one segment", msg.Segments)
+	}
+}
@@ -1,18 +1,52 @@
 package sms
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"time"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/lmousom/passless-auth/internal/config"
-	"github.com/twilio/twilio-go"
-	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
 )
 
+const twilioDefaultBaseURL = "https://api.twilio.com"
+
+// twilioRecipientErrors are Twilio error codes that refuse the recipient
+// rather than the request: invalid, unroutable, landline and opted out
+// numbers
+var twilioRecipientErrors = map[int]bool{
+	21211: true,
+	21214: true,
+	21217: true,
+	21610: true,
+	21612: true,
+	21614: true,
+}
+
+func init() {
+	Register("twilio", func(cfg *config.Config) (Provider, error) {
+		return NewTwilioService(cfg)
+	})
+}
+
+// TwilioService sends messages through the Twilio Messages API
 type TwilioService struct {
-	client     *twilio.RestClient
-	fromNumber string
-	otpExpiry  time.Duration
+	client         *http.Client
+	baseURL        string
+	accountSID     string
+	authToken      string
+	fromNumber     string
+	statusCallback string
+	templates      *Templates
+}
+
+type twilioMessageResponse struct {
+	Sid     string `json:"sid"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
 
 func NewTwilioService(cfg *config.Config) (*TwilioService, error) {
@@ -26,30 +60,78 @@ func NewTwilioService(cfg *config.Config) (*TwilioService, error) {
 		return nil, fmt.Errorf("failed to get auth token: %w", err)
 	}
 
-	client := twilio.NewRestClientWithParams(twilio.ClientParams{
-		Username: accountSID,
-		Password: authToken,
-	})
+	baseURL := cfg.SMS.Twilio.BaseURL
+	if baseURL == "" {
+		baseURL = twilioDefaultBaseURL
+	}
+
+	templates, err := NewTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	return &TwilioService{
-		client:     client,
-		fromNumber: cfg.SMS.FromNumber,
-		otpExpiry:  cfg.Security.OTPExpiry,
+		client:         newHTTPClient(),
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		accountSID:     accountSID,
+		authToken:      authToken,
+		fromNumber:     cfg.SMS.FromNumber,
+		statusCallback: webhookURL(cfg, "twilio"),
+		templates:      templates,
 	}, nil
 }
 
-func (s *TwilioService) SendOTP(phoneNumber, otp string) error {
-	params := &twilioApi.CreateMessageParams{}
-	params.SetTo(phoneNumber)
-	params.SetFrom(s.fromNumber)
+func (s *TwilioService) Name() string {
+	return "twilio"
+}
 
-	minutes := int(s.otpExpiry.Minutes())
-	params.SetBody(fmt.Sprintf("Your OTP is: %s. Valid for %d minutes.", otp, minutes))
+func (s *TwilioService) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	body, err := s.templates.RenderOTP(ctx, otp)
+	if err != nil {
+		return err
+	}
+	return s.SendMessage(ctx, phoneNumber, body)
+}
 
-	_, err := s.client.Api.CreateMessage(params)
+func (s *TwilioService) SendMessage(ctx context.Context, phoneNumber, body string) error {
+	form := url.Values{}
+	form.Set("To", phoneNumber)
+	form.Set("From", s.fromNumber)
+	form.Set("Body", body)
+	if s.statusCallback != "" {
+		form.Set("StatusCallback", s.statusCallback)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", s.baseURL, url.PathEscape(s.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create Twilio request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.accountSID, s.authToken)
+
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send SMS: %w", err)
 	}
+	defer resp.Body.Close()
+
+	var result twilioMessageResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		sendErr := &SendError{Provider: s.Name(), StatusCode: resp.StatusCode}
+		if decodeErr == nil && result.Code != 0 {
+			sendErr.Code = strconv.Itoa(result.Code)
+			sendErr.Message = result.Message
+			sendErr.Recipient = twilioRecipientErrors[result.Code]
+		}
+		return sendErr
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("failed to decode Twilio response: %w", decodeErr)
+	}
 
+	recordReceipt(ctx, s.Name(), result.Sid)
 	return nil
 }
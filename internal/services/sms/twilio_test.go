@@ -0,0 +1,76 @@
+package sms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestTwilioService(t *testing.T, handler http.HandlerFunc) *TwilioService {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := newProviderTestConfig()
+	cfg.SMS.AccountSID.Value = "AC123"
+	cfg.SMS.AuthToken.Value = "token"
+	cfg.SMS.FromNumber = "+15550000"
+	cfg.SMS.Twilio.BaseURL = server.URL + "/"
+
+	s, err := NewTwilioService(cfg)
+	if err != nil {
+		t.Fatalf("NewTwilioService() error = %v", err)
+	}
+	return s
+}
+
+func TestTwilioSendMessage(t *testing.T) {
+	s := newTestTwilioService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "AC123" || pass != "token" {
+			t.Errorf("basic auth = %q, %q, %v", user, pass, ok)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		if to, from, body := r.PostForm.Get("To"), r.PostForm.Get("From"), r.PostForm.Get("Body"); to != "+15550100" || from != "+15550000" || body != "hello" {
+			t.Errorf("form To = %q, From = %q, Body = %q", to, from, body)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "SM123", "status": "queued"}`))
+	})
+
+	ctx, receipt := WithReceipt(context.Background())
+	if err := s.SendMessage(ctx, "+15550100", "hello"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if receipt.Provider != "twilio" || receipt.MessageID != "SM123" {
+		t.Errorf("receipt = %+v, want twilio SM123", receipt)
+	}
+}
+
+func TestTwilioSendMessageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   SendError
+	}{
+		{"invalid number", http.StatusBadRequest, `{"code": 21211, "message": "Invalid 'To' Phone Number"}`, SendError{StatusCode: 400, Code: "21211", Recipient: true}},
+		{"bad credentials", http.StatusUnauthorized, `{"code": 20003, "message": "Authenticate"}`, SendError{StatusCode: 401, Code: "20003"}},
+		{"rate limited", http.StatusTooManyRequests, `{"code": 20429, "message": "Too Many Requests"}`, SendError{StatusCode: 429, Code: "20429"}},
+		{"server error", http.StatusInternalServerError, ``, SendError{StatusCode: 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestTwilioService(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			assertSendError(t, s.SendMessage(context.Background(), "+15550100", "hello"), tt.want)
+		})
+	}
+}
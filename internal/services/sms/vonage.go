@@ -0,0 +1,149 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const vonageDefaultBaseURL = "https://rest.nexmo.com"
+
+func init() {
+	Register("vonage", func(cfg *config.Config) (Provider, error) {
+		return NewVonageService(cfg)
+	})
+}
+
+// VonageService sends SMS through the Vonage (Nexmo) SMS API
+type VonageService struct {
+	client    *http.Client
+	baseURL   string
+	apiKey    string
+	apiSecret string
+	from      string
+	callback  string
+	templates *Templates
+}
+
+type vonageResponse struct {
+	Messages []struct {
+		Status    string `json:"status"`
+		ErrorText string `json:"error-text"`
+		MessageID string `json:"message-id"`
+	} `json:"messages"`
+}
+
+func NewVonageService(cfg *config.Config) (*VonageService, error) {
+	apiKey, err := cfg.SMS.Vonage.APIKey.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Vonage API key: %w", err)
+	}
+
+	apiSecret, err := cfg.SMS.Vonage.APISecret.Decrypt()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Vonage API secret: %w", err)
+	}
+
+	baseURL := cfg.SMS.Vonage.BaseURL
+	if baseURL == "" {
+		baseURL = vonageDefaultBaseURL
+	}
+
+	templates, err := NewTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VonageService{
+		client:    newHTTPClient(),
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		from:      cfg.SMS.Vonage.From,
+		callback:  webhookURL(cfg, "vonage"),
+		templates: templates,
+	}, nil
+}
+
+func (s *VonageService) Name() string {
+	return "vonage"
+}
+
+func (s *VonageService) SendOTP(ctx context.Context, phoneNumber, otp string) error {
+	body, err := s.templates.RenderOTP(ctx, otp)
+	if err != nil {
+		return err
+	}
+	return s.SendMessage(ctx, phoneNumber, body)
+}
+
+func (s *VonageService) SendMessage(ctx context.Context, phoneNumber, body string) error {
+	form := url.Values{}
+	form.Set("api_key", s.apiKey)
+	form.Set("api_secret", s.apiSecret)
+	form.Set("from", s.from)
+	// Vonage expects numbers in international format without the leading +
+	form.Set("to", strings.TrimPrefix(phoneNumber, "+"))
+	form.Set("text", body)
+	if s.callback != "" {
+		form.Set("callback", s.callback)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/sms/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create Vonage request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &SendError{Provider: s.Name(), StatusCode: resp.StatusCode}
+	}
+
+	var result vonageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode Vonage response: %w", err)
+	}
+	if len(result.Messages) == 0 {
+		return fmt.Errorf("failed to send SMS: Vonage returned no messages")
+	}
+
+	// A status of "0" means the message was accepted; anything else is an error code
+	for _, msg := range result.Messages {
+		if msg.Status != "0" {
+			return vonageError(msg.Status, msg.ErrorText)
+		}
+	}
+
+	recordReceipt(ctx, s.Name(), result.Messages[0].MessageID)
+	return nil
+}
+
+// vonageError maps a Vonage message status, which is reported with HTTP 200,
+// onto the HTTP status it stands for
+func vonageError(status, text string) error {
+	err := &SendError{Provider: "vonage", StatusCode: http.StatusBadRequest, Code: status, Message: text}
+	switch status {
+	case "1": // Throttled
+		err.StatusCode = http.StatusTooManyRequests
+	case "4": // Invalid credentials
+		err.StatusCode = http.StatusUnauthorized
+	case "8": // Account barred
+		err.StatusCode = http.StatusForbidden
+	case "5": // Internal error
+		err.StatusCode = http.StatusInternalServerError
+	case "6", "7": // Unroutable message, number barred
+		err.Recipient = true
+	}
+	return err
+}
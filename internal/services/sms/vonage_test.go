@@ -0,0 +1,77 @@
+package sms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestVonageService(t *testing.T, handler http.HandlerFunc) *VonageService {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := newProviderTestConfig()
+	cfg.SMS.Vonage.APIKey.Value = "key"
+	cfg.SMS.Vonage.APISecret.Value = "secret"
+	cfg.SMS.Vonage.From = "Acme"
+	cfg.SMS.Vonage.BaseURL = server.URL
+
+	s, err := NewVonageService(cfg)
+	if err != nil {
+		t.Fatalf("NewVonageService() error = %v", err)
+	}
+	return s
+}
+
+func TestVonageSendMessage(t *testing.T) {
+	s := newTestVonageService(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/sms/json" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		if key, secret := r.PostForm.Get("api_key"), r.PostForm.Get("api_secret"); key != "key" || secret != "secret" {
+			t.Errorf("credentials = %q, %q", key, secret)
+		}
+		// Vonage takes numbers without the leading +
+		if to, from, text := r.PostForm.Get("to"), r.PostForm.Get("from"), r.PostForm.Get("text"); to != "15550100" || from != "Acme" || text != "hello" {
+			t.Errorf("form to = %q, from = %q, text = %q", to, from, text)
+		}
+		w.Write([]byte(`{"message-count": "1", "messages": [{"status": "0", "message-id": "msg-1"}]}`))
+	})
+
+	ctx, receipt := WithReceipt(context.Background())
+	if err := s.SendMessage(ctx, "+15550100", "hello"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if receipt.Provider != "vonage" || receipt.MessageID != "msg-1" {
+		t.Errorf("receipt = %+v, want vonage msg-1", receipt)
+	}
+}
+
+func TestVonageSendMessageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   SendError
+	}{
+		{"throttled", http.StatusOK, `{"messages": [{"status": "1", "error-text": "Throttled"}]}`, SendError{StatusCode: 429, Code: "1"}},
+		{"bad credentials", http.StatusOK, `{"messages": [{"status": "4", "error-text": "Bad Credentials"}]}`, SendError{StatusCode: 401, Code: "4"}},
+		{"internal error", http.StatusOK, `{"messages": [{"status": "5", "error-text": "Internal Error"}]}`, SendError{StatusCode: 500, Code: "5"}},
+		{"number barred", http.StatusOK, `{"messages": [{"status": "7", "error-text": "Number barred"}]}`, SendError{StatusCode: 400, Code: "7", Recipient: true}},
+		{"server error", http.StatusBadGateway, ``, SendError{StatusCode: 502}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestVonageService(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			assertSendError(t, s.SendMessage(context.Background(), "+15550100", "hello"), tt.want)
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package sms
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lmousom/passless-auth/internal/config"
+	twilioClient "github.com/twilio/twilio-go/client"
+)
+
+const (
+	// maxWebhookBody bounds the size of a delivery receipt
+	maxWebhookBody = 64 << 10
+	// webhookMaxAge is how old a signed Vonage receipt may be
+	webhookMaxAge = 5 * time.Minute
+)
+
+var (
+	// ErrWebhookNotSupported is returned for providers that do not send
+	// delivery receipts, or whose signing secret is not configured
+	ErrWebhookNotSupported = errors.New("delivery receipts are not enabled for this provider")
+	// ErrInvalidSignature is returned when a receipt is not signed by the provider
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// ReportStatus is the state of a message according to a delivery receipt
+type ReportStatus string
+
+const (
+	// ReportSent covers every state before the handset confirmed or refused
+	// the message, such as queued, sent or buffered
+	ReportSent        ReportStatus = "sent"
+	ReportDelivered   ReportStatus = "delivered"
+	ReportUndelivered ReportStatus = "undelivered"
+)
+
+// Final reports whether the status will not change again
+func (s ReportStatus) Final() bool {
+	return s == ReportDelivered || s == ReportUndelivered
+}
+
+// StatusReport is a verified delivery receipt
+type StatusReport struct {
+	Provider  string
+	MessageID string
+	Status    ReportStatus
+	// RawStatus is the status as the provider named it
+	RawStatus string
+	ErrorCode string
+	// Carrier is the MCC/MNC of the network that handled the message, when known
+	Carrier string
+}
+
+// Webhooks verifies and parses the delivery receipts posted by providers
+type Webhooks struct {
+	baseURL           string
+	twilio            *twilioClient.RequestValidator
+	vonageSecret      string
+	messageBirdSecret string
+}
+
+// NewWebhooks creates a Webhooks. Only providers whose signing secret is
+// configured are accepted; Twilio signs with the account auth token.
+func NewWebhooks(cfg *config.Config) (*Webhooks, error) {
+	w := &Webhooks{
+		baseURL: strings.TrimSuffix(cfg.SMS.Webhooks.BaseURL, "/"),
+	}
+
+	authToken, err := cfg.GetDecryptedSMSAuthToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	}
+	if authToken != "" {
+		validator := twilioClient.NewRequestValidator(authToken)
+		w.twilio = &validator
+	}
+
+	if w.vonageSecret, err = cfg.SMS.Vonage.SignatureSecret.Decrypt(); err != nil {
+		return nil, fmt.Errorf("failed to get Vonage signature secret: %w", err)
+	}
+	if w.messageBirdSecret, err = cfg.SMS.MessageBird.SigningKey.Decrypt(); err != nil {
+		return nil, fmt.Errorf("failed to get MessageBird signing key: %w", err)
+	}
+
+	return w, nil
+}
+
+// Parse verifies the signature of a delivery receipt from provider and
+// returns its contents
+func (w *Webhooks) Parse(provider string, r *http.Request) (*StatusReport, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook body: %w", err)
+	}
+	// The URL the provider signed, as configured rather than as seen behind a proxy
+	signedURL := w.baseURL + r.URL.RequestURI()
+
+	switch {
+	case provider == "twilio" && w.twilio != nil:
+		return w.parseTwilio(r, body, signedURL)
+	case provider == "vonage" && w.vonageSecret != "":
+		return w.parseVonage(r, body)
+	case provider == "messagebird" && w.messageBirdSecret != "":
+		return w.parseMessageBird(r, body, signedURL)
+	default:
+		return nil, ErrWebhookNotSupported
+	}
+}
+
+// parseTwilio handles a Twilio status callback, signed in X-Twilio-Signature
+func (w *Webhooks) parseTwilio(r *http.Request, body []byte, signedURL string) (*StatusReport, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Twilio callback: %w", err)
+	}
+	params := flatten(form)
+	if !w.twilio.Validate(signedURL, params, r.Header.Get("X-Twilio-Signature")) {
+		return nil, ErrInvalidSignature
+	}
+
+	report := &StatusReport{
+		Provider:  "twilio",
+		MessageID: params["MessageSid"],
+		RawStatus: params["MessageStatus"],
+		ErrorCode: params["ErrorCode"],
+	}
+	switch report.RawStatus {
+	case "delivered", "read":
+		report.Status = ReportDelivered
+	case "undelivered", "failed":
+		report.Status = ReportUndelivered
+	default:
+		report.Status = ReportSent
+	}
+	return report.validate()
+}
+
+// parseVonage handles a Vonage delivery receipt, signed with an HMAC-SHA256
+// "sig" parameter. Receipts may arrive as a query string, a form or JSON.
+func (w *Webhooks) parseVonage(r *http.Request, body []byte) (*StatusReport, error) {
+	params, err := webhookParams(r, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Vonage receipt: %w", err)
+	}
+
+	timestamp, err := strconv.ParseInt(params["timestamp"], 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)).Abs() > webhookMaxAge {
+		return nil, ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(strings.ToLower(params["sig"])), []byte(vonageSignature(params, w.vonageSecret))) {
+		return nil, ErrInvalidSignature
+	}
+
+	report := &StatusReport{
+		Provider:  "vonage",
+		MessageID: params["messageId"],
+		RawStatus: params["status"],
+		Carrier:   params["network-code"],
+	}
+	if code := params["err-code"]; code != "0" {
+		report.ErrorCode = code
+	}
+	switch report.RawStatus {
+	case "delivered":
+		report.Status = ReportDelivered
+	case "expired", "failed", "rejected":
+		report.Status = ReportUndelivered
+	default:
+		report.Status = ReportSent
+	}
+	return report.validate()
+}
+
+// parseMessageBird handles a MessageBird status report, signed with a JWT
+// that covers the URL and body
+func (w *Webhooks) parseMessageBird(r *http.Request, body []byte, signedURL string) (*StatusReport, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(r.Header.Get("MessageBird-Signature-JWT"), claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(w.messageBirdSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithIssuer("MessageBird"))
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if claims["url_hash"] != sha256Hex(signedURL) {
+		return nil, ErrInvalidSignature
+	}
+	if len(body) > 0 && claims["payload_hash"] != sha256Hex(string(body)) {
+		return nil, ErrInvalidSignature
+	}
+
+	params, err := webhookParams(r, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MessageBird report: %w", err)
+	}
+
+	report := &StatusReport{
+		Provider:  "messagebird",
+		MessageID: params["id"],
+		RawStatus: params["status"],
+		ErrorCode: params["statusErrorCode"],
+		Carrier:   params["mccmnc"],
+	}
+	switch report.RawStatus {
+	case "delivered":
+		report.Status = ReportDelivered
+	case "delivery_failed", "expired":
+		report.Status = ReportUndelivered
+	default:
+		report.Status = ReportSent
+	}
+	return report.validate()
+}
+
+func (r *StatusReport) validate() (*StatusReport, error) {
+	if r.MessageID == "" || r.RawStatus == "" {
+		return nil, fmt.Errorf("%s receipt is missing the message ID or status", r.Provider)
+	}
+	return r, nil
+}
+
+// vonageSignature computes the signature of params: every parameter but
+// "sig", sorted, as "&key=value" with "&" and "=" in values replaced by "_"
+func vonageSignature(params map[string]string, secret string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "sig" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var signed strings.Builder
+	replacer := strings.NewReplacer("&", "_", "=", "_")
+	for _, key := range keys {
+		signed.WriteString("&" + key + "=" + replacer.Replace(params[key]))
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookParams collects the parameters of a receipt from the query string
+// and a form or JSON body
+func webhookParams(r *http.Request, body []byte) (map[string]string, error) {
+	params := flatten(r.URL.Query())
+	if len(body) == 0 {
+		return params, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		var fields map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&fields); err != nil {
+			return nil, err
+		}
+		for key, value := range fields {
+			if value != nil {
+				params[key] = fmt.Sprint(value)
+			}
+		}
+		return params, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range flatten(form) {
+		params[key] = value
+	}
+	return params, nil
+}
+
+func flatten(values url.Values) map[string]string {
+	params := make(map[string]string, len(values))
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+	return params
+}
@@ -0,0 +1,22 @@
+package voice
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// defaultHTTPTimeout bounds every outbound call to the voice provider API
+const defaultHTTPTimeout = 10 * time.Second
+
+// Caller reads OTP codes out over a phone call
+type Caller interface {
+	// Name returns the provider's name
+	Name() string
+	// CallOTP places a call to phoneNumber that reads out otp
+	CallOTP(ctx context.Context, phoneNumber, otp string) error
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: defaultHTTPTimeout}
+}
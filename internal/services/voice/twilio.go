@@ -0,0 +1,108 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+const twilioDefaultBaseURL = "https://api.twilio.com"
+
+// TwilioCaller places calls through the Twilio Programmable Voice Calls API,
+// passing the TwiML inline so that no callback into this service is needed
+type TwilioCaller struct {
+	client     *http.Client
+	baseURL    string
+	accountSID string
+	authToken  string
+	fromNumber string
+	script     Script
+}
+
+type twilioCallResponse struct {
+	Sid     string `json:"sid"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewTwilioCaller creates a TwilioCaller with the Twilio account configured
+// under sms
+func NewTwilioCaller(cfg *config.Config) (*TwilioCaller, error) {
+	accountSID, err := cfg.GetDecryptedSMSAccountSID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account SID: %w", err)
+	}
+
+	authToken, err := cfg.GetDecryptedSMSAuthToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	}
+
+	baseURL := cfg.Voice.BaseURL
+	if baseURL == "" {
+		baseURL = twilioDefaultBaseURL
+	}
+
+	return &TwilioCaller{
+		client:     newHTTPClient(),
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		accountSID: accountSID,
+		authToken:  authToken,
+		fromNumber: cfg.Voice.FromNumber,
+		script: Script{
+			Brand:    cfg.SMS.BrandName,
+			Voice:    cfg.Voice.Voice,
+			Language: cfg.Voice.Language,
+			Repeat:   cfg.Voice.Repeat,
+		},
+	}, nil
+}
+
+func (c *TwilioCaller) Name() string {
+	return "twilio"
+}
+
+func (c *TwilioCaller) CallOTP(ctx context.Context, phoneNumber, otp string) error {
+	twiml, err := c.script.TwiML(otp)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("To", phoneNumber)
+	form.Set("From", c.fromNumber)
+	form.Set("Twiml", twiml)
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", c.baseURL, url.PathEscape(c.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create Twilio call request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.accountSID, c.authToken)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to place call: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result twilioCallResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if decodeErr == nil && result.Message != "" {
+			return fmt.Errorf("failed to place call: Twilio error %d: %s", result.Code, result.Message)
+		}
+		return fmt.Errorf("failed to place call: Twilio returned status %d", resp.StatusCode)
+	}
+	if decodeErr != nil || result.Sid == "" {
+		return fmt.Errorf("failed to decode Twilio call response")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package voice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+func newTestTwilioCaller(t *testing.T, handler http.HandlerFunc) *TwilioCaller {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{}
+	cfg.SMS.AccountSID.Value = "AC123"
+	cfg.SMS.AuthToken.Value = "token"
+	cfg.SMS.BrandName = "Acme"
+	cfg.Voice.FromNumber = "+15550000"
+	cfg.Voice.BaseURL = server.URL + "/"
+	cfg.Voice.Repeat = 2
+
+	c, err := NewTwilioCaller(cfg)
+	if err != nil {
+		t.Fatalf("NewTwilioCaller() error = %v", err)
+	}
+	return c
+}
+
+func TestTwilioCallOTP(t *testing.T) {
+	c := newTestTwilioCaller(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2010-04-01/Accounts/AC123/Calls.json" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "AC123" || pass != "token" {
+			t.Errorf("basic auth = %q, %q, %v", user, pass, ok)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("ParseForm() error = %v", err)
+		}
+		if to, from := r.PostForm.Get("To"), r.PostForm.Get("From"); to != "+15550100" || from != "+15550000" {
+			t.Errorf("form To = %q, From = %q", to, from)
+		}
+		if twiml := r.PostForm.Get("Twiml"); !strings.Contains(twiml, "<Say>4. 9. 3. 8. 1. 7.</Say>") {
+			t.Errorf("form Twiml = %q, want the code read out", twiml)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "CA123", "status": "queued"}`))
+	})
+
+	if err := c.CallOTP(context.Background(), "+15550100", "493817"); err != nil {
+		t.Fatalf("CallOTP() error = %v", err)
+	}
+}
+
+func TestTwilioCallOTPErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"twilio error", http.StatusBadRequest, `{"code": 21215, "message": "Geo Permission configuration is not permitting call"}`, "Twilio error 21215: Geo Permission configuration is not permitting call"},
+		{"bad credentials", http.StatusUnauthorized, `{"code": 20003, "message": "Authenticate"}`, "Twilio error 20003: Authenticate"},
+		{"server error", http.StatusInternalServerError, ``, "Twilio returned status 500"},
+		{"no call sid", http.StatusCreated, `{}`, "failed to decode Twilio call response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestTwilioCaller(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+			err := c.CallOTP(context.Background(), "+15550100", "493817")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("CallOTP() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
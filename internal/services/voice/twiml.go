@@ -0,0 +1,68 @@
+package voice
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// say is the TwiML verb that reads text aloud
+type say struct {
+	XMLName  xml.Name `xml:"Say"`
+	Voice    string   `xml:"voice,attr,omitempty"`
+	Language string   `xml:"language,attr,omitempty"`
+	Text     string   `xml:",chardata"`
+}
+
+// pause is the TwiML verb that waits for a number of seconds
+type pause struct {
+	XMLName xml.Name `xml:"Pause"`
+	Length  int      `xml:"length,attr"`
+}
+
+type response struct {
+	XMLName xml.Name `xml:"Response"`
+	Verbs   []interface{}
+}
+
+// Script describes how a code is read out
+type Script struct {
+	Brand    string
+	Voice    string
+	Language string
+	// Repeat is how many times the code is read
+	Repeat int
+}
+
+// TwiML returns the TwiML document that reads code out: once after an
+// introduction and again Repeat-1 times, one digit at a time with a pause
+// after each so it can be written down
+func (s Script) TwiML(code string) (string, error) {
+	speak := func(text string) say {
+		return say{Voice: s.Voice, Language: s.Language, Text: text}
+	}
+
+	// Give the callee a moment to put the phone to their ear
+	verbs := []interface{}{pause{Length: 1}}
+	for i := 0; i < max(s.Repeat, 1); i++ {
+		if i == 0 {
+			verbs = append(verbs, speak(fmt.Sprintf("This is %s. Your verification code is:", s.Brand)))
+		} else {
+			verbs = append(verbs, speak("Again, your code is:"))
+		}
+		verbs = append(verbs, pause{Length: 1}, speak(spellDigits(code)), pause{Length: 1})
+	}
+	verbs = append(verbs, speak("Goodbye."))
+
+	body, err := xml.Marshal(response{Verbs: verbs})
+	if err != nil {
+		return "", fmt.Errorf("failed to render TwiML: %w", err)
+	}
+	return xml.Header + string(body), nil
+}
+
+// spellDigits separates the digits of code with full stops, which the
+// text-to-speech engine reads as a short pause
+func spellDigits(code string) string {
+	return strings.Join(strings.Split(code, ""), ". ") + "."
+}
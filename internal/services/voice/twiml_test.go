@@ -0,0 +1,35 @@
+package voice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptTwiML(t *testing.T) {
+	tests := []struct {
+		repeat int
+		want   int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 3},
+	}
+	for _, tt := range tests {
+		s := Script{Brand: "Acme", Voice: "Polly.Joanna", Language: "en-US", Repeat: tt.repeat}
+		twiml, err := s.TwiML("4938")
+		if err != nil {
+			t.Fatalf("TwiML() error = %v", err)
+		}
+
+		if !strings.HasPrefix(twiml, `<?xml version="1.0" encoding="UTF-8"?>`) {
+			t.Errorf("TwiML() = %q, want an XML document", twiml)
+		}
+		digits := `<Say voice="Polly.Joanna" language="en-US">4. 9. 3. 8.</Say>`
+		if got := strings.Count(twiml, digits); got != tt.want {
+			t.Errorf("Repeat %d: code read %d times, want %d: %s", tt.repeat, got, tt.want, twiml)
+		}
+		if !strings.Contains(twiml, "This is Acme.") {
+			t.Errorf("TwiML() = %q, want the brand introduced", twiml)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// deliveryGroup is the consumer group shared by all delivery workers
+const deliveryGroup = "delivery-workers"
+
+// DeliveryStatus is the state of a queued message
+type DeliveryStatus string
+
+const (
+	DeliveryQueued   DeliveryStatus = "queued"
+	DeliverySending  DeliveryStatus = "sending"
+	DeliveryRetrying DeliveryStatus = "retrying"
+	DeliverySent     DeliveryStatus = "sent"
+	DeliveryFailed   DeliveryStatus = "failed"
+)
+
+// DeliveryJob is a message waiting to be delivered by a worker
+type DeliveryJob struct {
+	ID        string `json:"id"`
+	Channel   string `json:"channel"`
+	Recipient string `json:"recipient"`
+	// Code is stored encrypted when an encryption key is configured
+	Code string `json:"code,omitempty"`
+	// Link is a magic link sent instead of a code. It is stored encrypted
+	// like Code.
+	Link      string `json:"link,omitempty"`
+	Locale    string `json:"locale,omitempty"`
+	ClientApp string `json:"client_app,omitempty"`
+	// Provider overrides the default SMS provider, for SMS jobs and for the
+	// SMS fallback of messaging app jobs
+	Provider string `json:"provider,omitempty"`
+	// Region is the recipient's country, used to label delivery metrics
+	Region string `json:"region,omitempty"`
+	// Fallback marks a resend after the original message was reported
+	// undelivered; it is not resent again
+	Fallback bool `json:"fallback,omitempty"`
+	// Expiry is the OTP lifetime quoted in the message
+	Expiry time.Duration `json:"expiry"`
+	// ExpiresAt is when the code stops being valid; it is never delivered later
+	ExpiresAt time.Time `json:"expires_at"`
+	Attempt   int       `json:"attempt"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeliveryMessage is a job read from the delivery stream
+type DeliveryMessage struct {
+	StreamID string
+	Job      *DeliveryJob
+	// Err is set when the job could not be decoded, such as after the key
+	// that encrypted it was rotated out. Job then holds the fields that
+	// could be read, without its code or link, or is empty if the payload
+	// is not a job at all.
+	Err error
+}
+
+// DeliveryRecord is the pollable status of a job
+type DeliveryRecord struct {
+	ID          string
+	Channel     string
+	Status      DeliveryStatus
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// ReceiptStatus is the latest status reported by the provider's
+	// delivery receipts, if any
+	ReceiptStatus string
+	// FallbackID is the delivery that resent the code after this one was
+	// reported undelivered
+	FallbackID string
+}
+
+// promoteRetriesScript moves jobs whose retry time has come back onto the
+// delivery stream.
+//
+// KEYS[1] retry set, KEYS[2] delivery stream
+// ARGV[1] now (ms), ARGV[2] max jobs to move
+var promoteRetriesScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, job in ipairs(due) do
+	redis.call('XADD', KEYS[2], '*', 'job', job)
+	redis.call('ZREM', KEYS[1], job)
+end
+return #due
+`)
+
+// EnsureDeliveryGroup creates the delivery stream and its consumer group
+func (r *RedisClient) EnsureDeliveryGroup(ctx context.Context) error {
+	err := r.client.XGroupCreateMkStream(ctx, r.deliveryStreamKey(), deliveryGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// EnqueueDelivery assigns job an ID, adds it to the delivery stream and
+// records it as queued
+func (r *RedisClient) EnqueueDelivery(ctx context.Context, job *DeliveryJob, statusTTL time.Duration) error {
+	id, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+	job.ID = id
+
+	payload, err := encodeDeliveryJob(job)
+	if err != nil {
+		return err
+	}
+
+	statusKey := r.deliveryStatusKey(job.ID)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, statusKey,
+			"channel", job.Channel,
+			"status", string(DeliveryQueued),
+			"attempts", job.Attempt,
+			"created_at", job.CreatedAt.UnixMilli(),
+			"updated_at", job.CreatedAt.UnixMilli())
+		pipe.PExpire(ctx, statusKey, statusTTL)
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.deliveryStreamKey(),
+			Values: map[string]interface{}{"job": payload},
+		})
+		return nil
+	})
+	return err
+}
+
+// ReadDeliveries waits up to block for new jobs assigned to consumer
+func (r *RedisClient) ReadDeliveries(ctx context.Context, consumer string, count int, block time.Duration) ([]DeliveryMessage, error) {
+	streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    deliveryGroup,
+		Consumer: consumer,
+		Streams:  []string{r.deliveryStreamKey(), ">"},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []DeliveryMessage
+	for _, stream := range streams {
+		messages = append(messages, r.decodeDeliveries(stream.Messages)...)
+	}
+	return messages, nil
+}
+
+// ClaimStaleDeliveries takes over jobs another consumer read but did not
+// finish within minIdle, such as after a crash
+func (r *RedisClient) ClaimStaleDeliveries(ctx context.Context, consumer string, minIdle time.Duration, count int) ([]DeliveryMessage, error) {
+	messages, _, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   r.deliveryStreamKey(),
+		Group:    deliveryGroup,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    "0",
+		Count:    int64(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeDeliveries(messages), nil
+}
+
+// AckDelivery removes a finished job from the stream
+func (r *RedisClient) AckDelivery(ctx context.Context, streamID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, r.deliveryStreamKey(), deliveryGroup, streamID)
+		pipe.XDel(ctx, r.deliveryStreamKey(), streamID)
+		return nil
+	})
+	return err
+}
+
+// ScheduleDeliveryRetry removes a failed job from the stream and schedules it
+// to be queued again at the given time
+func (r *RedisClient) ScheduleDeliveryRetry(ctx context.Context, streamID string, job *DeliveryJob, at time.Time) error {
+	payload, err := encodeDeliveryJob(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, r.deliveryRetryKey(), redis.Z{Score: float64(at.UnixMilli()), Member: payload})
+		pipe.XAck(ctx, r.deliveryStreamKey(), deliveryGroup, streamID)
+		pipe.XDel(ctx, r.deliveryStreamKey(), streamID)
+		return nil
+	})
+	return err
+}
+
+// PromoteDueRetries queues up to limit jobs whose retry time has passed
+func (r *RedisClient) PromoteDueRetries(ctx context.Context, limit int) (int, error) {
+	keys := []string{r.deliveryRetryKey(), r.deliveryStreamKey()}
+	return promoteRetriesScript.Run(ctx, r.client, keys, time.Now().UnixMilli(), limit).Int()
+}
+
+// DeadLetterDelivery removes a job that permanently failed from the stream and
+// keeps it, without its code or link, in the dead-letter list
+func (r *RedisClient) DeadLetterDelivery(ctx context.Context, streamID string, job *DeliveryJob, reason string, maxLen int) error {
+	deadJob := *job
+	deadJob.Code = ""
+	deadJob.Link = ""
+	payload, err := json.Marshal(struct {
+		*DeliveryJob
+		Reason   string    `json:"reason"`
+		FailedAt time.Time `json:"failed_at"`
+	}{&deadJob, reason, time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, r.deliveryDeadKey(), payload)
+		pipe.LTrim(ctx, r.deliveryDeadKey(), 0, int64(maxLen-1))
+		pipe.XAck(ctx, r.deliveryStreamKey(), deliveryGroup, streamID)
+		pipe.XDel(ctx, r.deliveryStreamKey(), streamID)
+		return nil
+	})
+	return err
+}
+
+// SetDeliveryStatus updates the pollable status of a job. lastError and
+// nextAttempt are cleared when empty.
+func (r *RedisClient) SetDeliveryStatus(ctx context.Context, id string, status DeliveryStatus, attempts int, lastError string, nextAttempt time.Time) error {
+	key := r.deliveryStatusKey(id)
+	var next int64
+	if !nextAttempt.IsZero() {
+		next = nextAttempt.UnixMilli()
+	}
+
+	return r.client.HSet(ctx, key,
+		"status", string(status),
+		"attempts", attempts,
+		"last_error", lastError,
+		"next_attempt", next,
+		"updated_at", time.Now().UnixMilli()).Err()
+}
+
+// GetDeliveryStatus returns the status of job id, or nil if it is unknown or expired
+func (r *RedisClient) GetDeliveryStatus(ctx context.Context, id string) (*DeliveryRecord, error) {
+	fields, err := r.client.HGetAll(ctx, r.deliveryStatusKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	return &DeliveryRecord{
+		ID:          id,
+		Channel:     fields["channel"],
+		Status:      DeliveryStatus(fields["status"]),
+		Attempts:    attempts,
+		LastError:   fields["last_error"],
+		NextAttempt: unixMilliField(fields["next_attempt"]),
+		CreatedAt:   unixMilliField(fields["created_at"]),
+		UpdatedAt:   unixMilliField(fields["updated_at"]),
+
+		ReceiptStatus: fields["receipt_status"],
+		FallbackID:    fields["fallback_id"],
+	}, nil
+}
+
+// decodeDeliveries parses stream entries. Entries that cannot be decoded are
+// returned with Err set so that the worker can give up on them.
+func (r *RedisClient) decodeDeliveries(entries []redis.XMessage) []DeliveryMessage {
+	messages := make([]DeliveryMessage, 0, len(entries))
+	for _, entry := range entries {
+		payload, _ := entry.Values["job"].(string)
+		job, err := decodeDeliveryJob([]byte(payload))
+		if job == nil {
+			job = &DeliveryJob{}
+		}
+		messages = append(messages, DeliveryMessage{StreamID: entry.ID, Job: job, Err: err})
+	}
+	return messages
+}
+
+// encodeDeliveryJob serializes job for storage, encrypting its code and link
+// with the configuration encryption key so that Redis never holds them in
+// plaintext. Without a key they are stored as is.
+func encodeDeliveryJob(job *DeliveryJob) ([]byte, error) {
+	stored := *job
+	if config.EncryptionEnabled() {
+		for _, secret := range []*string{&stored.Code, &stored.Link} {
+			var value config.EncryptedValue
+			if err := value.Encrypt(*secret); err != nil {
+				return nil, fmt.Errorf("failed to encrypt delivery job: %w", err)
+			}
+			*secret = value.Value
+		}
+	}
+	return json.Marshal(&stored)
+}
+
+// decodeDeliveryJob parses a job stored by encodeDeliveryJob. If the job
+// parses but cannot be decrypted, it is returned without its code and link
+// along with the error.
+func decodeDeliveryJob(payload []byte) (*DeliveryJob, error) {
+	var job DeliveryJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, fmt.Errorf("failed to parse delivery job: %w", err)
+	}
+
+	for _, secret := range []*string{&job.Code, &job.Link} {
+		value := config.EncryptedValue{Value: *secret}
+		plaintext, err := value.Decrypt()
+		if err != nil {
+			job.Code, job.Link = "", ""
+			return &job, fmt.Errorf("failed to decrypt delivery job: %w", err)
+		}
+		*secret = plaintext
+	}
+	return &job, nil
+}
+
+// unixMilliField parses a hash field holding Unix milliseconds, returning the
+// zero time if it is unset
+func unixMilliField(value string) time.Time {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+func (r *RedisClient) deliveryStreamKey() string {
+	return fmt.Sprintf("%sdelivery:stream", r.config.Redis.KeyPrefix)
+}
+
+func (r *RedisClient) deliveryRetryKey() string {
+	return fmt.Sprintf("%sdelivery:retry", r.config.Redis.KeyPrefix)
+}
+
+func (r *RedisClient) deliveryDeadKey() string {
+	return fmt.Sprintf("%sdelivery:dead", r.config.Redis.KeyPrefix)
+}
+
+func (r *RedisClient) deliveryStatusKey(id string) string {
+	return fmt.Sprintf("%sdelivery:status:%s", r.config.Redis.KeyPrefix, id)
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+func TestDeliveryJobIsEncryptedAtRest(t *testing.T) {
+	setEncryptionKey(t)
+
+	r, mr := newTestClient(t)
+	ctx := context.Background()
+	if err := r.EnsureDeliveryGroup(ctx); err != nil {
+		t.Fatalf("EnsureDeliveryGroup: %v", err)
+	}
+
+	job := &DeliveryJob{Channel: "sms", Recipient: "+15550100", Code: "493817", Link: "https://auth.example.com/api/v1/magic/c2VjcmV0", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := r.EnqueueDelivery(ctx, job, time.Minute); err != nil {
+		t.Fatalf("EnqueueDelivery: %v", err)
+	}
+	if err := r.TrackSMSMessage(ctx, "twilio", "SM1", job, time.Minute, true); err != nil {
+		t.Fatalf("TrackSMSMessage: %v", err)
+	}
+
+	for _, key := range mr.Keys() {
+		var stored string
+		switch mr.Type(key) {
+		case "stream":
+			entries, _ := mr.Stream(key)
+			for _, entry := range entries {
+				stored += strings.Join(entry.Values, " ")
+			}
+		case "string":
+			stored, _ = mr.Get(key)
+		}
+		if strings.Contains(stored, job.Code) || strings.Contains(stored, job.Link) {
+			t.Errorf("%s holds the job in plaintext: %s", key, stored)
+		}
+	}
+
+	messages, err := r.ReadDeliveries(ctx, "worker", 1, time.Millisecond)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ReadDeliveries() = %v, %v, want one job", messages, err)
+	}
+	if got := messages[0].Job; got.Code != job.Code || got.Link != job.Link {
+		t.Errorf("queued job = %q, %q, want %q, %q", got.Code, got.Link, job.Code, job.Link)
+	}
+
+	kept, err := r.TakeDeliveryJob(ctx, job.ID)
+	if err != nil || kept == nil {
+		t.Fatalf("TakeDeliveryJob() = %v, %v, want the kept job", kept, err)
+	}
+	if kept.Code != job.Code {
+		t.Errorf("kept code = %q, want %q", kept.Code, job.Code)
+	}
+}
+
+// setEncryptionKey configures a new random encryption key
+func setEncryptionKey(t *testing.T) {
+	t.Helper()
+	key, err := config.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("GenerateEncryptionKey: %v", err)
+	}
+	t.Setenv(config.EncryptionKeyEnv, key)
+}
+
+func TestDeliveryJobEncryptedWithAnotherKey(t *testing.T) {
+	setEncryptionKey(t)
+
+	r, _ := newTestClient(t)
+	ctx := context.Background()
+	if err := r.EnsureDeliveryGroup(ctx); err != nil {
+		t.Fatalf("EnsureDeliveryGroup: %v", err)
+	}
+	job := &DeliveryJob{Channel: "sms", Recipient: "+15550100", Code: "493817", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := r.EnqueueDelivery(ctx, job, time.Minute); err != nil {
+		t.Fatalf("EnqueueDelivery: %v", err)
+	}
+
+	// Rotate the key the job was encrypted with out
+	setEncryptionKey(t)
+
+	messages, err := r.ReadDeliveries(ctx, "worker", 1, time.Millisecond)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ReadDeliveries() = %v, %v, want one job", messages, err)
+	}
+	msg := messages[0]
+	if msg.Err == nil {
+		t.Fatal("message error = nil, want a decryption error")
+	}
+	if msg.Job.ID != job.ID || msg.Job.Code != "" {
+		t.Errorf("job = %q with code %q, want %q without its code", msg.Job.ID, msg.Job.Code, job.ID)
+	}
+
+	// The worker gives up on it, so its status must not stay queued
+	if err := r.DeadLetterDelivery(ctx, msg.StreamID, msg.Job, "job could not be decoded", 10); err != nil {
+		t.Fatalf("DeadLetterDelivery: %v", err)
+	}
+	if err := r.SetDeliveryStatus(ctx, job.ID, DeliveryFailed, 0, "job could not be decoded", time.Time{}); err != nil {
+		t.Fatalf("SetDeliveryStatus: %v", err)
+	}
+	record, err := r.GetDeliveryStatus(ctx, job.ID)
+	if err != nil || record == nil || record.Status != DeliveryFailed {
+		t.Errorf("GetDeliveryStatus() = %+v, %v, want failed", record, err)
+	}
+}
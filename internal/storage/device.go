@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeviceStatus is the state of a device authorization
+type DeviceStatus string
+
+const (
+	DevicePending  DeviceStatus = "pending"
+	DeviceApproved DeviceStatus = "approved"
+	DeviceDenied   DeviceStatus = "denied"
+	DeviceExpired  DeviceStatus = "expired"
+	DeviceNotFound DeviceStatus = "not_found"
+	// DeviceSlowDown means the device polled before its interval had
+	// passed; the interval has been increased
+	DeviceSlowDown DeviceStatus = "slow_down"
+)
+
+// deviceSlowDownStep is added to a device's polling interval each time it
+// polls too early (RFC 8628 section 3.5)
+const deviceSlowDownStep = 5 * time.Second
+
+// DeviceAuthorization is a device waiting for its user to approve it by
+// entering UserCode
+type DeviceAuthorization struct {
+	ClientID  string
+	Scope     string
+	UserCode  string
+	Interval  time.Duration
+	ExpiresAt time.Time
+}
+
+// DevicePoll is the result of a device asking for its tokens. Scope and
+// User are set when Status is DeviceApproved.
+type DevicePoll struct {
+	Status   DeviceStatus
+	Interval time.Duration
+	Scope    string
+	User     *UserAuthorization
+}
+
+// completeDeviceScript approves or denies a pending device authorization
+// and retires its user code.
+//
+// KEYS[1] user code, KEYS[2] device hash
+// ARGV[1] device code hash, ARGV[2] status, ARGV[3] user, ARGV[4] now (ms)
+var completeDeviceScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local device = redis.call('HMGET', KEYS[2], 'status', 'expires_at')
+if device[1] ~= 'pending' or tonumber(device[2]) <= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call('HSET', KEYS[2], 'status', ARGV[2], 'user', ARGV[3])
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// pollDeviceScript answers a device polling for its tokens. An approved or
+// denied authorization is removed when it is reported, so it is only
+// redeemed once.
+//
+// KEYS[1] device hash
+// ARGV[1] client ID, ARGV[2] now (ms), ARGV[3] slow down step (ms)
+var pollDeviceScript = redis.NewScript(`
+local device = redis.call('HMGET', KEYS[1], 'client_id', 'status', 'interval', 'last_polled_at', 'expires_at', 'user', 'scope')
+if not device[1] or device[1] ~= ARGV[1] then
+	return {'not_found'}
+end
+local now = tonumber(ARGV[2])
+if tonumber(device[5]) <= now then
+	return {'expired'}
+end
+local interval = tonumber(device[3])
+if device[2] ~= 'pending' then
+	redis.call('DEL', KEYS[1])
+	return {device[2], tostring(interval), device[6] or '', device[7] or ''}
+end
+redis.call('HSET', KEYS[1], 'last_polled_at', ARGV[2])
+if device[4] and now - tonumber(device[4]) < interval then
+	interval = interval + tonumber(ARGV[3])
+	redis.call('HSET', KEYS[1], 'interval', interval)
+	return {'slow_down', tostring(interval)}
+end
+return {'pending', tostring(interval)}
+`)
+
+// CreateDeviceAuthorization stores device and returns its device code, or
+// false if device.UserCode is already in use. Only the device code's hash
+// is stored. The authorization is kept for as long again after it expires
+// so that late polls are told it expired.
+func (r *RedisClient) CreateDeviceAuthorization(ctx context.Context, device *DeviceAuthorization) (string, bool, error) {
+	deviceCode, err := randomToken(32)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to generate device code: %w", err)
+	}
+	deviceHash := hashToken(deviceCode)
+
+	ttl := time.Until(device.ExpiresAt)
+	ok, err := r.client.SetNX(ctx, r.deviceUserCodeKey(device.UserCode), deviceHash, ttl).Result()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to store user code: %w", err)
+	}
+	if !ok {
+		return "", false, nil
+	}
+
+	deviceKey := r.deviceKey(deviceHash)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, deviceKey, map[string]interface{}{
+			"client_id":  device.ClientID,
+			"scope":      device.Scope,
+			"user_code":  device.UserCode,
+			"status":     string(DevicePending),
+			"interval":   device.Interval.Milliseconds(),
+			"expires_at": device.ExpiresAt.UnixMilli(),
+		})
+		pipe.PExpireAt(ctx, deviceKey, device.ExpiresAt.Add(ttl))
+		return nil
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to store device authorization: %w", err)
+	}
+	return deviceCode, true, nil
+}
+
+// GetDeviceAuthorization returns the pending device authorization with
+// userCode, or nil if there is none
+func (r *RedisClient) GetDeviceAuthorization(ctx context.Context, userCode string) (*DeviceAuthorization, error) {
+	deviceHash, err := r.client.Get(ctx, r.deviceUserCodeKey(userCode)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user code: %w", err)
+	}
+
+	fields, err := r.client.HGetAll(ctx, r.deviceKey(deviceHash)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device authorization: %w", err)
+	}
+	if fields["status"] != string(DevicePending) {
+		return nil, nil
+	}
+
+	device := &DeviceAuthorization{
+		ClientID:  fields["client_id"],
+		Scope:     fields["scope"],
+		UserCode:  fields["user_code"],
+		ExpiresAt: unixMilliField(fields["expires_at"]),
+	}
+	if !device.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	interval, _ := strconv.ParseInt(fields["interval"], 10, 64)
+	device.Interval = time.Duration(interval) * time.Millisecond
+	return device, nil
+}
+
+// ApproveDeviceAuthorization lets the device with userCode sign in as user.
+// It returns false if there is no pending authorization with userCode.
+func (r *RedisClient) ApproveDeviceAuthorization(ctx context.Context, userCode string, user *UserAuthorization) (bool, error) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode user authorization: %w", err)
+	}
+	return r.completeDeviceAuthorization(ctx, userCode, DeviceApproved, string(data))
+}
+
+// DenyDeviceAuthorization refuses the device with userCode. It returns false
+// if there is no pending authorization with userCode.
+func (r *RedisClient) DenyDeviceAuthorization(ctx context.Context, userCode string) (bool, error) {
+	return r.completeDeviceAuthorization(ctx, userCode, DeviceDenied, "")
+}
+
+func (r *RedisClient) completeDeviceAuthorization(ctx context.Context, userCode string, status DeviceStatus, user string) (bool, error) {
+	userCodeKey := r.deviceUserCodeKey(userCode)
+	deviceHash, err := r.client.Get(ctx, userCodeKey).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get user code: %w", err)
+	}
+
+	completed, err := completeDeviceScript.Run(ctx, r.client,
+		[]string{userCodeKey, r.deviceKey(deviceHash)},
+		deviceHash, string(status), user, time.Now().UnixMilli(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to complete device authorization: %w", err)
+	}
+	return completed == 1, nil
+}
+
+// PollDeviceAuthorization reports the state of the device authorization
+// with deviceCode to the client it was issued to
+func (r *RedisClient) PollDeviceAuthorization(ctx context.Context, deviceCode, clientID string) (*DevicePoll, error) {
+	res, err := pollDeviceScript.Run(ctx, r.client,
+		[]string{r.deviceKey(hashToken(deviceCode))},
+		clientID, time.Now().UnixMilli(), deviceSlowDownStep.Milliseconds(),
+	).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll device authorization: %w", err)
+	}
+
+	poll := &DevicePoll{Status: DeviceStatus(res[0])}
+	if len(res) > 1 {
+		interval, _ := strconv.ParseInt(res[1], 10, 64)
+		poll.Interval = time.Duration(interval) * time.Millisecond
+	}
+	if poll.Status == DeviceApproved {
+		var user UserAuthorization
+		if err := json.Unmarshal([]byte(res[2]), &user); err != nil {
+			return nil, fmt.Errorf("failed to decode user authorization: %w", err)
+		}
+		poll.User = &user
+		poll.Scope = res[3]
+	}
+	return poll, nil
+}
+
+func (r *RedisClient) deviceKey(deviceHash string) string {
+	return fmt.Sprintf("%soidc:device:%s", r.config.Redis.KeyPrefix, deviceHash)
+}
+
+func (r *RedisClient) deviceUserCodeKey(userCode string) string {
+	return fmt.Sprintf("%soidc:device_user:%s", r.config.Redis.KeyPrefix, userCode)
+}
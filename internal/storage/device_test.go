@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func createTestDevice(t *testing.T, r *RedisClient, userCode string) string {
+	t.Helper()
+	device := &DeviceAuthorization{
+		ClientID:  "tv",
+		Scope:     "openid phone",
+		UserCode:  userCode,
+		Interval:  5 * time.Second,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+	}
+	deviceCode, created, err := r.CreateDeviceAuthorization(context.Background(), device)
+	if err != nil || !created {
+		t.Fatalf("CreateDeviceAuthorization = %v, %v, want created", created, err)
+	}
+	return deviceCode
+}
+
+// setDeviceField overwrites a field of the stored device authorization, to
+// move its clock without waiting
+func setDeviceField(r *RedisClient, mr *miniredis.Miniredis, deviceCode, field string, at time.Time) {
+	mr.HSet(r.deviceKey(hashToken(deviceCode)), field, strconv.FormatInt(at.UnixMilli(), 10))
+}
+
+func pollDevice(t *testing.T, r *RedisClient, deviceCode, clientID string) *DevicePoll {
+	t.Helper()
+	poll, err := r.PollDeviceAuthorization(context.Background(), deviceCode, clientID)
+	if err != nil {
+		t.Fatalf("PollDeviceAuthorization: %v", err)
+	}
+	return poll
+}
+
+func TestPollDeviceAuthorizationSlowDown(t *testing.T) {
+	r, mr := newTestClient(t)
+	deviceCode := createTestDevice(t, r, "BCDFGHJK")
+
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DevicePending || poll.Interval != 5*time.Second {
+		t.Fatalf("first poll = %+v, want pending every 5s", poll)
+	}
+
+	// Polling again within the interval slows the device down, and each
+	// early poll adds another step
+	poll := pollDevice(t, r, deviceCode, "tv")
+	if poll.Status != DeviceSlowDown || poll.Interval != 10*time.Second {
+		t.Fatalf("early poll = %+v, want slow_down to 10s", poll)
+	}
+	poll = pollDevice(t, r, deviceCode, "tv")
+	if poll.Status != DeviceSlowDown || poll.Interval != 15*time.Second {
+		t.Fatalf("second early poll = %+v, want slow_down to 15s", poll)
+	}
+
+	// Waiting less than the new interval is still too early
+	setDeviceField(r, mr, deviceCode, "last_polled_at", time.Now().Add(-10*time.Second))
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DeviceSlowDown {
+		t.Fatalf("poll after 10s = %+v, want slow_down", poll)
+	}
+
+	setDeviceField(r, mr, deviceCode, "last_polled_at", time.Now().Add(-time.Minute))
+	poll = pollDevice(t, r, deviceCode, "tv")
+	if poll.Status != DevicePending || poll.Interval != 20*time.Second {
+		t.Errorf("poll after the interval = %+v, want pending every 20s", poll)
+	}
+}
+
+func TestPollDeviceAuthorizationApproved(t *testing.T) {
+	r, _ := newTestClient(t)
+	ctx := context.Background()
+	deviceCode := createTestDevice(t, r, "BCDFGHJK")
+
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DevicePending {
+		t.Fatalf("poll = %+v, want pending", poll)
+	}
+
+	device, err := r.GetDeviceAuthorization(ctx, "BCDFGHJK")
+	if err != nil || device == nil || device.ClientID != "tv" {
+		t.Fatalf("GetDeviceAuthorization = %+v, %v, want the pending device", device, err)
+	}
+
+	user := &UserAuthorization{Phone: "+15550100", SessionID: "s1"}
+	approved, err := r.ApproveDeviceAuthorization(ctx, "BCDFGHJK", user)
+	if err != nil || !approved {
+		t.Fatalf("ApproveDeviceAuthorization = %v, %v, want approved", approved, err)
+	}
+	// The user code is retired once used
+	if approved, _ := r.ApproveDeviceAuthorization(ctx, "BCDFGHJK", user); approved {
+		t.Error("user code approved twice")
+	}
+	if device, _ := r.GetDeviceAuthorization(ctx, "BCDFGHJK"); device != nil {
+		t.Errorf("GetDeviceAuthorization after approval = %+v, want nil", device)
+	}
+
+	// An approval is reported even within the polling interval, once
+	poll := pollDevice(t, r, deviceCode, "tv")
+	if poll.Status != DeviceApproved || poll.Scope != "openid phone" || poll.User == nil || poll.User.Phone != "+15550100" || poll.User.SessionID != "s1" {
+		t.Fatalf("poll after approval = %+v, want approved for +15550100", poll)
+	}
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DeviceNotFound {
+		t.Errorf("poll after redeeming = %+v, want not_found", poll)
+	}
+}
+
+func TestPollDeviceAuthorizationDenied(t *testing.T) {
+	r, _ := newTestClient(t)
+	ctx := context.Background()
+	deviceCode := createTestDevice(t, r, "BCDFGHJK")
+
+	denied, err := r.DenyDeviceAuthorization(ctx, "BCDFGHJK")
+	if err != nil || !denied {
+		t.Fatalf("DenyDeviceAuthorization = %v, %v, want denied", denied, err)
+	}
+	if approved, _ := r.ApproveDeviceAuthorization(ctx, "BCDFGHJK", &UserAuthorization{Phone: "+15550100"}); approved {
+		t.Error("denied device approved")
+	}
+
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DeviceDenied || poll.User != nil {
+		t.Fatalf("poll after denial = %+v, want denied", poll)
+	}
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DeviceNotFound {
+		t.Errorf("poll after reporting the denial = %+v, want not_found", poll)
+	}
+}
+
+func TestPollDeviceAuthorizationExpired(t *testing.T) {
+	r, mr := newTestClient(t)
+	ctx := context.Background()
+	deviceCode := createTestDevice(t, r, "BCDFGHJK")
+
+	setDeviceField(r, mr, deviceCode, "expires_at", time.Now().Add(-time.Second))
+
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DeviceExpired {
+		t.Fatalf("poll = %+v, want expired", poll)
+	}
+	// Expired devices can no longer be approved, and keep reporting that
+	// they expired
+	if approved, _ := r.ApproveDeviceAuthorization(ctx, "BCDFGHJK", &UserAuthorization{Phone: "+15550100"}); approved {
+		t.Error("expired device approved")
+	}
+	if device, _ := r.GetDeviceAuthorization(ctx, "BCDFGHJK"); device != nil {
+		t.Errorf("GetDeviceAuthorization = %+v, want nil for an expired device", device)
+	}
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DeviceExpired {
+		t.Errorf("second poll = %+v, want expired", poll)
+	}
+}
+
+func TestPollDeviceAuthorizationOtherClient(t *testing.T) {
+	r, _ := newTestClient(t)
+	deviceCode := createTestDevice(t, r, "BCDFGHJK")
+
+	if poll := pollDevice(t, r, deviceCode, "other"); poll.Status != DeviceNotFound {
+		t.Errorf("poll by another client = %+v, want not_found", poll)
+	}
+	if poll := pollDevice(t, r, "unknown", "tv"); poll.Status != DeviceNotFound {
+		t.Errorf("poll with an unknown device code = %+v, want not_found", poll)
+	}
+	// Polls by another client do not count against the device's interval
+	if poll := pollDevice(t, r, deviceCode, "tv"); poll.Status != DevicePending {
+		t.Errorf("poll = %+v, want pending", poll)
+	}
+}
+
+func TestCreateDeviceAuthorizationUserCodeInUse(t *testing.T) {
+	r, _ := newTestClient(t)
+	createTestDevice(t, r, "BCDFGHJK")
+
+	_, created, err := r.CreateDeviceAuthorization(context.Background(), &DeviceAuthorization{
+		ClientID:  "tv",
+		UserCode:  "BCDFGHJK",
+		Interval:  5 * time.Second,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+	})
+	if err != nil || created {
+		t.Errorf("CreateDeviceAuthorization with a used user code = %v, %v, want not created", created, err)
+	}
+}
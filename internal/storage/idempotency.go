@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyStatus is the outcome of reserving an idempotency key
+type IdempotencyStatus string
+
+const (
+	// IdempotencyReserved means the key is new and the request should be processed
+	IdempotencyReserved IdempotencyStatus = "reserved"
+	// IdempotencyPending means a request with the key is still being processed
+	IdempotencyPending IdempotencyStatus = "pending"
+	// IdempotencyCompleted means the stored response should be replayed
+	IdempotencyCompleted IdempotencyStatus = "completed"
+	// IdempotencyMismatch means the key was used for a different request
+	IdempotencyMismatch IdempotencyStatus = "mismatch"
+)
+
+// IdempotencyResult is returned when an idempotency key is reserved
+type IdempotencyResult struct {
+	Status   IdempotencyStatus
+	Response []byte
+}
+
+// reserveIdempotencyKeyScript claims an idempotency key for a request, or
+// reports the state of the request that claimed it first.
+//
+// KEYS[1] idempotency key
+// ARGV[1] request fingerprint, ARGV[2] lock TTL (ms)
+var reserveIdempotencyKeyScript = redis.NewScript(`
+local fields = redis.call('HMGET', KEYS[1], 'fingerprint', 'response')
+if not fields[1] then
+	redis.call('HSET', KEYS[1], 'fingerprint', ARGV[1])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return {'reserved', ''}
+end
+if fields[1] ~= ARGV[1] then
+	return {'mismatch', ''}
+end
+if not fields[2] then
+	return {'pending', ''}
+end
+return {'completed', fields[2]}
+`)
+
+// ReserveIdempotencyKey claims key within scope for the request identified
+// by fingerprint. The claim lapses after lockTTL unless it is completed.
+func (r *RedisClient) ReserveIdempotencyKey(ctx context.Context, scope, key, fingerprint string, lockTTL time.Duration) (*IdempotencyResult, error) {
+	res, err := reserveIdempotencyKeyScript.Run(ctx, r.client,
+		[]string{r.idempotencyKey(scope, key)},
+		fingerprint, lockTTL.Milliseconds(),
+	).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	result := &IdempotencyResult{Status: IdempotencyStatus(res[0])}
+	if result.Status == IdempotencyCompleted {
+		result.Response = []byte(res[1])
+	}
+	return result, nil
+}
+
+// CompleteIdempotencyKey stores the response to replay for key until ttl elapses
+func (r *RedisClient) CompleteIdempotencyKey(ctx context.Context, scope, key string, response []byte, ttl time.Duration) error {
+	redisKey := r.idempotencyKey(scope, key)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisKey, "response", response)
+		pipe.PExpire(ctx, redisKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// ReleaseIdempotencyKey forgets key so that the request can be retried
+func (r *RedisClient) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	return r.client.Del(ctx, r.idempotencyKey(scope, key)).Err()
+}
+
+// idempotencyKey hashes the client supplied key to bound its length
+func (r *RedisClient) idempotencyKey(scope, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%sidempotency:%s:%s", r.config.Redis.KeyPrefix, scope, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// lockoutHistoryTTL is how long previous lockouts count towards progressive backoff
+const lockoutHistoryTTL = 24 * time.Hour
+
+// recordFailureScript counts a failed login attempt and, once the limit is
+// reached, locks the identifier out. Each successive lockout within the
+// history window doubles the lockout duration up to a maximum.
+//
+// KEYS[1] failure counter, KEYS[2] lockout marker, KEYS[3] lockout history
+// ARGV[1] failure window (ms), ARGV[2] max attempts, ARGV[3] base lockout (ms),
+// ARGV[4] history TTL (ms), ARGV[5] max lockout (ms)
+var recordFailureScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if failures < tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+local count = redis.call('INCR', KEYS[3])
+redis.call('PEXPIRE', KEYS[3], ARGV[4])
+local duration = tonumber(ARGV[3]) * math.pow(2, count - 1)
+if duration > tonumber(ARGV[5]) then
+	duration = tonumber(ARGV[5])
+end
+redis.call('SET', KEYS[2], count, 'PX', math.floor(duration))
+return math.floor(duration)
+`)
+
+// GetLockout returns how long identifier remains locked out, or zero if it is not
+func (r *RedisClient) GetLockout(ctx context.Context, identifier string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, r.lockoutKey(identifier)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL reports missing keys and keys without expiry as negative durations
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// RecordFailedAttempt counts a failed login for identifier and returns the
+// lockout duration if this failure triggered a lockout
+func (r *RedisClient) RecordFailedAttempt(ctx context.Context, identifier string) (time.Duration, error) {
+	security := r.config.Security
+	maxLockout := security.MaxLockoutDuration
+	if maxLockout < security.LockoutDuration {
+		maxLockout = security.LockoutDuration
+	}
+
+	keys := []string{r.failedAttemptsKey(identifier), r.lockoutKey(identifier), r.lockoutHistoryKey(identifier)}
+	ms, err := recordFailureScript.Run(ctx, r.client, keys,
+		security.LockoutDuration.Milliseconds(), security.MaxLoginAttempts, security.LockoutDuration.Milliseconds(),
+		lockoutHistoryTTL.Milliseconds(), maxLockout.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// ResetFailedAttempts clears the failure counter and lockout history after a successful login
+func (r *RedisClient) ResetFailedAttempts(ctx context.Context, identifier string) error {
+	return r.client.Del(ctx, r.failedAttemptsKey(identifier), r.lockoutHistoryKey(identifier)).Err()
+}
+
+func (r *RedisClient) failedAttemptsKey(identifier string) string {
+	return fmt.Sprintf("%slockout:failures:%s", r.config.Redis.KeyPrefix, identifier)
+}
+
+func (r *RedisClient) lockoutKey(identifier string) string {
+	return fmt.Sprintf("%slockout:until:%s", r.config.Redis.KeyPrefix, identifier)
+}
+
+func (r *RedisClient) lockoutHistoryKey(identifier string) string {
+	return fmt.Sprintf("%slockout:history:%s", r.config.Redis.KeyPrefix, identifier)
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MagicLinkStatus is the outcome of redeeming a magic link
+type MagicLinkStatus string
+
+const (
+	MagicLinkValid         MagicLinkStatus = "valid"
+	MagicLinkExpired       MagicLinkStatus = "expired"
+	MagicLinkUsed          MagicLinkStatus = "used"
+	MagicLinkNotFound      MagicLinkStatus = "not_found"
+	MagicLinkStateMismatch MagicLinkStatus = "state_mismatch"
+)
+
+// MagicLink is a single-use sign-in link bound to the browser that requested it
+type MagicLink struct {
+	Token     string
+	State     string
+	Recipient string
+	Channel   string
+	ExpiresAt time.Time
+}
+
+// MagicLinkResult is returned when a magic link is redeemed
+type MagicLinkResult struct {
+	Status    MagicLinkStatus
+	Recipient string
+	Channel   string
+}
+
+// consumeMagicLinkScript redeems a magic link if it is unexpired and the
+// presented state matches the one issued to the requesting browser. A state
+// mismatch leaves the link intact so a forwarded link cannot burn it.
+//
+// KEYS[1] link hash, KEYS[2] used marker
+// ARGV[1] state hash, ARGV[2] now (ms), ARGV[3] used marker TTL (ms)
+var consumeMagicLinkScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return {'used', '', ''}
+end
+local fields = redis.call('HMGET', KEYS[1], 'recipient', 'channel', 'state_hash', 'expires_at')
+if not fields[1] then
+	return {'not_found', '', ''}
+end
+if tonumber(fields[4]) <= tonumber(ARGV[2]) then
+	return {'expired', '', ''}
+end
+if fields[3] ~= ARGV[1] then
+	return {'state_mismatch', '', ''}
+end
+redis.call('DEL', KEYS[1])
+redis.call('SET', KEYS[2], '1', 'PX', ARGV[3])
+return {'valid', fields[1], fields[2]}
+`)
+
+// CreateMagicLink issues a magic link token and browser state for recipient.
+// Only hashes of the token and state are stored.
+func (r *RedisClient) CreateMagicLink(ctx context.Context, recipient, channel string, expiry time.Duration) (*MagicLink, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate magic link token: %w", err)
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate magic link state: %w", err)
+	}
+
+	expiresAt := time.Now().Add(expiry)
+	key := r.magicLinkKey(token)
+
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"recipient":  recipient,
+		"channel":    channel,
+		"state_hash": hashToken(state),
+		"expires_at": expiresAt.UnixMilli(),
+	})
+	pipe.Expire(ctx, key, 2*expiry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return &MagicLink{
+		Token:     token,
+		State:     state,
+		Recipient: recipient,
+		Channel:   channel,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ConsumeMagicLink redeems token if state matches the browser it was issued to
+func (r *RedisClient) ConsumeMagicLink(ctx context.Context, token, state string) (*MagicLinkResult, error) {
+	keys := []string{r.magicLinkKey(token), r.magicLinkUsedKey(token)}
+	usedTTL := 2 * r.config.Security.OTPExpiry
+
+	res, err := consumeMagicLinkScript.Run(ctx, r.client, keys,
+		hashToken(state), time.Now().UnixMilli(), usedTTL.Milliseconds()).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 3 {
+		return nil, fmt.Errorf("unexpected magic link script result: %v", res)
+	}
+
+	return &MagicLinkResult{
+		Status:    MagicLinkStatus(res[0]),
+		Recipient: res[1],
+		Channel:   res[2],
+	}, nil
+}
+
+func (r *RedisClient) magicLinkKey(token string) string {
+	return fmt.Sprintf("%smagic:link:%s", r.config.Redis.KeyPrefix, hashToken(token))
+}
+
+func (r *RedisClient) magicLinkUsedKey(token string) string {
+	return fmt.Sprintf("%smagic:used:%s", r.config.Redis.KeyPrefix, hashToken(token))
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a high-entropy token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UserAuthorization records the signed in user who let an OpenID Connect
+// client sign them in, and how they signed in
+type UserAuthorization struct {
+	Phone         string    `json:"phone,omitempty"`
+	Email         string    `json:"email,omitempty"`
+	TwoFAEnabled  bool      `json:"twofa_enabled"`
+	TwoFAVerified bool      `json:"twofa_verified"`
+	SessionID     string    `json:"sid,omitempty"`
+	AuthTime      time.Time `json:"auth_time"`
+	AuthMethods   []string  `json:"amr,omitempty"`
+}
+
+// AuthorizationCode is an OpenID Connect authorization code waiting to be
+// exchanged for tokens
+type AuthorizationCode struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	Nonce               string `json:"nonce,omitempty"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	UserAuthorization
+}
+
+// CreateAuthorizationCode stores code and returns the code string. Only its
+// hash is stored.
+func (r *RedisClient) CreateAuthorizationCode(ctx context.Context, code *AuthorizationCode, ttl time.Duration) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %w", err)
+	}
+
+	data, err := json.Marshal(code)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode authorization code: %w", err)
+	}
+	if err := r.client.Set(ctx, r.authorizationCodeKey(token), data, ttl).Err(); err != nil {
+		return "", fmt.Errorf("failed to store authorization code: %w", err)
+	}
+	return token, nil
+}
+
+// ConsumeAuthorizationCode removes and returns the authorization code, or
+// nil if it is unknown, expired or already used
+func (r *RedisClient) ConsumeAuthorizationCode(ctx context.Context, token string) (*AuthorizationCode, error) {
+	data, err := r.client.GetDel(ctx, r.authorizationCodeKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
+
+	var code AuthorizationCode
+	if err := json.Unmarshal(data, &code); err != nil {
+		return nil, fmt.Errorf("failed to decode authorization code: %w", err)
+	}
+	return &code, nil
+}
+
+func (r *RedisClient) authorizationCodeKey(token string) string {
+	return fmt.Sprintf("%soidc:code:%s", r.config.Redis.KeyPrefix, hashToken(token))
+}
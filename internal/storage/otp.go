@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OTPChallengeStatus is the outcome of verifying an OTP challenge
+type OTPChallengeStatus string
+
+const (
+	OTPChallengeValid    OTPChallengeStatus = "valid"
+	OTPChallengeInvalid  OTPChallengeStatus = "invalid"
+	OTPChallengeExpired  OTPChallengeStatus = "expired"
+	OTPChallengeUsed     OTPChallengeStatus = "used"
+	OTPChallengeNotFound OTPChallengeStatus = "not_found"
+	OTPChallengeLocked   OTPChallengeStatus = "locked"
+)
+
+// OTPChallenge represents an OTP that has been issued and is awaiting verification.
+// Recipient is the phone number or email address the code was sent to.
+type OTPChallenge struct {
+	ID        string
+	Recipient string
+	ExpiresAt time.Time
+}
+
+// OTPChallengeResult is returned when a challenge is verified. Attempts is
+// zero when the submitted code was not compared.
+type OTPChallengeResult struct {
+	Status   OTPChallengeStatus
+	Attempts int64
+}
+
+// consumeOTPScript checks a submitted code against a stored challenge and
+// consumes the challenge on success. It runs atomically so a challenge can
+// only ever be redeemed once, and locks the challenge once the maximum
+// number of wrong codes has been submitted.
+//
+// KEYS[1] challenge hash, KEYS[2] used marker
+// ARGV[1] recipient, ARGV[2] code hash, ARGV[3] now (ms), ARGV[4] used marker TTL (ms),
+// ARGV[5] max attempts
+var consumeOTPScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 then
+	return {'used', 0}
+end
+local fields = redis.call('HMGET', KEYS[1], 'recipient', 'code_hash', 'expires_at', 'locked')
+if not fields[1] or fields[1] ~= ARGV[1] then
+	return {'not_found', 0}
+end
+if fields[4] == '1' then
+	return {'locked', 0}
+end
+if tonumber(fields[3]) <= tonumber(ARGV[3]) then
+	return {'expired', 0}
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if fields[2] ~= ARGV[2] then
+	if attempts >= tonumber(ARGV[5]) then
+		redis.call('HSET', KEYS[1], 'locked', '1')
+		return {'locked', attempts}
+	end
+	return {'invalid', attempts}
+end
+redis.call('DEL', KEYS[1])
+redis.call('SET', KEYS[2], '1', 'PX', ARGV[4])
+return {'valid', attempts}
+`)
+
+// CreateOTPChallenge stores a hashed OTP for recipient and returns the challenge
+// the client must present when verifying it
+func (r *RedisClient) CreateOTPChallenge(ctx context.Context, recipient, code string, expiry time.Duration) (*OTPChallenge, error) {
+	id, err := generateChallengeID()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(expiry)
+	key := r.otpChallengeKey(id)
+
+	// Keep the record around past its expiry so late submissions can be
+	// reported as expired rather than unknown
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"recipient":  recipient,
+		"code_hash":  hashOTP(id, recipient, code),
+		"expires_at": expiresAt.UnixMilli(),
+		"attempts":   0,
+	})
+	pipe.Expire(ctx, key, 2*expiry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return &OTPChallenge{
+		ID:        id,
+		Recipient: recipient,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// VerifyOTPChallenge checks code against the challenge and consumes it when it
+// matches. After security.max_login_attempts wrong codes the challenge is locked.
+func (r *RedisClient) VerifyOTPChallenge(ctx context.Context, id, recipient, code string) (*OTPChallengeResult, error) {
+	keys := []string{r.otpChallengeKey(id), r.otpUsedKey(id)}
+	usedTTL := 2 * r.config.Security.OTPExpiry
+
+	res, err := consumeOTPScript.Run(ctx, r.client, keys,
+		recipient, hashOTP(id, recipient, code), time.Now().UnixMilli(), usedTTL.Milliseconds(),
+		r.config.Security.MaxLoginAttempts).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2 {
+		return nil, fmt.Errorf("unexpected OTP script result: %v", res)
+	}
+
+	status, _ := res[0].(string)
+	attempts, _ := res[1].(int64)
+	return &OTPChallengeResult{
+		Status:   OTPChallengeStatus(status),
+		Attempts: attempts,
+	}, nil
+}
+
+func (r *RedisClient) otpChallengeKey(id string) string {
+	return fmt.Sprintf("%sotp:challenge:%s", r.config.Redis.KeyPrefix, id)
+}
+
+func (r *RedisClient) otpUsedKey(id string) string {
+	return fmt.Sprintf("%sotp:used:%s", r.config.Redis.KeyPrefix, id)
+}
+
+// generateChallengeID returns a random, URL-safe challenge identifier
+func generateChallengeID() (string, error) {
+	id, err := randomToken(18)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate challenge ID: %w", err)
+	}
+	return id, nil
+}
+
+// hashOTP binds the code to its challenge and recipient so stored hashes are not reusable
+func hashOTP(id, recipient, code string) string {
+	sum := sha256.Sum256([]byte(id + "." + recipient + "." + code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SetChannelPreference stores the channel phone prefers to receive codes on.
+// An empty channel clears the preference.
+func (r *RedisClient) SetChannelPreference(ctx context.Context, phone, channel string) error {
+	key := r.channelPreferenceKey(phone)
+	if channel == "" {
+		return r.client.Del(ctx, key).Err()
+	}
+	return r.client.Set(ctx, key, channel, 0).Err() // Kept until changed
+}
+
+// GetChannelPreference returns the channel phone prefers, or "" if none is set
+func (r *RedisClient) GetChannelPreference(ctx context.Context, phone string) (string, error) {
+	channel, err := r.client.Get(ctx, r.channelPreferenceKey(phone)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return channel, err
+}
+
+func (r *RedisClient) channelPreferenceKey(phone string) string {
+	return fmt.Sprintf("%schannel:preference:%s", r.config.Redis.KeyPrefix, phone)
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lmousom/passless-auth/internal/config"
+)
+
+// newTestClient returns a RedisClient backed by an in-memory Redis
+func newTestClient(t *testing.T) (*RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	cfg := &config.Config{}
+	cfg.Redis.Host, cfg.Redis.Port, _ = strings.Cut(mr.Addr(), ":")
+	cfg.Redis.KeyPrefix = "test:"
+
+	client, err := NewRedisClient(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, mr
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RefreshStatus is the outcome of redeeming a refresh token
+type RefreshStatus string
+
+const (
+	RefreshValid    RefreshStatus = "valid"
+	RefreshNotFound RefreshStatus = "not_found"
+	// RefreshReused means the token had already been rotated; its whole
+	// family has been revoked
+	RefreshReused  RefreshStatus = "reused"
+	RefreshRevoked RefreshStatus = "revoked"
+	RefreshExpired RefreshStatus = "expired"
+)
+
+// SessionDevice describes the client a session was started from
+type SessionDevice struct {
+	Name      string
+	UserAgent string
+	IP        string
+}
+
+// RefreshSession is a token family: the chain of refresh tokens issued for
+// one sign-in. Each token can be redeemed once for the next one, until the
+// family reaches ExpiresAt or is revoked.
+type RefreshSession struct {
+	ID            string
+	Phone         string
+	Email         string
+	TwoFAEnabled  bool
+	TwoFAVerified bool
+	// Generation is the user's token generation when the family was created
+	Generation int64
+	Device     SessionDevice
+	// AuthMethods are the factors the user signed in with, such as otp
+	AuthMethods []string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiresAt   time.Time
+	Revoked     bool
+}
+
+// Identifier returns the phone number or email address of the session's user
+func (s *RefreshSession) Identifier() string {
+	if s.Phone != "" {
+		return s.Phone
+	}
+	return s.Email
+}
+
+// RefreshResult is returned when a refresh token is rotated. FamilyID is set
+// unless Status is RefreshNotFound; Token and Session are set when Status is
+// RefreshValid.
+type RefreshResult struct {
+	Status         RefreshStatus
+	FamilyID       string
+	Session        *RefreshSession
+	Token          string
+	TokenExpiresAt time.Time
+}
+
+// rotateRefreshTokenScript redeems a refresh token for a new one in the same
+// family. Redeemed tokens are kept, marked used, until they would have
+// expired so that presenting one again revokes the family.
+//
+// KEYS[1] token hash, KEYS[2] family hash, KEYS[3] new token hash
+// ARGV[1] family ID, ARGV[2] now (ms), ARGV[3] token TTL (ms), ARGV[4] client IP
+var rotateRefreshTokenScript = redis.NewScript(`
+local family = redis.call('HMGET', KEYS[2], 'expires_at', 'revoked')
+if not family[1] then
+	return {'not_found'}
+end
+local token = redis.call('HMGET', KEYS[1], 'family', 'used')
+if not token[1] or token[1] ~= ARGV[1] then
+	return {'not_found'}
+end
+if family[2] == '1' then
+	return {'revoked'}
+end
+if token[2] == '1' then
+	redis.call('HSET', KEYS[2], 'revoked', '1')
+	return {'reused'}
+end
+local remaining = tonumber(family[1]) - tonumber(ARGV[2])
+if remaining <= 0 then
+	return {'expired'}
+end
+redis.call('HSET', KEYS[1], 'used', '1')
+redis.call('HSET', KEYS[2], 'last_seen_at', ARGV[2])
+if ARGV[4] ~= '' then
+	redis.call('HSET', KEYS[2], 'ip', ARGV[4])
+end
+local ttl = math.min(tonumber(ARGV[3]), remaining)
+redis.call('HSET', KEYS[3], 'family', ARGV[1])
+redis.call('PEXPIRE', KEYS[3], ttl)
+return {'valid', tostring(ttl)}
+`)
+
+// CreateRefreshSession starts a token family for session, records it in the
+// user's session list, and returns its first refresh token. Only hashes of
+// refresh tokens are stored.
+func (r *RedisClient) CreateRefreshSession(ctx context.Context, session *RefreshSession, tokenTTL time.Duration) (string, time.Time, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	session.ID = id
+
+	tokenExpiresAt := time.Now().Add(tokenTTL)
+	if tokenExpiresAt.After(session.ExpiresAt) {
+		tokenExpiresAt = session.ExpiresAt
+	}
+
+	session.LastSeenAt = session.CreatedAt
+
+	familyKey := r.refreshFamilyKey(id)
+	tokenKey := r.refreshTokenKey(hashToken(token))
+	indexKey := r.userSessionsKey(session.Identifier())
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, familyKey, map[string]interface{}{
+			"phone":          session.Phone,
+			"email":          session.Email,
+			"twofa_enabled":  boolFlag(session.TwoFAEnabled),
+			"twofa_verified": boolFlag(session.TwoFAVerified),
+			"generation":     session.Generation,
+			"device_name":    session.Device.Name,
+			"user_agent":     session.Device.UserAgent,
+			"ip":             session.Device.IP,
+			"auth_methods":   strings.Join(session.AuthMethods, ","),
+			"created_at":     session.CreatedAt.UnixMilli(),
+			"last_seen_at":   session.LastSeenAt.UnixMilli(),
+			"expires_at":     session.ExpiresAt.UnixMilli(),
+		})
+		pipe.PExpireAt(ctx, familyKey, session.ExpiresAt)
+		pipe.HSet(ctx, tokenKey, "family", id)
+		pipe.PExpireAt(ctx, tokenKey, tokenExpiresAt)
+		// Sessions all last the same time, so the newest expires last
+		pipe.ZAdd(ctx, indexKey, redis.Z{Score: float64(session.ExpiresAt.UnixMilli()), Member: id})
+		pipe.PExpireAt(ctx, indexKey, session.ExpiresAt)
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store refresh session: %w", err)
+	}
+	return token, tokenExpiresAt, nil
+}
+
+// RotateRefreshToken redeems token for a new refresh token in its family and
+// records ip as where the session was last seen
+func (r *RedisClient) RotateRefreshToken(ctx context.Context, token string, tokenTTL time.Duration, ip string) (*RefreshResult, error) {
+	tokenKey := r.refreshTokenKey(hashToken(token))
+	familyID, err := r.client.HGet(ctx, tokenKey, "family").Result()
+	if err == redis.Nil {
+		return &RefreshResult{Status: RefreshNotFound}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	next, err := randomToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	now := time.Now()
+	res, err := rotateRefreshTokenScript.Run(ctx, r.client,
+		[]string{tokenKey, r.refreshFamilyKey(familyID), r.refreshTokenKey(hashToken(next))},
+		familyID, now.UnixMilli(), tokenTTL.Milliseconds(), ip,
+	).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	result := &RefreshResult{Status: RefreshStatus(res[0])}
+	if result.Status == RefreshNotFound {
+		return result, nil
+	}
+	result.FamilyID = familyID
+	if result.Status != RefreshValid {
+		return result, nil
+	}
+
+	ttl, _ := strconv.ParseInt(res[1], 10, 64)
+	session, err := r.GetRefreshSession(ctx, familyID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		// Expired since it was rotated
+		return &RefreshResult{Status: RefreshExpired, FamilyID: familyID}, nil
+	}
+	result.Session = session
+	result.Token = next
+	result.TokenExpiresAt = now.Add(time.Duration(ttl) * time.Millisecond)
+	return result, nil
+}
+
+// revokeRefreshSessionScript marks a token family revoked without recreating
+// it once it has expired.
+//
+// KEYS[1] family hash
+var revokeRefreshSessionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'revoked', '1')
+return 1
+`)
+
+// RevokeRefreshToken revokes the family token belongs to and returns the
+// family ID, or "" if the token is unknown
+func (r *RedisClient) RevokeRefreshToken(ctx context.Context, token string) (string, error) {
+	familyID, err := r.client.HGet(ctx, r.refreshTokenKey(hashToken(token)), "family").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return familyID, r.RevokeRefreshSession(ctx, familyID)
+}
+
+// RevokeRefreshSession revokes a token family so none of its refresh tokens
+// can be redeemed
+func (r *RedisClient) RevokeRefreshSession(ctx context.Context, familyID string) error {
+	return revokeRefreshSessionScript.Run(ctx, r.client, []string{r.refreshFamilyKey(familyID)}).Err()
+}
+
+// GetRefreshSession returns the token family with id, or nil if it has expired
+func (r *RedisClient) GetRefreshSession(ctx context.Context, id string) (*RefreshSession, error) {
+	fields, err := r.client.HGetAll(ctx, r.refreshFamilyKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh session: %w", err)
+	}
+	return parseRefreshSession(id, fields), nil
+}
+
+// parseRefreshSession decodes a family hash, returning nil if it is empty
+func parseRefreshSession(id string, fields map[string]string) *RefreshSession {
+	if fields["expires_at"] == "" {
+		return nil
+	}
+
+	session := &RefreshSession{
+		ID:            id,
+		Phone:         fields["phone"],
+		Email:         fields["email"],
+		TwoFAEnabled:  fields["twofa_enabled"] == "1",
+		TwoFAVerified: fields["twofa_verified"] == "1",
+		Device: SessionDevice{
+			Name:      fields["device_name"],
+			UserAgent: fields["user_agent"],
+			IP:        fields["ip"],
+		},
+		CreatedAt:  unixMilliField(fields["created_at"]),
+		LastSeenAt: unixMilliField(fields["last_seen_at"]),
+		ExpiresAt:  unixMilliField(fields["expires_at"]),
+		Revoked:    fields["revoked"] == "1",
+	}
+	session.Generation, _ = strconv.ParseInt(fields["generation"], 10, 64)
+	if methods := fields["auth_methods"]; methods != "" {
+		session.AuthMethods = strings.Split(methods, ",")
+	}
+	return session
+}
+
+func (r *RedisClient) refreshTokenKey(tokenHash string) string {
+	return fmt.Sprintf("%srefresh:token:%s", r.config.Redis.KeyPrefix, tokenHash)
+}
+
+func (r *RedisClient) refreshFamilyKey(id string) string {
+	return fmt.Sprintf("%srefresh:family:%s", r.config.Redis.KeyPrefix, id)
+}
+
+func boolFlag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
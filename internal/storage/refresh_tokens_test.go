@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRotateRefreshTokenReuseRevokesFamily(t *testing.T) {
+	r, _ := newTestClient(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	session := &RefreshSession{Phone: "+15550100", CreatedAt: now, ExpiresAt: now.Add(24 * time.Hour)}
+	token, _, err := r.CreateRefreshSession(ctx, session, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateRefreshSession: %v", err)
+	}
+
+	first, err := r.RotateRefreshToken(ctx, token, time.Hour, "")
+	if err != nil || first.Status != RefreshValid {
+		t.Fatalf("first rotation = %+v, %v, want valid", first, err)
+	}
+
+	reused, err := r.RotateRefreshToken(ctx, token, time.Hour, "")
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if reused.Status != RefreshReused || reused.FamilyID != session.ID {
+		t.Fatalf("reuse = %+v, want reused in family %s", reused, session.ID)
+	}
+
+	// The token issued by the first rotation is revoked along with its family
+	next, err := r.RotateRefreshToken(ctx, first.Token, time.Hour, "")
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if next.Status != RefreshRevoked {
+		t.Errorf("status = %s, want %s", next.Status, RefreshRevoked)
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RevokeToken adds a token ID to the revocation list until the token expires
+func (r *RedisClient) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := r.client.Set(ctx, r.revokedTokenKey(jti), "1", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// RevokeSessionTokens revokes every access token carrying session id until
+// expiresAt, including tokens issued after the call, so it is only used for
+// sessions that have ended. expiresAt is when the last token issued before
+// the session ended expires.
+func (r *RedisClient) RevokeSessionTokens(ctx context.Context, id string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := r.client.Set(ctx, r.revokedSessionKey(id), "1", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke session tokens: %w", err)
+	}
+	return nil
+}
+
+// TokenRevocation reports whether a token ID, or the session the token was
+// issued for, was revoked, along with the current token generation of
+// identifier
+func (r *RedisClient) TokenRevocation(ctx context.Context, jti, sessionID, identifier string) (bool, int64, error) {
+	keys := []string{r.revokedTokenKey(jti)}
+	if sessionID != "" {
+		keys = append(keys, r.revokedSessionKey(sessionID))
+	}
+
+	var revoked *redis.IntCmd
+	var generation *redis.StringCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		revoked = pipe.Exists(ctx, keys...)
+		generation = pipe.Get(ctx, r.tokenGenerationKey(identifier))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, 0, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+
+	gen, err := generation.Int64()
+	if err != nil && err != redis.Nil {
+		return false, 0, fmt.Errorf("failed to get token generation: %w", err)
+	}
+	return revoked.Val() > 0, gen, nil
+}
+
+// GetTokenGeneration returns the token generation of identifier. Tokens
+// issued in an earlier generation are revoked.
+func (r *RedisClient) GetTokenGeneration(ctx context.Context, identifier string) (int64, error) {
+	gen, err := r.client.Get(ctx, r.tokenGenerationKey(identifier)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get token generation: %w", err)
+	}
+	return gen, nil
+}
+
+// IncrementTokenGeneration revokes every token issued to identifier so far
+// and returns the new generation
+func (r *RedisClient) IncrementTokenGeneration(ctx context.Context, identifier string) (int64, error) {
+	gen, err := r.client.Incr(ctx, r.tokenGenerationKey(identifier)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment token generation: %w", err)
+	}
+	return gen, nil
+}
+
+func (r *RedisClient) revokedTokenKey(jti string) string {
+	return fmt.Sprintf("%stoken:revoked:%s", r.config.Redis.KeyPrefix, jti)
+}
+
+func (r *RedisClient) revokedSessionKey(id string) string {
+	return fmt.Sprintf("%stoken:revoked_session:%s", r.config.Redis.KeyPrefix, id)
+}
+
+func (r *RedisClient) tokenGenerationKey(identifier string) string {
+	return fmt.Sprintf("%stoken:generation:%s", r.config.Redis.KeyPrefix, identifier)
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ListUserSessions returns the active sessions of identifier, most recently
+// seen first. Expired and revoked sessions, and sessions from before the
+// user's current token generation, are dropped from the list.
+func (r *RedisClient) ListUserSessions(ctx context.Context, identifier string, generation int64) ([]*RefreshSession, error) {
+	indexKey := r.userSessionsKey(identifier)
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := r.client.ZRemRangeByScore(ctx, indexKey, "-inf", now).Err(); err != nil {
+		return nil, fmt.Errorf("failed to prune sessions: %w", err)
+	}
+
+	ids, err := r.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(ids))
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, r.refreshFamilyKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+
+	var sessions []*RefreshSession
+	var stale []interface{}
+	for i, id := range ids {
+		session := parseRefreshSession(id, cmds[i].Val())
+		if session == nil || session.Revoked || session.Generation < generation {
+			stale = append(stale, id)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if len(stale) > 0 {
+		if err := r.client.ZRem(ctx, indexKey, stale...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to prune sessions: %w", err)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// RevokeUserSessions revokes every session of identifier and clears its
+// session list
+func (r *RedisClient) RevokeUserSessions(ctx context.Context, identifier string) error {
+	indexKey := r.userSessionsKey(identifier)
+	ids, err := r.client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for _, id := range ids {
+		if err := r.RevokeRefreshSession(ctx, id); err != nil {
+			return fmt.Errorf("failed to revoke session: %w", err)
+		}
+	}
+	if err := r.client.Del(ctx, indexKey).Err(); err != nil {
+		return fmt.Errorf("failed to clear sessions: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSession revokes the session with id if it belongs to identifier.
+// It reports whether the session was found.
+func (r *RedisClient) RevokeUserSession(ctx context.Context, identifier, id string) (bool, error) {
+	// Removing the session from the index first means only one caller finds it
+	removed, err := r.client.ZRem(ctx, r.userSessionsKey(identifier), id).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if removed == 0 {
+		return false, nil
+	}
+
+	if err := r.RevokeRefreshSession(ctx, id); err != nil {
+		return false, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return true, nil
+}
+
+func (r *RedisClient) userSessionsKey(identifier string) string {
+	return fmt.Sprintf("%ssessions:user:%s", r.config.Redis.KeyPrefix, identifier)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func createTestSession(t *testing.T, r *RedisClient, phone string, generation int64) *RefreshSession {
+	t.Helper()
+	now := time.Now()
+	session := &RefreshSession{
+		Phone:      phone,
+		Generation: generation,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(24 * time.Hour),
+	}
+	if _, _, err := r.CreateRefreshSession(context.Background(), session, time.Hour); err != nil {
+		t.Fatalf("CreateRefreshSession: %v", err)
+	}
+	return session
+}
+
+func TestListUserSessionsDropsEarlierGenerations(t *testing.T) {
+	r, mr := newTestClient(t)
+	ctx := context.Background()
+
+	old := createTestSession(t, r, "+15550100", 0)
+	current := createTestSession(t, r, "+15550100", 1)
+
+	sessions, err := r.ListUserSessions(ctx, "+15550100", 1)
+	if err != nil {
+		t.Fatalf("ListUserSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != current.ID {
+		t.Fatalf("sessions = %v, want only %s", sessions, current.ID)
+	}
+	if members, _ := mr.ZMembers(r.userSessionsKey("+15550100")); len(members) != 1 {
+		t.Errorf("session list = %v, want %s pruned", members, old.ID)
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	r, mr := newTestClient(t)
+	ctx := context.Background()
+
+	first := createTestSession(t, r, "+15550100", 0)
+	second := createTestSession(t, r, "+15550100", 0)
+	other := createTestSession(t, r, "+15550199", 0)
+
+	if err := r.RevokeUserSessions(ctx, "+15550100"); err != nil {
+		t.Fatalf("RevokeUserSessions: %v", err)
+	}
+	for _, session := range []*RefreshSession{first, second} {
+		got, err := r.GetRefreshSession(ctx, session.ID)
+		if err != nil {
+			t.Fatalf("GetRefreshSession: %v", err)
+		}
+		if !got.Revoked {
+			t.Errorf("session %s is not revoked", session.ID)
+		}
+	}
+	if mr.Exists(r.userSessionsKey("+15550100")) {
+		t.Error("session list was not cleared")
+	}
+
+	sessions, err := r.ListUserSessions(ctx, "+15550199", 0)
+	if err != nil {
+		t.Fatalf("ListUserSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != other.ID {
+		t.Errorf("other user's sessions = %v, want %s", sessions, other.ID)
+	}
+}
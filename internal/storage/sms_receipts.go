@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SMSMessage is a message accepted by an SMS provider, tracked until its
+// delivery receipt arrives
+type SMSMessage struct {
+	Provider   string
+	MessageID  string
+	DeliveryID string
+	Region     string
+	Status     string
+	Carrier    string
+	ErrorCode  string
+	SentAt     time.Time
+	UpdatedAt  time.Time
+}
+
+// updateSMSMessageScript applies a delivery receipt to a tracked message.
+// Receipts can arrive out of order or more than once, so once a final
+// status is recorded later receipts are ignored.
+//
+// KEYS[1] message key
+// ARGV[1] status, ARGV[2] "1" if the status is final, ARGV[3] now (ms),
+// ARGV[4] carrier, ARGV[5] error code
+//
+// Returns -1 for an unknown message, 1 if this receipt made the message
+// final and 0 otherwise
+var updateSMSMessageScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+if redis.call('HEXISTS', KEYS[1], 'final_at') == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'status', ARGV[1], 'updated_at', ARGV[3])
+if ARGV[4] ~= '' then
+	redis.call('HSET', KEYS[1], 'carrier', ARGV[4])
+end
+if ARGV[5] ~= '' then
+	redis.call('HSET', KEYS[1], 'error_code', ARGV[5])
+end
+if ARGV[2] == '1' then
+	redis.call('HSET', KEYS[1], 'final_at', ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// annotateDeliveryScript sets fields on a delivery status without recreating
+// it once it has expired.
+//
+// KEYS[1] delivery status key
+// ARGV field, value pairs
+var annotateDeliveryScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HSET', KEYS[1], unpack(ARGV))
+`)
+
+// TrackSMSMessage records that provider accepted job's message as messageID.
+// With keepJob the job, including its encrypted code, is also kept so that
+// it can be resent through a fallback channel. It is deleted by the final
+// receipt, or when the code expires if none arrives.
+func (r *RedisClient) TrackSMSMessage(ctx context.Context, provider, messageID string, job *DeliveryJob, ttl time.Duration, keepJob bool) error {
+	var payload []byte
+	if keepJob {
+		var err error
+		if payload, err = encodeDeliveryJob(job); err != nil {
+			return err
+		}
+	}
+
+	key := r.smsMessageKey(provider, messageID)
+	now := time.Now().UnixMilli()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"delivery_id", job.ID,
+			"region", job.Region,
+			"status", "sent",
+			"sent_at", now,
+			"updated_at", now)
+		pipe.PExpire(ctx, key, ttl)
+		pipe.HSet(ctx, r.deliveryStatusKey(job.ID), "provider", provider, "message_id", messageID)
+		if keepJob {
+			pipe.Set(ctx, r.deliveryJobKey(job.ID), payload, 0)
+			pipe.PExpireAt(ctx, r.deliveryJobKey(job.ID), job.ExpiresAt)
+		}
+		return nil
+	})
+	return err
+}
+
+// UpdateSMSMessage applies a delivery receipt to a tracked message and
+// returns the message, or nil if it is unknown. final is true only for the
+// first receipt with a final status.
+func (r *RedisClient) UpdateSMSMessage(ctx context.Context, provider, messageID, status string, isFinal bool, carrier, errorCode string) (msg *SMSMessage, final bool, err error) {
+	key := r.smsMessageKey(provider, messageID)
+	finalArg := "0"
+	if isFinal {
+		finalArg = "1"
+	}
+
+	result, err := updateSMSMessageScript.Run(ctx, r.client, []string{key},
+		status, finalArg, time.Now().UnixMilli(), carrier, errorCode).Int()
+	if err != nil {
+		return nil, false, err
+	}
+	if result < 0 {
+		return nil, false, nil
+	}
+
+	fields, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	msg = &SMSMessage{
+		Provider:   provider,
+		MessageID:  messageID,
+		DeliveryID: fields["delivery_id"],
+		Region:     fields["region"],
+		Status:     fields["status"],
+		Carrier:    fields["carrier"],
+		ErrorCode:  fields["error_code"],
+		SentAt:     unixMilliField(fields["sent_at"]),
+		UpdatedAt:  unixMilliField(fields["updated_at"]),
+	}
+
+	if err := r.annotateDelivery(ctx, msg.DeliveryID, "receipt_status", msg.Status); err != nil {
+		return nil, false, err
+	}
+	return msg, result == 1, nil
+}
+
+// TakeDeliveryJob returns and forgets the job kept by TrackSMSMessage, or
+// nil if it was not kept or the code has expired
+func (r *RedisClient) TakeDeliveryJob(ctx context.Context, deliveryID string) (*DeliveryJob, error) {
+	payload, err := r.client.GetDel(ctx, r.deliveryJobKey(deliveryID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := decodeDeliveryJob(payload)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// SetDeliveryFallback links a delivery to the one that resent its code
+func (r *RedisClient) SetDeliveryFallback(ctx context.Context, deliveryID, fallbackID string) error {
+	return r.annotateDelivery(ctx, deliveryID, "fallback_id", fallbackID)
+}
+
+func (r *RedisClient) annotateDelivery(ctx context.Context, deliveryID string, fieldValues ...interface{}) error {
+	return annotateDeliveryScript.Run(ctx, r.client, []string{r.deliveryStatusKey(deliveryID)}, fieldValues...).Err()
+}
+
+func (r *RedisClient) smsMessageKey(provider, messageID string) string {
+	return fmt.Sprintf("%ssms:message:%s:%s", r.config.Redis.KeyPrefix, provider, messageID)
+}
+
+func (r *RedisClient) deliveryJobKey(id string) string {
+	return fmt.Sprintf("%sdelivery:job:%s", r.config.Redis.KeyPrefix, id)
+}
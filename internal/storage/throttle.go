@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SendLimits are the caps applied to outgoing OTP messages. A zero value disables that cap.
+type SendLimits struct {
+	ResendCooldown  time.Duration
+	DailyPerNumber  int
+	DailyPerCountry int
+	GlobalPerMinute int
+}
+
+// SendLimitResult reports which limit, if any, blocked a send
+type SendLimitResult struct {
+	// Limit is empty when the send is allowed, otherwise one of
+	// "cooldown", "number_daily", "country_daily" or "global"
+	Limit      string
+	RetryAfter time.Duration
+}
+
+// sendLimitScript checks every send limit and, only if all pass, records the
+// send against each of them.
+//
+// KEYS[1] cooldown marker, KEYS[2] number daily counter, KEYS[3] country
+// daily counter, KEYS[4] global per-minute counter
+// ARGV[1] cooldown (ms), ARGV[2] number cap, ARGV[3] country cap, ARGV[4] global cap
+var sendLimitScript = redis.NewScript(`
+if tonumber(ARGV[1]) > 0 then
+	local ttl = redis.call('PTTL', KEYS[1])
+	if ttl > 0 then
+		return {'cooldown', ttl}
+	end
+end
+local limits = {
+	{KEYS[2], tonumber(ARGV[2]), 'number_daily', 86400000},
+	{KEYS[3], tonumber(ARGV[3]), 'country_daily', 86400000},
+	{KEYS[4], tonumber(ARGV[4]), 'global', 60000},
+}
+for _, l in ipairs(limits) do
+	if l[2] > 0 and tonumber(redis.call('GET', l[1]) or '0') >= l[2] then
+		return {l[3], redis.call('PTTL', l[1])}
+	end
+end
+if tonumber(ARGV[1]) > 0 then
+	redis.call('SET', KEYS[1], '1', 'PX', ARGV[1])
+end
+for _, l in ipairs(limits) do
+	if l[2] > 0 and redis.call('INCR', l[1]) == 1 then
+		redis.call('PEXPIRE', l[1], l[4])
+	end
+end
+return {'', 0}
+`)
+
+// prefixHistoryTTL is how long a destination prefix's send history is kept
+// after its last send. Quiet prefixes keep their history, so a burst after
+// a quiet spell is compared against their quiet baseline.
+const prefixHistoryTTL = 7 * 24 * time.Hour
+
+// PrefixRule decides when sends to a destination prefix look like pumping
+type PrefixRule struct {
+	// PerMinute blocks the prefix once a minute reaches this many sends
+	PerMinute int
+	// SpikeMinCount and SpikeFactor block the prefix when a minute has at
+	// least SpikeMinCount sends and more than SpikeFactor times the prefix's
+	// average per-minute volume over the preceding Window. Spikes are only
+	// detected once the prefix has MinHistory of history.
+	SpikeMinCount int
+	SpikeFactor   float64
+	Window        time.Duration
+	MinHistory    time.Duration
+	BlockFor      time.Duration
+}
+
+// recordPrefixScript counts a send towards a destination prefix in
+// per-minute buckets and blocks the prefix when the current minute breaks
+// its rule. Buckets older than the window are dropped as it slides.
+//
+// KEYS[1] history hash, KEYS[2] block marker
+// ARGV[1] current minute, ARGV[2] per-minute cap, ARGV[3] spike min count,
+// ARGV[4] spike factor, ARGV[5] window (minutes), ARGV[6] min history
+// (minutes), ARGV[7] block duration (ms), ARGV[8] history TTL (ms)
+var recordPrefixScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[5])
+local since = tonumber(redis.call('HGET', KEYS[1], 'since') or now)
+local fields = redis.call('HGETALL', KEYS[1])
+local total = 0
+for i = 1, #fields, 2 do
+	if fields[i] ~= 'since' then
+		local minute = tonumber(fields[i])
+		if minute < now - window then
+			redis.call('HDEL', KEYS[1], fields[i])
+		elseif minute < now then
+			total = total + tonumber(fields[i + 1])
+		end
+	end
+end
+local current = redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+redis.call('HSETNX', KEYS[1], 'since', since)
+redis.call('PEXPIRE', KEYS[1], ARGV[8])
+
+local reason = ''
+local perMinute = tonumber(ARGV[2])
+local minCount = tonumber(ARGV[3])
+local factor = tonumber(ARGV[4])
+if perMinute > 0 and current >= perMinute then
+	reason = 'high_risk'
+elseif minCount > 0 and factor > 0 and current >= minCount then
+	local history = math.min(now - since, window)
+	if history >= tonumber(ARGV[6]) and history > 0 and current > factor * total / history then
+		reason = 'spike'
+	end
+end
+if reason ~= '' then
+	redis.call('SET', KEYS[2], '1', 'PX', ARGV[7])
+end
+return reason
+`)
+
+// CheckSendLimits applies limits to a send to phone in country and records it if allowed
+func (r *RedisClient) CheckSendLimits(ctx context.Context, phone, country string, limits SendLimits) (*SendLimitResult, error) {
+	keys := []string{
+		fmt.Sprintf("%sthrottle:cooldown:%s", r.config.Redis.KeyPrefix, phone),
+		fmt.Sprintf("%sthrottle:number:%s", r.config.Redis.KeyPrefix, phone),
+		fmt.Sprintf("%sthrottle:country:%s", r.config.Redis.KeyPrefix, country),
+		fmt.Sprintf("%sthrottle:global", r.config.Redis.KeyPrefix),
+	}
+
+	res, err := sendLimitScript.Run(ctx, r.client, keys,
+		limits.ResendCooldown.Milliseconds(), limits.DailyPerNumber, limits.DailyPerCountry, limits.GlobalPerMinute).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2 {
+		return nil, fmt.Errorf("unexpected send limit script result: %v", res)
+	}
+
+	limit, _ := res[0].(string)
+	retryMs, _ := res[1].(int64)
+	return &SendLimitResult{
+		Limit:      limit,
+		RetryAfter: time.Duration(retryMs) * time.Millisecond,
+	}, nil
+}
+
+// RecordPrefixSend counts an allowed send to prefix and blocks the prefix
+// for rule.BlockFor if the volume breaks rule. It returns why the prefix was
+// blocked, "high_risk" or "spike", or "" if it was not.
+func (r *RedisClient) RecordPrefixSend(ctx context.Context, prefix string, rule PrefixRule) (string, error) {
+	return r.recordPrefixSend(ctx, prefix, rule, time.Now())
+}
+
+func (r *RedisClient) recordPrefixSend(ctx context.Context, prefix string, rule PrefixRule, now time.Time) (string, error) {
+	keys := []string{
+		fmt.Sprintf("%spumping:history:%s", r.config.Redis.KeyPrefix, prefix),
+		r.blockedPrefixKey(prefix),
+	}
+
+	minute := int64(time.Minute / time.Millisecond)
+	return recordPrefixScript.Run(ctx, r.client, keys,
+		now.UnixMilli()/minute, rule.PerMinute, rule.SpikeMinCount, rule.SpikeFactor,
+		int64(rule.Window/time.Minute), int64(rule.MinHistory/time.Minute),
+		rule.BlockFor.Milliseconds(), prefixHistoryTTL.Milliseconds(),
+	).Text()
+}
+
+// GetBlockedPrefix returns the first of prefixes that is currently blocked
+// and how long it remains blocked
+func (r *RedisClient) GetBlockedPrefix(ctx context.Context, prefixes []string) (string, time.Duration, error) {
+	for _, prefix := range prefixes {
+		ttl, err := r.client.PTTL(ctx, r.blockedPrefixKey(prefix)).Result()
+		if err != nil {
+			return "", 0, err
+		}
+		if ttl > 0 {
+			return prefix, ttl, nil
+		}
+	}
+	return "", 0, nil
+}
+
+func (r *RedisClient) blockedPrefixKey(prefix string) string {
+	return fmt.Sprintf("%spumping:blocked:%s", r.config.Redis.KeyPrefix, prefix)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var spikeRule = PrefixRule{
+	SpikeMinCount: 20,
+	SpikeFactor:   5,
+	Window:        time.Hour,
+	MinHistory:    30 * time.Minute,
+	BlockFor:      time.Hour,
+}
+
+// sendMinute records count sends to prefix during the minute starting at
+// start and returns the reason the last send blocked the prefix for, if any
+func sendMinute(t *testing.T, r *RedisClient, prefix string, rule PrefixRule, start time.Time, count int) string {
+	t.Helper()
+	var reason string
+	for i := 0; i < count; i++ {
+		got, err := r.recordPrefixSend(context.Background(), prefix, rule, start.Add(time.Duration(i)*time.Millisecond))
+		if err != nil {
+			t.Fatalf("recordPrefixSend: %v", err)
+		}
+		if got != "" {
+			reason = got
+		}
+	}
+	return reason
+}
+
+func isBlocked(t *testing.T, r *RedisClient, prefix string) bool {
+	t.Helper()
+	blocked, _, err := r.GetBlockedPrefix(context.Background(), []string{prefix})
+	if err != nil {
+		t.Fatalf("GetBlockedPrefix: %v", err)
+	}
+	return blocked != ""
+}
+
+func TestRecordPrefixSendColdStart(t *testing.T) {
+	r, _ := newTestClient(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// A first burst has no history to be compared with
+	if reason := sendMinute(t, r, "+1", spikeRule, start, 100); reason != "" {
+		t.Fatalf("cold start blocked the prefix: %s", reason)
+	}
+	if isBlocked(t, r, "+1") {
+		t.Fatal("cold start blocked the prefix")
+	}
+}
+
+func TestRecordPrefixSendSteadyTraffic(t *testing.T) {
+	r, _ := newTestClient(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Two hours of steady traffic well above the minimum count, crossing
+	// the point where a fixed hourly counter would have reset
+	for m := 0; m < 120; m++ {
+		if reason := sendMinute(t, r, "+1", spikeRule, start.Add(time.Duration(m)*time.Minute), 40); reason != "" {
+			t.Fatalf("steady traffic blocked the prefix in minute %d: %s", m, reason)
+		}
+	}
+}
+
+func TestRecordPrefixSendSpike(t *testing.T) {
+	r, _ := newTestClient(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for m := 0; m < 45; m++ {
+		sendMinute(t, r, "+881", spikeRule, start.Add(time.Duration(m)*time.Minute), 2)
+	}
+	if isBlocked(t, r, "+881") {
+		t.Fatal("baseline traffic blocked the prefix")
+	}
+
+	reason := sendMinute(t, r, "+881", spikeRule, start.Add(45*time.Minute), 30)
+	if reason != "spike" {
+		t.Fatalf("reason = %q, want spike", reason)
+	}
+	if !isBlocked(t, r, "+881") {
+		t.Fatal("spike did not block the prefix")
+	}
+}
+
+func TestRecordPrefixSendQuietPrefixSpike(t *testing.T) {
+	r, _ := newTestClient(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// One send, then nothing for longer than the window
+	sendMinute(t, r, "+979", spikeRule, start, 1)
+	if reason := sendMinute(t, r, "+979", spikeRule, start.Add(3*time.Hour), 20); reason != "spike" {
+		t.Fatalf("reason = %q, want spike", reason)
+	}
+}
+
+func TestRecordPrefixSendPerMinuteCap(t *testing.T) {
+	r, _ := newTestClient(t)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rule := spikeRule
+	rule.PerMinute = 3
+	if reason := sendMinute(t, r, "+882", rule, start, 2); reason != "" {
+		t.Fatalf("reason = %q below the cap", reason)
+	}
+	// The cap applies without history
+	if reason := sendMinute(t, r, "+882", rule, start.Add(time.Second), 1); reason != "high_risk" {
+		t.Fatalf("reason = %q, want high_risk", reason)
+	}
+}
+
+func TestCheckSendLimitsWithoutCooldown(t *testing.T) {
+	r, _ := newTestClient(t)
+	ctx := context.Background()
+	limits := SendLimits{ResendCooldown: time.Minute, DailyPerNumber: 2}
+
+	if res, err := r.CheckSendLimits(ctx, "+15550100", "+1", limits); err != nil || res.Limit != "" {
+		t.Fatalf("first send = %v, %v, want allowed", res, err)
+	}
+	if res, err := r.CheckSendLimits(ctx, "+15550100", "+1", limits); err != nil || res.Limit != "cooldown" {
+		t.Fatalf("second send = %v, %v, want cooldown", res, err)
+	}
+
+	// A resend skips the cooldown but still counts towards the daily cap
+	limits.ResendCooldown = 0
+	if res, err := r.CheckSendLimits(ctx, "+15550100", "+1", limits); err != nil || res.Limit != "" {
+		t.Fatalf("resend = %v, %v, want allowed", res, err)
+	}
+	if res, err := r.CheckSendLimits(ctx, "+15550100", "+1", limits); err != nil || res.Limit != "number_daily" {
+		t.Fatalf("resend over the cap = %v, %v, want number_daily", res, err)
+	}
+}
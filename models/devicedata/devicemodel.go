@@ -0,0 +1,27 @@
+package devicedata
+
+import "time"
+
+// Device verification actions
+const (
+	ActionApprove = "approve"
+	ActionDeny    = "deny"
+)
+
+type DeviceResponse struct {
+	Status     string    `json:"status"`
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name,omitempty"`
+	Scope      string    `json:"scope"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type VerifyDeviceRequest struct {
+	UserCode string `json:"user_code"`
+	Action   string `json:"action"`
+}
+
+type VerifyDeviceResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
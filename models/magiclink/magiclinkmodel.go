@@ -0,0 +1,26 @@
+package magiclink
+
+import "time"
+
+type SendMagicLinkRequest struct {
+	Phone string `json:"phone,omitempty"`
+	Email string `json:"email,omitempty"`
+	// Locale selects the SMS language, e.g. "es" or "pt-BR". Defaults to the
+	// request's Accept-Language header.
+	Locale string `json:"locale,omitempty"`
+}
+
+type SendMagicLinkResponse struct {
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	Channel   string    `json:"channel"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// DeliveryID identifies the queued message; poll
+	// /api/v1/deliveries/{id} for its status
+	DeliveryID string `json:"delivery_id,omitempty"`
+}
+
+type MagicLinkResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
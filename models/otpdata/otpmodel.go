@@ -1,12 +1,71 @@
 package otpdata
 
+import "time"
+
+// Delivery channels for an OTP
+const (
+	ChannelSMS      = "sms"
+	ChannelVoice    = "voice"
+	ChannelWhatsApp = "whatsapp"
+	ChannelTelegram = "telegram"
+	ChannelEmail    = "email"
+)
+
 type SendOtpResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
-	Phone   string `json:"phone"`
-	Hash    string `json:"hash"`
+	Status      string    `json:"status"`
+	Message     string    `json:"message"`
+	Channel     string    `json:"channel"`
+	Phone       string    `json:"phone,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	ChallengeID string    `json:"challenge_id"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// DeliveryID identifies the queued message; poll
+	// /api/v1/deliveries/{id} for its status
+	DeliveryID string `json:"delivery_id,omitempty"`
 }
 
 type SendOtpRequest struct {
-	Phone string `json:"phone"`
+	Phone string `json:"phone,omitempty"`
+	Email string `json:"email,omitempty"`
+	// Locale selects the SMS language, e.g. "es" or "pt-BR". Defaults to the
+	// request's Accept-Language header.
+	Locale string `json:"locale,omitempty"`
+	// ClientApp names an entry of sms.client_apps whose WebOTP and Android
+	// SMS Retriever lines are added to the message
+	ClientApp string `json:"client_app,omitempty"`
+	// Channel selects "voice" to have a phone number called instead of
+	// texted. Defaults to sms for phone numbers and email for addresses.
+	Channel string `json:"channel,omitempty"`
+}
+
+type ChannelPreferenceRequest struct {
+	// Channel is sms, voice, whatsapp or telegram; empty clears the preference
+	Channel string `json:"channel"`
+}
+
+type ChannelPreferenceResponse struct {
+	Status  string `json:"status"`
+	Channel string `json:"channel,omitempty"`
+	// AvailableChannels lists the channels that can be chosen
+	AvailableChannels []string `json:"available_channels"`
+}
+
+// DeliveryStatusResponse reports the progress of a queued OTP message
+type DeliveryStatusResponse struct {
+	DeliveryID  string     `json:"delivery_id"`
+	Channel     string     `json:"channel"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	// ReceiptStatus is the handset delivery status reported by the SMS
+	// provider: sent, delivered or undelivered
+	ReceiptStatus string `json:"receipt_status,omitempty"`
+	// FallbackDeliveryID is the delivery that resent the code after this
+	// one was reported undelivered
+	FallbackDeliveryID string `json:"fallback_delivery_id,omitempty"`
+	// AlternativeChannels lists the channels a client can offer, such as
+	// "Call me instead", after an SMS failed
+	AlternativeChannels []string `json:"alternative_channels,omitempty"`
 }
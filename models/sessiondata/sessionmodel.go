@@ -0,0 +1,27 @@
+package sessiondata
+
+import "time"
+
+type Session struct {
+	ID          string    `json:"id"`
+	DeviceName  string    `json:"device_name,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	AuthMethods []string  `json:"auth_methods"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Current marks the session the request was made from
+	Current bool `json:"current"`
+}
+
+type SessionListResponse struct {
+	Status   string    `json:"status"`
+	Sessions []Session `json:"sessions"`
+}
+
+type RevokeSessionsResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Revoked int    `json:"revoked"`
+}
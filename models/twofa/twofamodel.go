@@ -6,10 +6,6 @@ type TwoFASettings struct {
 	SecretKey string `json:"secret_key,omitempty"`
 }
 
-type Enable2FARequest struct {
-	Phone string `json:"phone"`
-}
-
 type Enable2FAResponse struct {
 	Status    string `json:"status"`
 	Message   string `json:"message"`
@@ -18,8 +14,7 @@ type Enable2FAResponse struct {
 }
 
 type Verify2FARequest struct {
-	Phone string `json:"phone"`
-	Code  string `json:"code"`
+	Code string `json:"code"`
 }
 
 type Verify2FAResponse struct {
@@ -28,8 +23,7 @@ type Verify2FAResponse struct {
 }
 
 type Disable2FARequest struct {
-	Phone string `json:"phone"`
-	Code  string `json:"code"`
+	Code string `json:"code"`
 }
 
 type Disable2FAResponse struct {
@@ -1,9 +1,12 @@
 package verifydata
 
 type VerifyOtpRequest struct {
-	Phone string `json:"phone"`
-	Hash  string `json:"hash"`
-	Otp   string `json:"otp"`
+	Phone       string `json:"phone,omitempty"`
+	Email       string `json:"email,omitempty"`
+	ChallengeID string `json:"challenge_id"`
+	Otp         string `json:"otp"`
+	// DeviceName labels the session in the session list
+	DeviceName string `json:"device_name,omitempty"`
 }
 
 type VerifyOtpResponse struct {
@@ -1,15 +0,0 @@
-package utils
-
-import (
-	"strconv"
-	"time"
-)
-
-func MsToTime(ms string) (time.Time, error) {
-	msInt, err := strconv.ParseInt(ms, 10, 64)
-	if err != nil {
-		return time.Time{}, err
-	}
-
-	return time.Unix(0, msInt*int64(time.Millisecond)), nil
-}